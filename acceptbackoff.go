@@ -0,0 +1,75 @@
+// acceptbackoff.go
+
+package main
+
+import (
+	"errors"  //classifying errors
+	"syscall" //EMFILE & co.
+	"time"    //backoff
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Accept errors
+//    - When Accept fails (out of file descriptors, EMFILE/ENFILE, is
+//      the usual one) retrying at once just fails again, spinning a
+//      core and writing a log line per attempt. acceptLoop instead
+//      waits acceptBackoffMin, doubling with each failure in a row up
+//      to acceptBackoffMax, and goes back to full speed on the first
+//      connection accepted.
+//    - Every failure counts in accept_errors{kind:emfile|enfile|other}
+//      (see /metrics). The first failure of a run is logged as an
+//      ERROR to alert on, then at most one WARN per acceptLogEvery
+//      with the count so far, and an INFO once accepting works again.
+// ─────────────────────────────────────────────────────────────────
+
+const (
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = 1 * time.Second
+	acceptLogEvery   = 1 * time.Minute
+)
+
+// acceptBackoff is the state of one accept loop.
+type acceptBackoff struct {
+	delay    time.Duration //next wait, 0 while accepting works
+	failures int           //in a row
+	since    time.Time     //first failure of the run
+	logged   time.Time     //last log line about it
+}
+
+// failed records an Accept error and waits before the next attempt.
+func (b *acceptBackoff) failed(err error) {
+	kind := acceptErrorKind(err)
+	incCounter("accept_errors", []string{"kind:" + kind}, 1)
+	now := time.Now()
+	b.failures++
+	switch {
+	case b.failures == 1:
+		b.since, b.logged = now, now
+		logErrorf("server", "Accept failing (%s), backing off: %v", kind, err)
+	case now.Sub(b.logged) >= acceptLogEvery:
+		b.logged = now
+		logWarnf("server", "Accept still failing after %d errors in %s, waiting %s between attempts: %v",
+			b.failures, now.Sub(b.since).Round(time.Second), b.delay, err)
+	}
+	b.delay = min(max(2*b.delay, acceptBackoffMin), acceptBackoffMax)
+	time.Sleep(b.delay)
+}
+
+// succeeded ends a run of failures, if there was one.
+func (b *acceptBackoff) succeeded() {
+	if b.failures == 0 {
+		return
+	}
+	logInfof("server", "Accepting connections again after %d errors in %s", b.failures, time.Since(b.since).Round(time.Millisecond))
+	*b = acceptBackoff{}
+}
+
+func acceptErrorKind(err error) string {
+	switch {
+	case errors.Is(err, syscall.EMFILE):
+		return "emfile"
+	case errors.Is(err, syscall.ENFILE):
+		return "enfile"
+	}
+	return "other"
+}
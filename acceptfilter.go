@@ -0,0 +1,237 @@
+// acceptfilter.go
+
+package main
+
+import (
+	"encoding/json" //admin API responses
+	"fmt"           //errors
+	"net"           //IPs and CIDRs
+	"net/http"      //admin API handler
+	"sort"          //stable listing
+	"strings"       //CIDR detection
+	"sync"          //guarding the ban list
+	"time"          //ban expiry
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Accept filters
+//    - Run in the accept loop, right after Accept() and before we
+//      spawn a goroutine or read a single byte. A filter that says no
+//      gets the connection closed on the spot, no response at all.
+//    - They must be cheap: no DNS, no disk.
+// ─────────────────────────────────────────────────────────────────
+
+// acceptFilter returns "" to let the connection through, or a short
+// reason (used in logs/metrics) to drop it.
+type acceptFilter func(conn net.Conn, ip string) (reason string)
+
+var acceptFilters = []acceptFilter{
+	banListFilter,
+	connRateFilter,
+}
+
+// ConnRateLimit / ConnRateBurst: new connections per second a single IP
+// may open. Browsers open a handful; thousands is abuse.
+const (
+	ConnRateLimit = 30.0
+	ConnRateBurst = 60.0
+)
+
+// BannedIPs are banned from startup on: single IPs or CIDRs
+// ("203.0.113.7", "198.51.100.0/24"). More can be added at runtime via
+// the admin API.
+var BannedIPs = []string{}
+
+// allowConnection runs every filter; false means the caller must close
+// the connection and move on.
+func allowConnection(conn net.Conn) bool {
+	ip := clientIP(conn.RemoteAddr().String())
+	for _, filter := range acceptFilters {
+		if reason := filter(conn, ip); reason != "" {
+			logDebugf("accept", "dropping connection from %s: %s", logAddr(ip), reason)
+			incCounter("connections_dropped", []string{"reason:" + reason}, 1)
+			return false
+		}
+	}
+	return true
+}
+
+func connRateFilter(conn net.Conn, ip string) string {
+	if soakRunning.Load() {
+		return "" //all soak load comes from one IP (see soak.go)
+	}
+	if ok, _ := takeToken("conn|"+ip, ConnRateLimit, ConnRateBurst); !ok {
+		return "conn_rate"
+	}
+	return ""
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Ban list
+//    - Exact IPs live in a map (optionally with an expiry), CIDRs in a
+//      short list that is checked one by one.
+// ─────────────────────────────────────────────────────────────────
+
+type ban struct {
+	network *net.IPNet
+	expires time.Time //zero = never
+	reason  string
+}
+
+var (
+	bansMu sync.RWMutex
+	bans   = make(map[string]ban) //keyed by the IP/CIDR as given
+)
+
+func init() {
+	adminMux.HandleFunc("/bans", handleBansAdmin)
+	registerJob("prune-bans", time.Minute, pruneExpiredBans)
+}
+
+// loadBannedIPs adds BannedIPs to the ban list, called once at startup.
+func loadBannedIPs() error {
+	for _, entry := range BannedIPs {
+		if err := addBan(entry, 0, "config"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBan bans an IP or CIDR, for ttl (0 = forever).
+func addBan(entry string, ttl time.Duration, reason string) error {
+	cidr := entry
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return fmt.Errorf("not an IP or CIDR: %q", entry)
+		}
+		if ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("not an IP or CIDR: %q", entry)
+	}
+	b := ban{network: network, reason: reason}
+	if ttl > 0 {
+		b.expires = time.Now().Add(ttl)
+	}
+	bansMu.Lock()
+	bans[entry] = b
+	bansMu.Unlock()
+	logInfof("accept", "Banned %s (%s)", entry, reason)
+	return nil
+}
+
+func removeBan(entry string) bool {
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	_, ok := bans[entry]
+	delete(bans, entry)
+	return ok
+}
+
+func banListFilter(conn net.Conn, ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	now := time.Now()
+	bansMu.RLock()
+	defer bansMu.RUnlock()
+	for _, b := range bans {
+		if !b.expires.IsZero() && now.After(b.expires) {
+			continue
+		}
+		if b.network.Contains(parsed) {
+			return "banned"
+		}
+	}
+	return ""
+}
+
+// pruneExpiredBans forgets bans whose time is up.
+func pruneExpiredBans() error {
+	now := time.Now()
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	for entry, b := range bans {
+		if !b.expires.IsZero() && now.After(b.expires) {
+			delete(bans, entry)
+		}
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Admin API: /bans
+//    GET    → list of bans
+//    POST   ip=<ip or cidr> [ttl=1h] [reason=...] → add a ban
+//    DELETE ip=<ip or cidr>                       → lift a ban
+// ─────────────────────────────────────────────────────────────────
+
+func handleBansAdmin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry := r.Form.Get("ip")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var ttl time.Duration
+		if s := r.Form.Get("ttl"); s != "" {
+			parsed, err := time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("bad ttl %q", s), http.StatusBadRequest)
+				return
+			}
+			ttl = parsed
+		}
+		reason := r.Form.Get("reason")
+		if reason == "" {
+			reason = "admin"
+		}
+		if err := addBan(entry, ttl, reason); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		broadcastBanChange("add", entry, ttl, reason)
+	case http.MethodDelete:
+		if !removeBan(entry) {
+			http.Error(w, "no such ban", http.StatusNotFound)
+			return
+		}
+		logInfof("accept", "Lifted ban on %s", entry)
+		broadcastBanChange("remove", entry, 0, "")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pruneExpiredBans()
+	type banJSON struct {
+		Entry   string `json:"entry"`
+		Reason  string `json:"reason"`
+		Expires string `json:"expires,omitempty"`
+	}
+	list := []banJSON{}
+	bansMu.RLock()
+	for entry, b := range bans {
+		j := banJSON{Entry: entry, Reason: b.reason}
+		if !b.expires.IsZero() {
+			j.Expires = b.expires.UTC().Format(time.RFC3339)
+		}
+		list = append(list, j)
+	}
+	bansMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Entry < list[j].Entry })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,83 @@
+// admin.go
+
+package main
+
+import (
+	"expvar"         //runtime + our own stats as JSON
+	"net"            //checking the admin address
+	"net/http"       //the admin listener is a plain net/http server
+	"net/http/pprof" //CPU/heap/goroutine profiles
+	"time"           //log timestamps
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Admin listener
+//    - A second, separate listener for operators only. It is never
+//      served on the public address, so profiles and internals can't
+//      leak to visitors.
+//    - /debug/pprof/ → profiles (go tool pprof http://127.0.0.1:9090/debug/pprof/profile)
+//    - /debug/vars   → expvar JSON (memstats, cmdline, helix counters)
+// ─────────────────────────────────────────────────────────────────
+
+// AdminListenAddr is where the admin listener binds. Keep it on loopback
+// (or a private interface); empty disables it.
+var AdminListenAddr = "127.0.0.1:9090"
+
+// adminMux holds every admin endpoint. Other files add theirs with
+// adminMux.HandleFunc in init().
+var adminMux = http.NewServeMux()
+
+func init() {
+	adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+	adminMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	adminMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	adminMux.Handle("/debug/vars", expvar.Handler())
+
+	//Our own numbers next to Go's memstats in /debug/vars
+	expvar.Publish("helix_active_connections", expvar.Func(func() any {
+		return activeConns.Load()
+	}))
+	expvar.Publish("helix_counters", expvar.Func(func() any {
+		out := make(map[string]int64)
+		for key, value := range counterSnapshot() {
+			name := key.name
+			if key.tags != "" {
+				name += "{" + key.tags + "}"
+			}
+			out[name] = value
+		}
+		return out
+	}))
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  startAdmin()
+//    - Binds AdminListenAddr and serves adminMux in the background.
+//    - A failure here is logged but doesn't stop the public server.
+// ─────────────────────────────────────────────────────────────────
+
+func startAdmin() {
+	if AdminListenAddr == "" {
+		return
+	}
+	if host, _, err := net.SplitHostPort(AdminListenAddr); err == nil {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			logWarnf("admin", "Admin listener on %s is not loopback only; make sure it is firewalled", AdminListenAddr)
+		}
+	}
+
+	listener, err := net.Listen("tcp", AdminListenAddr)
+	if err != nil {
+		logErrorf("admin", "Admin listener disabled, could not listen on %s: %v", AdminListenAddr, err)
+		return
+	}
+	logInfof("admin", "Admin listener on %s", AdminListenAddr)
+
+	server := &http.Server{
+		Handler:           adminMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+}
@@ -0,0 +1,43 @@
+// assets.go
+
+package main
+
+import (
+	"bytes"         //rendering the welcome page
+	"embed"         //assets compiled into the binary
+	"errors"        //error kinds
+	"html/template" //welcome + error page templates
+	"strings"       //query splitting
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Built-in assets
+//    - assets/ is compiled into the binary, so a fresh install (empty
+//      or missing ./public) still answers with styled pages:
+//        error.html    the error page when the docroot has none
+//                      (see errorpages.go)
+//        welcome.html  served at / while there is no index file
+//    - A real /index.html or /404.html in the docroot always wins.
+// ─────────────────────────────────────────────────────────────────
+
+//go:embed assets
+var assetFS embed.FS
+
+var welcomeTemplate = template.Must(template.ParseFS(assetFS, "assets/welcome.html"))
+
+// showsWelcome reports whether a static request that failed with err
+// gets the welcome page instead: GET / on a site without an index.
+func showsWelcome(req *request, err error) bool {
+	path, _, _ := strings.Cut(req.rawPath, "?")
+	return path == "/" && (errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden))
+}
+
+func welcomePage(site *siteSettings) []byte {
+	var buf bytes.Buffer
+	welcomeTemplate.Execute(&buf, struct {
+		Root    string
+		Index   string
+		Version string
+	}{site.root, site.index, version})
+	return buf.Bytes()
+}
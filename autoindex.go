@@ -0,0 +1,402 @@
+// autoindex.go
+
+package main
+
+import (
+	"bytes"         //rendering a page
+	"fmt"           //template errors
+	"html/template" //listing page
+	"io"            //README size limit
+	"mime"          //icons by type
+	"net/url"       //?page= / ?after= + escaping links
+	"os"            //reading directories
+	"path/filepath" //README path, extensions
+	"slices"        //copying entries for another order
+	"sort"          //entry order
+	"strconv"       //page numbers
+	"strings"       //paths
+	"sync"          //guarding the cache
+	"time"          //cache TTL, modification times
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Directory listings (autoindex)
+//    - With autoindex on, a directory without an index file gets a
+//      listing instead of a 403.
+//    - Listings are paginated, AutoindexPageSize entries a page, so a
+//      directory with 50 000 files doesn't turn into one huge page:
+//        ?page=N     N-th page (1-based)
+//        ?after=NAME the page starting after NAME; "next" links use
+//                    this so they stay right while files are added
+//    - The sorted entry list is cached per directory and reused while
+//      the directory's modification time is unchanged (adding,
+//      removing or renaming entries changes it). Sizes and dates of
+//      files changed in place refresh after AutoindexCacheTTL.
+// ─────────────────────────────────────────────────────────────────
+
+// Autoindex turns listings on for the whole document root.
+var Autoindex = false
+
+var (
+	AutoindexPageSize    = 500
+	AutoindexCacheTTL    = 30 * time.Second
+	AutoindexCacheMaxDir = 256 //directories kept in the cache
+)
+
+type listEntry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+// listingSorts are the ?sort= keys, each a "less" for ascending order.
+// Directories always come first.
+var listingSorts = map[string]func(a, b listEntry) bool{
+	"name": func(a, b listEntry) bool { return a.Name < b.Name },
+	"size": func(a, b listEntry) bool { return a.Size < b.Size || a.Size == b.Size && a.Name < b.Name },
+	"mtime": func(a, b listEntry) bool {
+		return a.ModTime.Before(b.ModTime) || a.ModTime.Equal(b.ModTime) && a.Name < b.Name
+	},
+}
+
+func listingLess(key string, desc bool) func(a, b listEntry) bool {
+	less := listingSorts[key]
+	return func(a, b listEntry) bool {
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	}
+}
+
+// Href is the entry's link, relative to the listing.
+func (e listEntry) Href() string {
+	href := (&url.URL{Path: e.Name}).EscapedPath()
+	if strings.Contains(e.Name, ":") {
+		href = "./" + href //don't let "a:b" read as a scheme
+	}
+	if e.IsDir {
+		href += "/"
+	}
+	return href
+}
+
+// HumanSize is e.g. "1.2 MiB", "-" for directories.
+func (e listEntry) HumanSize() string {
+	if e.IsDir {
+		return "-"
+	}
+	return humanBytes(e.Size)
+}
+
+// Icon is an emoji for the kind of file, going by its MIME type.
+func (e listEntry) Icon() string {
+	if e.IsDir {
+		return "📁"
+	}
+	ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(e.Name)))
+	switch {
+	case strings.HasPrefix(ctype, "image/"):
+		return "🖼️"
+	case strings.HasPrefix(ctype, "video/"):
+		return "🎞️"
+	case strings.HasPrefix(ctype, "audio/"):
+		return "🎵"
+	case strings.Contains(ctype, "zip"), strings.Contains(ctype, "tar"), strings.Contains(ctype, "compressed"):
+		return "📦"
+	case ctype == "application/pdf":
+		return "📕"
+	}
+	return "📄"
+}
+
+type dirListing struct {
+	modTime  time.Time //of the directory itself
+	read     time.Time
+	entries  []listEntry   //by name
+	readme   template.HTML //rendered README.md, if any
+	readmeAt string        //its local path
+
+	mu     sync.Mutex
+	sorted map[string][]listEntry //other orders, made on first use
+}
+
+// inOrder returns the entries sorted by key, cached.
+func (l *dirListing) inOrder(key string, desc bool) []listEntry {
+	if key == "name" && !desc {
+		return l.entries
+	}
+	cacheKey := key
+	if desc {
+		cacheKey += "-desc"
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if entries, ok := l.sorted[cacheKey]; ok {
+		return entries
+	}
+	entries := slices.Clone(l.entries)
+	less := listingLess(key, desc)
+	sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+	if l.sorted == nil {
+		l.sorted = make(map[string][]listEntry)
+	}
+	l.sorted[cacheKey] = entries
+	return entries
+}
+
+var (
+	dirListingsMu sync.Mutex
+	dirListings   = make(map[string]*dirListing) //by local path
+)
+
+// listDirectory returns the listing of dir, cached.
+func listDirectory(dir string, dirInfo os.FileInfo) (*dirListing, error) {
+	dirListingsMu.Lock()
+	l, ok := dirListings[dir]
+	dirListingsMu.Unlock()
+	if ok && l.modTime.Equal(dirInfo.ModTime()) && time.Since(l.read) < AutoindexCacheTTL {
+		incCounter("autoindex_cache", []string{"result:hit"}, 1)
+		return l, nil
+	}
+	incCounter("autoindex_cache", []string{"result:miss"}, 1)
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]listEntry, 0, len(des))
+	var readme string
+	for _, de := range des {
+		if strings.HasPrefix(de.Name(), ".") {
+			continue //dotfiles stay private
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue //removed meanwhile
+		}
+		if strings.EqualFold(de.Name(), "README.md") && info.Mode().IsRegular() {
+			readme = de.Name()
+		}
+		entries = append(entries, listEntry{Name: de.Name(), IsDir: de.IsDir(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	less := listingLess("name", false)
+	sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	l = &dirListing{modTime: dirInfo.ModTime(), read: time.Now(), entries: entries}
+	//Rendered whatever the setting; the page shows it if its site says so
+	if readme != "" {
+		if data, err := readLimited(filepath.Join(dir, readme), maxReadmeSize); err == nil {
+			l.readme, l.readmeAt = renderMarkdown(string(data)), filepath.Join(dir, readme)
+		}
+	}
+
+	dirListingsMu.Lock()
+	if len(dirListings) >= AutoindexCacheMaxDir {
+		clear(dirListings) //crude, but listings are cheap to rebuild
+	}
+	dirListings[dir] = l
+	dirListingsMu.Unlock()
+	return l, nil
+}
+
+// maxReadmeSize caps the README.md we render above a listing.
+const maxReadmeSize = 256 << 10
+
+func readLimited(path string, limit int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, limit))
+}
+
+func clearDirListings() {
+	dirListingsMu.Lock()
+	clear(dirListings)
+	dirListingsMu.Unlock()
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Listing templates
+//    - AutoindexTemplate names an html/template file used instead of
+//      the built-in page, so listings can match the site. It gets a
+//      listingPage:
+//        .Path .Crumbs (.Name .Href) .Readme .Total .Page .Pages
+//        .Prev .Next .Sort .Order (.SortHref "name"|"size"|"mtime")
+//        .Entries (.Name .IsDir .Size .ModTime .Href .HumanSize .Icon)
+//    - The template is parsed at startup and on reload; a broken one
+//      stops the server from starting (or the reload from applying).
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	AutoindexTemplate = ""   //path to a template file, "" = built-in
+	AutoindexReadme   = true //render README.md above the listing, read via siteSettings
+)
+
+// parsedListingTemplate is AutoindexTemplate, parsed by loadListingTemplate.
+var parsedListingTemplate = defaultListingTemplate
+
+func loadListingTemplate() error {
+	if AutoindexTemplate == "" {
+		parsedListingTemplate = defaultListingTemplate
+		return nil
+	}
+	tmpl, err := template.ParseFiles(AutoindexTemplate)
+	if err != nil {
+		return err
+	}
+	parsedListingTemplate = tmpl
+	return nil
+}
+
+type crumb struct {
+	Name string
+	Href string
+}
+
+type listingPage struct {
+	Path    string  //URL path of the directory, ending in "/"
+	Crumbs  []crumb //"/", "docs/", "api/" with their links
+	Readme  template.HTML
+	Entries []listEntry
+	Page    int
+	Pages   int
+	Total   int
+	Prev    string //link, "" on the first page
+	Next    string //link, "" on the last page
+	Sort    string //"name", "size" or "mtime"
+	Order   string //"asc" or "desc"
+}
+
+// SortHref links to the listing sorted by key, flipping the order if
+// it already is.
+func (p listingPage) SortHref(key string) string {
+	order := "asc"
+	if key == p.Sort && p.Order == "asc" {
+		order = "desc"
+	}
+	return "?sort=" + key + "&order=" + order
+}
+
+func breadcrumbs(urlPath string) []crumb {
+	crumbs := []crumb{{Name: "/", Href: "/"}}
+	href := "/"
+	for _, part := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		href += (&url.URL{Path: part}).EscapedPath() + "/"
+		crumbs = append(crumbs, crumb{Name: part + "/", Href: href})
+	}
+	return crumbs
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveAutoindex(w, req, urlPath, dir, info) error
+//    - ?sort=name|size|mtime and ?order=asc|desc pick the order;
+//      ?after= only works with the default (name, ascending), other
+//      orders page with ?page=.
+// ─────────────────────────────────────────────────────────────────
+
+func serveAutoindex(w ResponseWriter, req *request, urlPath, dir string, info os.FileInfo) error {
+	listing, err := listDirectory(dir, info)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(urlPath, "/") {
+		urlPath += "/"
+	}
+
+	query := req.query()
+	sortKey := query.Get("sort")
+	if _, ok := listingSorts[sortKey]; !ok {
+		sortKey = "name"
+	}
+	desc := query.Get("order") == "desc"
+	entries := listing.inOrder(sortKey, desc)
+	byName := sortKey == "name" && !desc
+
+	//Pick the page: ?after= wins over ?page=
+	size := max(AutoindexPageSize, 1)
+	start := 0
+	if after := query.Get("after"); after != "" && byName {
+		afterEntry := listEntry{Name: after, IsDir: query.Get("dir") == "1"}
+		less := listingLess("name", false)
+		start = sort.Search(len(entries), func(i int) bool { return less(afterEntry, entries[i]) })
+	} else if n, err := strconv.Atoi(query.Get("page")); err == nil && n > 1 {
+		//Past the last page before multiplying, so a huge ?page= can't wrap around
+		if n-1 > len(entries)/size {
+			start = len(entries)
+		} else {
+			start = (n - 1) * size
+		}
+	}
+	start = min(start, len(entries))
+	end := start + min(size, len(entries)-start)
+
+	page := listingPage{
+		Path:    urlPath,
+		Crumbs:  breadcrumbs(urlPath),
+		Entries: entries[start:end],
+		Page:    start/size + 1,
+		Pages:   max((len(entries)+size-1)/size, 1),
+		Total:   len(entries),
+		Sort:    sortKey,
+		Order:   "asc",
+	}
+	//Not one behind sidecar auth (see sidecar.go)
+	if req.site.readme && (listing.readmeAt == "" || !gated(listing.readmeAt)) {
+		page.Readme = listing.readme
+	}
+	if desc {
+		page.Order = "desc"
+	}
+	order := ""
+	if !byName {
+		order = "&sort=" + sortKey + "&order=" + page.Order
+	}
+	if start > 0 {
+		page.Prev = "?page=" + strconv.Itoa(max((start-1)/size+1, 1)) + order
+	}
+	if end < len(entries) {
+		if byName {
+			last := entries[end-1]
+			page.Next = "?after=" + url.QueryEscape(last.Name)
+			if last.IsDir {
+				page.Next += "&dir=1"
+			}
+		} else {
+			page.Next = "?page=" + strconv.Itoa(page.Page+1) + order
+		}
+		w.Header().Set("Link", "<"+escapePath(urlPath)+page.Next+">; rel=\"next\"")
+	}
+
+	var buf bytes.Buffer
+	if err := req.site.listing.Execute(&buf, page); err != nil {
+		return fmt.Errorf("autoindex template: %w", err)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeBody(w, 200, "text/html; charset=utf-8", buf.Bytes())
+	return nil
+}
+
+var defaultListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Index of {{.Path}}</title>
+<style>body{font-family:system-ui,sans-serif;margin:2em}td,th{padding:0 1em 0 0;text-align:left}td.n{text-align:right}.readme{max-width:50em;border-bottom:1px solid #ddd;margin-bottom:1em}</style></head>
+<body><h1>Index of {{range .Crumbs}}<a href="{{.Href}}">{{.Name}}</a>{{end}}</h1>
+{{if .Readme}}<div class="readme">{{.Readme}}</div>{{end}}
+<table>
+<tr><th><a href="{{.SortHref "name"}}">Name</a></th><th><a href="{{.SortHref "size"}}">Size</a></th><th><a href="{{.SortHref "mtime"}}">Modified</a></th></tr>
+{{if ne .Path "/"}}<tr><td>⬆️ <a href="../">../</a></td><td></td><td></td></tr>{{end}}
+{{range .Entries}}<tr><td>{{.Icon}} <a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="n">{{.HumanSize}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>
+<p>{{.Total}} entries{{if gt .Pages 1}}, page {{.Page}} of {{.Pages}}{{end}}
+{{if .Prev}} · <a href="{{.Prev}}">previous</a>{{end}}{{if .Next}} · <a href="{{.Next}}">next</a>{{end}}</p>
+</body></html>
+`))
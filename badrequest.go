@@ -0,0 +1,169 @@
+// badrequest.go
+
+package main
+
+import (
+	"bufio"   //bounded line reads
+	"errors"  //error kinds
+	"fmt"     //wrapping
+	"net"     //the connection
+	"strings" //parsing
+	"time"    //request timing
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Malformed requests
+//    - A request we can't parse gets a "400 Bad Request" (505 for an
+//      HTTP major version other than 1) with the usual error page and
+//      a line in the request log, then the connection is closed; we
+//      can't know where the next request would start.
+//    - Rejected: request lines that aren't "METHOD target HTTP/x.y",
+//      targets not starting with "/" (or "*") or with broken
+//      %-escapes, header lines without a colon, folded (indented)
+//      header lines, CR or NUL inside a value, a bad or missing Host
+//      (see host.go), body framing two parsers could read differently
+//      (see chunked.go), and anything over the limits below.
+//    - A client that just goes away or times out is closed silently,
+//      there is nobody to answer.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	MaxRequestLine = 8 << 10  //bytes
+	MaxHeaderBytes = 64 << 10 //all header lines together
+	MaxHeaderCount = 100
+)
+
+// ErrVersionNotSupported is for well formed but non-HTTP/1 requests.
+var ErrVersionNotSupported = errors.New("http version not supported")
+
+// readLine reads one line of at most limit bytes, without its CRLF.
+func readLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", fmt.Errorf("%w: line longer than %d bytes", ErrBadRequest, limit)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err //gone or timed out, nothing to answer
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// parseRequestLine splits and checks "GET /index.html HTTP/1.1".
+func parseRequestLine(line string) (method, target, version string, err error) {
+	parts := strings.Split(line, " ")
+	switch {
+	case len(parts) == 2 && strings.HasPrefix(parts[1], "/"):
+		return "", "", "", fmt.Errorf("%w: missing HTTP version", ErrBadRequest)
+	case len(parts) != 3:
+		return "", "", "", fmt.Errorf("%w: malformed request line", ErrBadRequest)
+	}
+	method, target, version = parts[0], parts[1], parts[2]
+
+	if !isToken(method) {
+		return "", "", "", fmt.Errorf("%w: bad method %q", ErrBadRequest, method)
+	}
+	if len(version) != 8 || !strings.HasPrefix(version, "HTTP/") || !isDigit(version[5]) || version[6] != '.' || !isDigit(version[7]) {
+		return "", "", "", fmt.Errorf("%w: bad HTTP version %q", ErrBadRequest, version)
+	}
+	if version[5] != '1' {
+		return "", "", "", fmt.Errorf("%w: %s", ErrVersionNotSupported, version)
+	}
+	if !strings.HasPrefix(target, "/") && target != "*" {
+		return "", "", "", fmt.Errorf("%w: bad request target %q", ErrBadRequest, target)
+	}
+	if err := checkPercentEncoding(target); err != nil {
+		return "", "", "", err
+	}
+	return method, target, version, nil
+}
+
+// checkPercentEncoding rejects "%" not followed by two hex digits and
+// control characters.
+func checkPercentEncoding(target string) error {
+	for i := 0; i < len(target); i++ {
+		switch c := target[i]; {
+		case c < 0x20 || c == 0x7f:
+			return fmt.Errorf("%w: control character in request target", ErrBadRequest)
+		case c == '%':
+			if i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2]) {
+				return fmt.Errorf("%w: bad percent-encoding in request target", ErrBadRequest)
+			}
+			i += 2
+		}
+	}
+	return nil
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveBadRequest(conn, reader, clientAddr, requestLine, header, err, start)
+//    - Answers a request that failed to parse and logs it like any
+//      other; the caller closes the connection afterwards.
+// ─────────────────────────────────────────────────────────────────
+
+// maxLoggedRequestLine keeps garbage from flooding the log.
+const maxLoggedRequestLine = 200
+
+func serveBadRequest(conn net.Conn, reader *bufio.Reader, clientAddr, requestLine string, header Header, err error, start time.Time) {
+	if len(requestLine) > maxLoggedRequestLine {
+		requestLine = requestLine[:maxLoggedRequestLine] + "…"
+	}
+	if header == nil {
+		header = make(Header)
+	}
+	host, _ := normalizeHost(header.Get("Host")) //a bad one gets the default site
+	req := &request{
+		id:          newRequestID(),
+		clientAddr:  clientAddr,
+		requestLine: requestLine,
+		method:      "GET",      //for the error page subrequest
+		version:     "HTTP/1.0", //unknown: frame it so any client can read it
+		header:      header,
+		host:        host,
+		body:        strings.NewReader(""),
+		site:        siteNow().siteFor(host),
+	}
+	req.timing.start, req.timing.parsed = start, time.Now()
+	logInfof("server", "Bad request from %s: %v", logAddr(clientAddr), err)
+
+	w := newResponseWriter(conn, reader, "", req.version)
+	w.Header().Set("X-Request-Id", req.id)
+	handleError(w, req, err)
+	req.timing.handled = time.Now()
+	w.finish()
+	req.timing.done = time.Now()
+
+	logRequest(req, w.status)
+	recordRequestMetrics(req, w.status, w.written, req.timing.done.Sub(start))
+}
+
+// isBadRequest tells parse errors worth answering from a client that
+// hung up or timed out.
+func isBadRequest(err error) bool {
+	return errors.Is(err, ErrBadRequest) || errors.Is(err, ErrVersionNotSupported)
+}
@@ -0,0 +1,122 @@
+// bench.go
+
+package main
+
+import (
+	"flag"        //subcommand flags
+	"fmt"         //the report
+	"io"          //draining bodies
+	"net/http"    //load client
+	"sort"        //percentiles
+	"sync"        //workers
+	"sync/atomic" //request budget
+	"time"        //durations
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  helix bench [-c 16] [-d 10s] [-n 0] URL
+//    - Sends GET requests to URL from -c parallel clients for -d (or
+//      until -n requests are done) and reports throughput, status
+//      codes and latency percentiles. Meant for a quick before/after
+//      number on a running server, not as a replacement for a proper
+//      load testing tool.
+//    - Mind the per-client rate limit (ratelimit.go) when benchmarking
+//      from a single address; most requests will be 429s otherwise.
+// ─────────────────────────────────────────────────────────────────
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	concurrency := fs.Int("c", 16, "parallel clients")
+	duration := fs.Duration("d", 10*time.Second, "how long to send requests")
+	total := fs.Int64("n", 0, "stop after this many requests (0 = run for -d)")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-request timeout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: helix bench [flags] URL")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *concurrency < 1 {
+		fs.Usage()
+		return 2
+	}
+	target := fs.Arg(0)
+
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency, DisableCompression: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		latencies []time.Duration
+		statuses  = make(map[string]int64)
+		bytes     int64
+		started   atomic.Int64
+		firstErr  atomic.Value //error text, to explain the "error" count
+	)
+	fmt.Printf("Benchmarking %s for %s with %d clients\n", target, *duration, *concurrency)
+	start := time.Now()
+	deadline := start.Add(*duration)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			localStatuses := make(map[string]int64)
+			var localBytes int64
+			for time.Now().Before(deadline) && (*total == 0 || started.Add(1) <= *total) {
+				t := time.Now()
+				resp, err := client.Get(target)
+				if err != nil {
+					localStatuses["error"]++
+					firstErr.CompareAndSwap(nil, err.Error())
+					continue
+				}
+				n, _ := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				local = append(local, time.Since(t))
+				localStatuses[fmt.Sprint(resp.StatusCode)]++
+				localBytes += n
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			for status, n := range localStatuses {
+				statuses[status] += n
+			}
+			bytes += localBytes
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	codes := make([]string, 0, len(statuses))
+	for status := range statuses {
+		codes = append(codes, status)
+	}
+	sort.Strings(codes)
+	fmt.Printf("Requests:  %d in %s (%.0f/s), %s received\n", len(latencies), elapsed.Round(time.Millisecond),
+		float64(len(latencies))/elapsed.Seconds(), humanBytes(bytes))
+	for _, status := range codes {
+		fmt.Printf("  %s: %d\n", status, statuses[status])
+	}
+	if err := firstErr.Load(); err != nil {
+		fmt.Printf("  first error: %s\n", err)
+	}
+	if len(latencies) == 0 {
+		fmt.Println("No request got an answer")
+		return 1
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(p float64) time.Duration {
+		return latencies[min(int(p*float64(len(latencies))), len(latencies)-1)]
+	}
+	fmt.Printf("Latency:   p50 %s  p90 %s  p99 %s  max %s\n", pct(0.50), pct(0.90), pct(0.99), latencies[len(latencies)-1])
+	return 0
+}
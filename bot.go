@@ -0,0 +1,276 @@
+// bot.go
+
+package main
+
+import (
+	"context" //lookup timeouts
+	"errors"  //telling "no such host" from a failed lookup
+	"fmt"     //formatting the log tag
+	"net"     //reverse/forward DNS lookups
+	"strings" //matching User-Agent tokens and hostnames
+	"sync"    //guarding the verification cache
+	"time"    //cache expiry
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Crawler definitions
+// ─────────────────────────────────────────────────────────────────
+
+// crawler describes a well known search engine bot: the token it puts in
+// its User-Agent and the DNS domains its reverse lookups must end in.
+type crawler struct {
+	name    string
+	uaToken string   //lowercase token searched for in the User-Agent
+	domains []string //allowed reverse DNS suffixes (with leading dot)
+}
+
+// knownCrawlers are the bots we are able to verify. Each of these vendors
+// documents reverse+forward DNS as the way to check their crawler is real.
+var knownCrawlers = []crawler{
+	{"Googlebot", "googlebot", []string{".googlebot.com", ".google.com", ".googleusercontent.com"}},
+	{"Bingbot", "bingbot", []string{".search.msn.com"}},
+	{"Applebot", "applebot", []string{".applebot.apple.com"}},
+	{"YandexBot", "yandex", []string{".yandex.ru", ".yandex.net", ".yandex.com"}},
+	{"Baiduspider", "baiduspider", []string{".baidu.com", ".baidu.jp"}},
+}
+
+// genericBotTokens mark a User-Agent as automated even though we can't
+// verify who is behind it (scrapers, libraries, CLI tools).
+var genericBotTokens = []string{"bot", "crawler", "spider", "curl", "wget", "python-requests", "go-http-client", "scrapy"}
+
+// crawlerCacheTTL is how long a DNS verification result is remembered.
+// Lookups are slow, so we only want to do them once per IP every so often.
+const crawlerCacheTTL = 6 * time.Hour
+
+const (
+	crawlerLookupTimeout = 3 * time.Second //for the reverse + forward lookups together
+	crawlerRetryTTL      = time.Minute     //a lookup that failed (rather than said no) is tried again after this
+	maxCrawlerLookups    = 16              //verifications running at once, server-wide
+)
+
+// crawlerLookups holds a slot per verification in flight, so a flood
+// of fake Googlebots from many IPs can't park a goroutine per request
+// on DNS.
+var crawlerLookups = make(chan struct{}, maxCrawlerLookups)
+
+// ─────────────────────────────────────────────────────────────────
+//  clientTag
+//    - What we decided about a client, attached to every request.
+//    - String() is what ends up in the access log.
+// ─────────────────────────────────────────────────────────────────
+
+type clientKind int
+
+const (
+	clientHuman           clientKind = iota //nothing suspicious in the User-Agent
+	clientBot                               //self-declared bot we can't verify
+	clientVerifiedCrawler                   //known crawler, DNS checks passed
+	clientFakeCrawler                       //claims to be a known crawler, DNS says otherwise
+)
+
+type clientTag struct {
+	kind    clientKind
+	crawler string //name of the known crawler, if any
+	pending bool   //clientFakeCrawler until verifyClaim() has checked DNS
+}
+
+func (t clientTag) String() string {
+	switch t.kind {
+	case clientBot:
+		return "bot"
+	case clientVerifiedCrawler:
+		return fmt.Sprintf("crawler=%s verified", t.crawler)
+	case clientFakeCrawler:
+		return fmt.Sprintf("crawler=%s fake", t.crawler)
+	}
+	return ""
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  classifyClient(ip, userAgent string) clientTag
+//    - Looks for a known crawler token in the User-Agent; if found,
+//      uses the cached DNS verdict for the IP. Without one the claim
+//      counts as fake (pending) until verifyClaim() checks it, which
+//      the caller does only once the rate limit let the request in:
+//      an unchecked claim costs a fake crawler's budget first.
+//    - Otherwise falls back to a cheap "does this look like a bot" check.
+// ─────────────────────────────────────────────────────────────────
+
+func classifyClient(ip, userAgent string) clientTag {
+	ua := strings.ToLower(userAgent)
+	for _, c := range knownCrawlers {
+		if !strings.Contains(ua, c.uaToken) {
+			continue
+		}
+		verified, known := cachedCrawlerVerdict(ip, c)
+		switch {
+		case !known:
+			return clientTag{kind: clientFakeCrawler, crawler: c.name, pending: true}
+		case verified:
+			return clientTag{kind: clientVerifiedCrawler, crawler: c.name}
+		}
+		return clientTag{kind: clientFakeCrawler, crawler: c.name}
+	}
+	for _, token := range genericBotTokens {
+		if strings.Contains(ua, token) {
+			return clientTag{kind: clientBot}
+		}
+	}
+	return clientTag{kind: clientHuman}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  verifyCrawler(ip string, c crawler) bool
+//    - Reverse lookup: the IP must resolve to a host under one of the
+//      crawler's domains (e.g. crawl-66-249-66-1.googlebot.com).
+//    - Forward lookup: that host must resolve back to the same IP,
+//      otherwise anyone controlling their own PTR record could pass.
+// ─────────────────────────────────────────────────────────────────
+
+// verifyCrawler returns an error, and false, when DNS didn't answer
+// (as opposed to answering no) within crawlerLookupTimeout.
+func verifyCrawler(ip string, c crawler) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), crawlerLookupTimeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		return false, lookupFailure(err)
+	}
+	var failed error
+	for _, name := range names {
+		host := strings.TrimSuffix(strings.ToLower(name), ".")
+		if !hasAnySuffix(host, c.domains) {
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			failed = lookupFailure(err)
+			continue
+		}
+		for _, addr := range addrs {
+			if addr == ip {
+				return true, nil
+			}
+		}
+	}
+	return false, failed
+}
+
+// lookupFailure is err unless it is DNS saying there is no such name,
+// which is an answer.
+func lookupFailure(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil
+	}
+	return err
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Verification cache
+//    - Keyed by crawler name + IP so one IP pretending to be several
+//      crawlers doesn't reuse a result.
+// ─────────────────────────────────────────────────────────────────
+
+type crawlerVerdict struct {
+	verified bool
+	expires  time.Time
+}
+
+var (
+	crawlerCacheMu sync.Mutex
+	crawlerCache   = make(map[string]crawlerVerdict)
+)
+
+// cachedCrawlerVerdict returns the remembered verdict for ip claiming
+// to be c; known is false if there is none (or it expired).
+func cachedCrawlerVerdict(ip string, c crawler) (verified, known bool) {
+	crawlerCacheMu.Lock()
+	defer crawlerCacheMu.Unlock()
+	v, ok := crawlerCache[c.name+"|"+ip]
+	if !ok || time.Now().After(v.expires) {
+		return false, false
+	}
+	return v.verified, true
+}
+
+// verifyClaim checks a pending crawler claim with DNS and returns the
+// tag it earns; any other tag comes back as it is. With
+// maxCrawlerLookups already running the claim stays fake for this
+// request and is checked on a later one.
+func verifyClaim(ip string, tag clientTag) clientTag {
+	if !tag.pending {
+		return tag
+	}
+	tag.pending = false
+	select {
+	case crawlerLookups <- struct{}{}:
+		defer func() { <-crawlerLookups }()
+	default:
+		incCounter("crawler_lookups", []string{"result:busy"}, 1)
+		return tag
+	}
+	var c crawler
+	for _, known := range knownCrawlers {
+		if known.name == tag.crawler {
+			c = known
+		}
+	}
+
+	//Do the (slow) DNS work without holding the lock
+	verified, err := verifyCrawler(ip, c)
+	ttl := crawlerCacheTTL
+	if err != nil {
+		logDebugf("bot", "Could not verify %s as %s: %v", logAddr(ip), c.name, err)
+		ttl = crawlerRetryTTL
+	} else {
+		logDebugf("bot", "%s claims to be %s, verified=%v", logAddr(ip), c.name, verified)
+	}
+
+	crawlerCacheMu.Lock()
+	crawlerCache[c.name+"|"+ip] = crawlerVerdict{verified: verified, expires: time.Now().Add(ttl)}
+	crawlerCacheMu.Unlock()
+	if verified {
+		tag.kind = clientVerifiedCrawler
+	}
+	return tag
+}
+
+func init() {
+	registerJob("prune-crawler-cache", time.Hour, pruneCrawlerCache)
+}
+
+// pruneCrawlerCache forgets expired verdicts.
+func pruneCrawlerCache() error {
+	now := time.Now()
+	crawlerCacheMu.Lock()
+	defer crawlerCacheMu.Unlock()
+	for key, v := range crawlerCache {
+		if now.After(v.expires) {
+			delete(crawlerCache, key)
+		}
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  clientIP(clientAddr string) string
+//    - "127.0.0.1:51748" → "127.0.0.1", "[::1]:51748" → "::1"
+// ─────────────────────────────────────────────────────────────────
+
+func clientIP(clientAddr string) string {
+	host, _, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		return clientAddr
+	}
+	return host
+}
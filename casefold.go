@@ -0,0 +1,84 @@
+// casefold.go
+
+package main
+
+import (
+	"net/url"       //escaping the redirect
+	"os"            //reading directories
+	"path/filepath" //local paths
+	"strings"       //folding
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Case-insensitive paths (case_insensitive: true, per site)
+//    - For content moved over from Windows/IIS, where /Docs/Setup.HTM
+//      and /docs/setup.htm were the same page and links mix both.
+//    - Only kicks in when the path doesn't exist as asked: each
+//      segment is looked up in its directory ignoring case (and
+//      trailing dots, which Windows drops too), and the client is
+//      redirected (301) to the spelling on disk, so every page keeps
+//      one URL and caches don't fill up with variants.
+//    - A segment matching several names (Readme.txt and README.txt)
+//      is ambiguous and stays a 404.
+//    - Costs a directory read per segment on a miss, so 404s get a
+//      bit more expensive with it on.
+// ─────────────────────────────────────────────────────────────────
+
+var CaseInsensitivePaths = false
+
+// caseFoldPath finds the on-disk spelling of cleanPath under root.
+func caseFoldPath(root, cleanPath string) (string, bool) {
+	dir := root
+	var found []string
+	for _, segment := range strings.Split(strings.Trim(cleanPath, "/"), "/") {
+		name, ok := foldedName(dir, segment)
+		if !ok {
+			return "", false
+		}
+		found = append(found, name)
+		dir = filepath.Join(dir, name)
+	}
+	canonical := "/" + strings.Join(found, "/")
+	return canonical, canonical != cleanPath
+}
+
+// foldedName picks the one entry of dir that segment means.
+func foldedName(dir, segment string) (string, bool) {
+	if _, err := os.Lstat(filepath.Join(dir, segment)); err == nil {
+		return segment, true
+	}
+	want := strings.TrimRight(segment, ".")
+	if want == "" {
+		return "", false
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return "", false
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+
+	match := ""
+	for _, name := range names {
+		if strings.EqualFold(name, want) {
+			if match != "" {
+				return "", false //ambiguous
+			}
+			match = name
+		}
+	}
+	return match, match != ""
+}
+
+// caseFoldRedirect is where a missing path is redirected to, if a
+// differently spelled one exists.
+func caseFoldRedirect(site *siteSettings, urlPath, cleanPath, rawQuery string) (string, bool) {
+	canonical, ok := caseFoldPath(site.root, cleanPath)
+	if !ok {
+		return "", false
+	}
+	if strings.HasSuffix(urlPath, "/") && canonical != "/" {
+		canonical += "/"
+	}
+	return withQuery((&url.URL{Path: canonical}).EscapedPath(), rawQuery), true
+}
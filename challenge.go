@@ -0,0 +1,269 @@
+// challenge.go
+
+package main
+
+import (
+	"crypto/hmac"     //signing nonces and pass cookies
+	"crypto/rand"     //per-process signing secret
+	"crypto/sha256"   //HMAC hash + proof-of-work check
+	"encoding/base64" //cookie/nonce encoding
+	"encoding/hex"    //proof-of-work digest
+	"encoding/json"   //hCaptcha verify response
+	"fmt"             //building pages and headers
+	"net/url"         //query parsing for the verify endpoint
+	"strconv"         //timestamps in nonces/cookies
+	"strings"         //cookie + path handling
+	"time"            //expiry checks
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Challenge settings
+// ─────────────────────────────────────────────────────────────────
+
+// ChallengeEnabled turns on the anti-bot challenge for suspicious clients.
+// Off by default because it gets in the way of legitimate CLI/API clients.
+var ChallengeEnabled = false
+
+// HCaptchaSiteKey / HCaptchaSecret switch the challenge from the built-in
+// JavaScript proof-of-work to an hCaptcha widget when both are set.
+var (
+	HCaptchaSiteKey = ""
+	HCaptchaSecret  = ""
+)
+
+// ChallengePath is where the challenge page sends its answer.
+const ChallengePath = "/__helix/challenge"
+
+// ChallengeDifficulty is the number of leading zero hex digits the
+// proof-of-work hash must have. 4 takes a browser well under a second.
+const ChallengeDifficulty = 4
+
+// ChallengeRateFraction: a client that has used up more than this share
+// of its rate limit bucket is considered suspicious and gets challenged.
+const ChallengeRateFraction = 0.5
+
+const (
+	passCookieName = "helix_pass"
+	passTTL        = 24 * time.Hour  //how long a solved challenge is honoured
+	nonceTTL       = 5 * time.Minute //how long a client has to solve one
+)
+
+// challengeSecret signs nonces and pass cookies. It is random per process,
+// so a restart simply means clients solve the challenge once more.
+var challengeSecret = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// ─────────────────────────────────────────────────────────────────
+//  handleChallenge(w, req) bool
+//    - Answers the verify endpoint (sets the pass cookie or blocks).
+//    - Serves the challenge page to suspicious clients without a pass.
+//    - Returns true if it wrote a response, in which case the caller
+//      must stop processing the request.
+// ─────────────────────────────────────────────────────────────────
+
+func handleChallenge(w ResponseWriter, req *request) bool {
+	ip := clientIP(req.clientAddr)
+	path := req.path()
+
+	if path == ChallengePath {
+		query := req.query()
+		next := safeRedirectTarget(query.Get("next"))
+		if !verifyChallengeAnswer(req, ip, query) {
+			serveErrorPage(w, req, 403)
+			return true
+		}
+		w.Header().Set("Location", next)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Set-Cookie", fmt.Sprintf("%s=%s; Path=/; Max-Age=%d; HttpOnly; SameSite=Lax",
+			passCookieName, signPass(ip, time.Now().Add(passTTL)), int(passTTL.Seconds())))
+		writeBody(w, 302, "text/html", nil)
+		return true
+	}
+
+	if !isSuspicious(req) || hasValidPass(req, ip) {
+		return false
+	}
+	logDebugf("challenge", "challenging %s (%s)", logAddr(ip), req.clientTag)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, 403, "text/html; charset=utf-8", []byte(challengePage(ip, req.rawPath)))
+	return true
+}
+
+// isSuspicious: clients pretending to be a known crawler, or hammering us
+// hard enough to have used up most of their rate limit bucket.
+func isSuspicious(req *request) bool {
+	if req.clientTag.kind == clientFakeCrawler {
+		return true
+	}
+	return nearRateLimit(clientIP(req.clientAddr), req.clientTag, ChallengeRateFraction)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Pass cookie: "<expiry unix>.<hmac(ip|expiry)>"
+//    - Bound to the client IP so a cookie can't be shared by a botnet.
+// ─────────────────────────────────────────────────────────────────
+
+func signPass(ip string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + sign("pass|"+ip+"|"+exp)
+}
+
+func hasValidPass(req *request, ip string) bool {
+	value := cookieValue(req.header.Get("Cookie"), passCookieName)
+	exp, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expUnix {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(sign("pass|"+ip+"|"+exp)))
+}
+
+// cookieValue pulls one cookie out of a "a=1; b=2" Cookie header.
+func cookieValue(header, name string) string {
+	for _, part := range strings.Split(header, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && k == name {
+			return v
+		}
+	}
+	return ""
+}
+
+func sign(msg string) string {
+	mac := hmac.New(sha256.New, challengeSecret)
+	mac.Write([]byte(msg))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  verifyChallengeAnswer(req, ip, query) bool
+//    - hCaptcha mode: asks the hCaptcha API whether the token is good.
+//    - Proof-of-work mode: checks our nonce is genuine, fresh, issued to
+//      this IP, and that sha256(nonce:counter) has enough leading zeros.
+// ─────────────────────────────────────────────────────────────────
+
+func verifyChallengeAnswer(req *request, ip string, query url.Values) bool {
+	if hcaptchaConfigured() {
+		return verifyHCaptcha(req, ip, query.Get("h-captcha-response"))
+	}
+
+	nonce, counter := query.Get("nonce"), query.Get("counter")
+	ts, sig, ok := strings.Cut(nonce, ".")
+	if !ok || counter == "" {
+		return false
+	}
+	issued, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > nonceTTL {
+		return false
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign("nonce|"+ip+"|"+ts))) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(nonce + ":" + counter))
+	return strings.HasPrefix(hex.EncodeToString(sum[:]), strings.Repeat("0", ChallengeDifficulty))
+}
+
+func hcaptchaConfigured() bool {
+	return HCaptchaSiteKey != "" && HCaptchaSecret != ""
+}
+
+func verifyHCaptcha(req *request, ip, token string) bool {
+	if token == "" {
+		return false
+	}
+	form := url.Values{
+		"secret":   {secret(HCaptchaSecret)},
+		"response": {token},
+		"remoteip": {ip},
+	}
+	//No retries: tokens are single-use
+	resp, err := outboundDo(outboundRequest{
+		target:      "hcaptcha",
+		method:      "POST",
+		url:         "https://api.hcaptcha.com/siteverify",
+		contentType: "application/x-www-form-urlencoded",
+		body:        []byte(form.Encode()),
+		requestID:   req.id,
+		timeout:     5 * time.Second,
+	})
+	if err != nil {
+		logErrorf("challenge", "hCaptcha verify failed: %v", err)
+		return false
+	}
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.Unmarshal(resp.body, &result); err != nil {
+		return false
+	}
+	return result.Success
+}
+
+// safeRedirectTarget only allows local paths so the verify endpoint
+// can't be abused as an open redirect ("//evil.com" is rejected too).
+func safeRedirectTarget(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\r\n\\") {
+		return "/"
+	}
+	return next
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  challengePage(ip, next string) string
+//    - The page shown instead of the requested resource.
+// ─────────────────────────────────────────────────────────────────
+
+func challengePage(ip, next string) string {
+	nextQ := url.QueryEscape(next)
+	if hcaptchaConfigured() {
+		return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+  <head><meta charset="utf-8"><title>Just a moment…</title>
+  <script src="https://js.hcaptcha.com/1/api.js" async defer></script></head>
+  <body>
+    <h1>Just a moment…</h1>
+    <p>Please confirm you are not a robot to continue.</p>
+    <div class="h-captcha" data-sitekey="%s" data-callback="helixPass"></div>
+    <script>
+      function helixPass(token) {
+        location.href = "%s?next=%s&h-captcha-response=" + encodeURIComponent(token);
+      }
+    </script>
+  </body>
+</html>`, HCaptchaSiteKey, ChallengePath, nextQ)
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := ts + "." + sign("nonce|"+ip+"|"+ts)
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+  <head><meta charset="utf-8"><title>Just a moment…</title></head>
+  <body>
+    <h1>Just a moment…</h1>
+    <p>Checking your browser. This page needs JavaScript enabled.</p>
+    <script>
+      (async function () {
+        const nonce = "%s", prefix = "0".repeat(%d);
+        for (let counter = 0; ; counter++) {
+          const data = new TextEncoder().encode(nonce + ":" + counter);
+          const hash = new Uint8Array(await crypto.subtle.digest("SHA-256", data));
+          const hex = Array.from(hash, b => b.toString(16).padStart(2, "0")).join("");
+          if (hex.startsWith(prefix)) {
+            location.href = "%s?next=%s&nonce=" + encodeURIComponent(nonce) + "&counter=" + counter;
+            return;
+          }
+        }
+      })();
+    </script>
+  </body>
+</html>`, nonce, ChallengeDifficulty, ChallengePath, nextQ)
+}
@@ -0,0 +1,201 @@
+// chaos.go
+
+package main
+
+import (
+	"encoding/json" //admin API
+	"fmt"           //config errors
+	"math/rand/v2"  //picking victims
+	"net/http"      //admin API
+	"sort"          //rule order
+	"strconv"       //config numbers
+	"strings"       //route matching
+	"sync/atomic"   //on/off switch
+	"time"          //injected latency
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Chaos mode
+//    - For testing clients against a misbehaving server: a share of
+//      the requests to a route get extra latency, a dropped connection
+//      or an error status instead of the real answer.
+//    - Rules come from the config, by path prefix:
+//          chaos:
+//            enabled: true
+//            rules:
+//              /api/:
+//                percent: 10
+//                latency: 2s
+//              /:
+//                percent: 1
+//                status: 503   (or drop: true)
+//      The longest matching prefix wins and applies to Percent% of
+//      its requests.
+//    - Off unless ChaosEnabled (or switched on at runtime through
+//      /chaos on the admin listener, which needs rules to switch on).
+//      Never leave it on in production.
+// ─────────────────────────────────────────────────────────────────
+
+type chaosRule struct {
+	Prefix  string        `json:"prefix"`            //e.g. "/api/", "/" for everything
+	Percent float64       `json:"percent"`           //0..100
+	Latency time.Duration `json:"latency,omitempty"` //added before answering
+	Drop    bool          `json:"drop,omitempty"`    //close the connection without answering
+	Status  int           `json:"status,omitempty"`  //answer with this error status instead
+}
+
+var (
+	ChaosEnabled = false
+	ChaosRules   = []chaosRule{}
+)
+
+var chaosOn atomic.Bool
+
+func init() {
+	adminMux.HandleFunc("/chaos", handleChaosAdmin)
+}
+
+// startChaos applies ChaosEnabled, called once at startup.
+func startChaos() {
+	chaosOn.Store(ChaosEnabled)
+	if ChaosEnabled {
+		logWarnf("chaos", "Chaos mode is on: %d rules", len(ChaosRules))
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  injectChaos(w, req) bool
+//    - Returns true when it answered (or dropped) the request itself.
+//    - Latency-only rules sleep and then let the request through.
+// ─────────────────────────────────────────────────────────────────
+
+func injectChaos(w ResponseWriter, req *request) bool {
+	if !chaosOn.Load() {
+		return false
+	}
+	path := req.path()
+	for _, rule := range ChaosRules {
+		if !strings.HasPrefix(path, rule.Prefix) {
+			continue
+		}
+		if rand.Float64()*100 >= rule.Percent {
+			return false
+		}
+		incCounter("chaos_injected", []string{"prefix:" + rule.Prefix}, 1)
+		if rule.Latency > 0 {
+			time.Sleep(rule.Latency)
+		}
+		switch {
+		case rule.Drop:
+			logInfof("chaos", "Dropping %s – %q", logAddr(req.clientAddr), req.requestLine)
+			if conn, _, err := w.Hijack(); err == nil {
+				conn.Close()
+			}
+			return true
+		case rule.Status >= 400:
+			w.Header().Set("X-Helix-Chaos", "1")
+			serveErrorPage(w, req, rule.Status)
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Admin API: /chaos
+//    GET            → on/off + rules
+//    POST enabled=1 → switch on (enabled=0 switches off)
+// ─────────────────────────────────────────────────────────────────
+
+func handleChaosAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		on := r.Form.Get("enabled") == "1"
+		if on && len(ChaosRules) == 0 {
+			http.Error(w, "no chaos.rules configured, nothing to inject", http.StatusConflict)
+			return
+		}
+		chaosOn.Store(on)
+		if on {
+			logWarnf("chaos", "Chaos mode switched on through the admin API")
+		} else {
+			logInfof("chaos", "Chaos mode switched off through the admin API")
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"enabled": chaosOn.Load(), "rules": ChaosRules})
+}
+
+// setChaosRules reads "chaos.rules": a mapping of path prefix to
+// percent, latency, drop and status.
+func setChaosRules(v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a mapping of path prefix to percent, latency, drop, status")
+	}
+	rules := make([]chaosRule, 0, len(m))
+	for prefix, item := range m {
+		fields, ok := item.(map[string]any)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("%s: expected a path prefix with percent, latency, drop, status", prefix)
+		}
+		rule := chaosRule{Prefix: prefix}
+		for name, fv := range fields {
+			s, err := configString(fv)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", prefix, name, err)
+			}
+			switch name {
+			case "percent":
+				p, err := strconv.ParseFloat(s, 64)
+				if err != nil || p < 0 || p > 100 {
+					return fmt.Errorf("%s.percent: expected 0 to 100, got %q", prefix, s)
+				}
+				rule.Percent = p
+			case "latency":
+				d, err := time.ParseDuration(s)
+				if err != nil || d < 0 {
+					return fmt.Errorf("%s.latency: expected a duration like 2s, got %q", prefix, s)
+				}
+				rule.Latency = d
+			case "drop":
+				b, err := strconv.ParseBool(s)
+				if err != nil {
+					return fmt.Errorf("%s.drop: expected true or false, got %q", prefix, s)
+				}
+				rule.Drop = b
+			case "status":
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 400 || n > 599 {
+					return fmt.Errorf("%s.status: expected an error status (400-599), got %q", prefix, s)
+				}
+				rule.Status = n
+			default:
+				return fmt.Errorf("%s: unknown setting %q", prefix, name)
+			}
+		}
+		if rule.Latency == 0 && !rule.Drop && rule.Status == 0 {
+			return fmt.Errorf("%s: needs latency, drop or status", prefix)
+		}
+		rules = append(rules, rule)
+	}
+	//Longest prefix first, so the most specific rule is the one that matches
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].Prefix) != len(rules[j].Prefix) {
+			return len(rules[i].Prefix) > len(rules[j].Prefix)
+		}
+		return rules[i].Prefix < rules[j].Prefix
+	})
+	ChaosRules = rules
+	return nil
+}
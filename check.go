@@ -0,0 +1,210 @@
+// check.go
+
+package main
+
+import (
+	"flag"          //same flags as the server
+	"fmt"           //report lines
+	"io"            //discarding log output
+	"log"           //logger for the loaders
+	"net"           //addresses + CIDRs
+	"net/url"       //upstream URLs
+	"os"            //paths
+	"os/exec"       //exec route commands
+	"path/filepath" //log dir parents
+	"sort"          //stable report
+	"strconv"       //port numbers
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  helix check [-config helix.yaml] [flags]
+//    - Loads the configuration exactly like the server would (defaults,
+//      file, HELIX_* variables, flags) and validates it without
+//      binding or serving anything: paths exist, addresses parse,
+//      CIDRs and URLs are well formed, the signing key and secret
+//      references load, exec commands are there.
+//    - Prints one line per check and exits 1 if any failed, so a
+//      deploy can run it before restarting a live server.
+//    - Reachability and free ports are the startup self-check's job
+//      (selfcheck.go); they depend on the moment, not the config.
+// ─────────────────────────────────────────────────────────────────
+
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	logWriter = log.New(io.Discard, "", 0) //the loaders log, we report
+
+	if err := loadSettings(fs); err != nil {
+		printCheck(checkResult{"config", checkFail, err.Error(), true})
+		return 1
+	}
+	source := "defaults"
+	if configFile != "" {
+		source = configFile
+	}
+	if configProfile != "" {
+		source = "profile " + configProfile + " + " + source
+	}
+
+	results := []checkResult{{"config", checkOK, source + " parsed", true}}
+	if err := checkFlags(); err != nil {
+		results = append(results, checkResult{"flags", checkFail, err.Error(), true})
+	} else {
+		results = append(results, checkDocroot()...)
+		results = append(results, checkErrorPages()...)
+	}
+	results = append(results, checkLogDir())
+	results = append(results, checkAddresses()...)
+	results = append(results, checkURLs()...)
+	results = append(results, checkCIDRs()...)
+	results = append(results, checkLoader("autoindex template", AutoindexTemplate != "", loadListingTemplate))
+	results = append(results, checkLoader("signing key", SigningKeyFile != "", loadSigningKey))
+	results = append(results, checkLoader("mirror GeoIP file", MirrorGeoIPFile != "", loadGeoIP))
+	results = append(results, checkLoader("secrets", true, loadSecrets))
+	results = append(results, checkExecRoutes()...)
+
+	failed := 0
+	for _, r := range results {
+		printCheck(r)
+		if r.status == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d problem(s) found\n", failed)
+		return 1
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
+func printCheck(r checkResult) {
+	fmt.Printf("%-5s %s: %s\n", r.status, r.name, r.detail)
+}
+
+// checkLogDir accepts a log directory that exists or can be created.
+func checkLogDir() checkResult {
+	dir, err := filepath.Abs(LogDir)
+	if err != nil {
+		return checkResult{"log dir", checkFail, err.Error(), true}
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return checkResult{"log dir", checkFail, dir + " is not a directory", true}
+			}
+			return checkResult{"log dir", checkOK, LogDir, true}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return checkResult{"log dir", checkFail, LogDir + " can't be created", true}
+		}
+		dir = parent
+	}
+}
+
+func checkAddresses() []checkResult {
+	addrs := map[string]string{"listen": ListenAddr}
+	if AdminListenAddr != "" {
+		addrs["admin.listen"] = AdminListenAddr
+	}
+	if StatsDAddr != "" {
+		addrs["statsd.addr"] = StatsDAddr
+	}
+	var results []checkResult
+	for _, name := range sortedKeys(addrs) {
+		addr := addrs[name]
+		_, port, err := net.SplitHostPort(addr)
+		if err == nil {
+			var n int
+			if n, err = strconv.Atoi(port); err == nil && (n < 0 || n > 65535) {
+				err = fmt.Errorf("port %d out of range", n)
+			}
+		}
+		if err != nil {
+			results = append(results, checkResult{name, checkFail, fmt.Sprintf("%q: %v", addr, err), true})
+			continue
+		}
+		results = append(results, checkResult{name, checkOK, addr, true})
+	}
+	return results
+}
+
+func checkURLs() []checkResult {
+	urls := map[string]string{}
+	if SyncFrom != "" {
+		urls["sync.from"] = SyncFrom
+	}
+	if SLOWebhookURL != "" {
+		urls["slo.webhook"] = SLOWebhookURL
+	}
+	for i, peer := range ClusterPeers {
+		urls[fmt.Sprintf("cluster.peers[%d]", i)] = peer
+	}
+	var results []checkResult
+	for _, name := range sortedKeys(urls) {
+		u, err := url.Parse(urls[name])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			results = append(results, checkResult{name, checkFail, fmt.Sprintf("%q is not an http(s) URL", urls[name]), true})
+			continue
+		}
+		results = append(results, checkResult{name, checkOK, urls[name], true})
+	}
+	return results
+}
+
+func checkCIDRs() []checkResult {
+	var results []checkResult
+	if err := loadBannedIPs(); err != nil {
+		results = append(results, checkResult{"bans", checkFail, err.Error(), true})
+	} else if len(BannedIPs) > 0 {
+		results = append(results, checkResult{"bans", checkOK, fmt.Sprintf("%d entries", len(BannedIPs)), true})
+	}
+	for _, cidr := range EchoAllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			results = append(results, checkResult{"echo.allowed", checkFail, fmt.Sprintf("%q is not a CIDR", cidr), true})
+		}
+	}
+	return results
+}
+
+// checkLoader runs one of the startup loaders, if its setting is used.
+func checkLoader(name string, used bool, load func() error) checkResult {
+	if !used {
+		return checkResult{name, checkOK, "not configured", false}
+	}
+	if err := load(); err != nil {
+		return checkResult{name, checkFail, err.Error(), true}
+	}
+	return checkResult{name, checkOK, "loaded", true}
+}
+
+func checkExecRoutes() []checkResult {
+	var results []checkResult
+	for _, path := range sortedKeys(ExecRoutes) {
+		route := ExecRoutes[path]
+		if len(route.Command) == 0 {
+			results = append(results, checkResult{"exec " + path, checkFail, "no command", true})
+			continue
+		}
+		if _, err := exec.LookPath(route.Command[0]); err != nil {
+			results = append(results, checkResult{"exec " + path, checkFail, err.Error(), true})
+			continue
+		}
+		results = append(results, checkResult{"exec " + path, checkOK, route.Command[0], true})
+	}
+	return results
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
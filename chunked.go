@@ -0,0 +1,187 @@
+// chunked.go
+
+package main
+
+import (
+	"bufio"   //reading from the connection
+	"errors"  //error kinds
+	"fmt"     //wrapping
+	"io"      //the body reader
+	"strconv" //chunk sizes
+	"strings" //parsing
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Request bodies
+//    - A body is framed by Content-Length, or, for clients streaming
+//      an upload of unknown size, by "Transfer-Encoding: chunked":
+//          1a;name=value       size in hex, extensions ignored
+//          <26 bytes>
+//          0                   last chunk
+//          Trailer: value      optional trailers, dropped
+//          <empty line>
+//      Handlers just read req.body either way and see the payload.
+//    - Chunked must be the last (and is the only) transfer coding we
+//      decode: "gzip, chunked" gets a 501, a Transfer-Encoding not
+//      ending in chunked a 400, as the body's end can't be found.
+//    - Anything that lets two parsers disagree on where the body ends
+//      is a 400 and closes the connection (request smuggling through
+//      a proxy in front of us, or behind): both Transfer-Encoding and
+//      Content-Length, Content-Length lines or list items that differ
+//      ("5, 5" is fine), a Content-Length that isn't plain digits, and
+//      Transfer-Encoding from an HTTP/1.0 client, which can't know
+//      chunking. Folded header lines are turned down in readHeaders.
+//    - Broken chunk framing mid-body, or a client going away before
+//      the last chunk, surfaces to the handler as an ErrBadRequest
+//      read error.
+// ─────────────────────────────────────────────────────────────────
+
+// ErrNotImplemented is for requests needing something we don't support.
+var ErrNotImplemented = errors.New("not implemented")
+
+// errCutShort is what reading a body returns when the client went away
+// before the last chunk.
+var errCutShort = fmt.Errorf("%w: chunked body cut short: %w", ErrBadRequest, io.ErrUnexpectedEOF)
+
+// maxChunkLine bounds a chunk size line including its extensions.
+const maxChunkLine = 4 << 10
+
+// requestBody returns the reader for the body that follows header in
+// a request of version.
+func requestBody(r *bufio.Reader, version string, header Header) (io.Reader, error) {
+	size, err := parseContentLength(header)
+	if err != nil {
+		return nil, err
+	}
+	te := header.Values("Transfer-Encoding")
+	if len(te) == 0 {
+		return io.LimitReader(r, size), nil
+	}
+	if len(header.Values("Content-Length")) > 0 {
+		return nil, fmt.Errorf("%w: both Transfer-Encoding and Content-Length", ErrBadRequest)
+	}
+	if !http11(version) {
+		//HTTP/1.0 has no transfer codings: the framing is broken (RFC 9112, 6.1)
+		return nil, fmt.Errorf("%w: Transfer-Encoding in an %s request", ErrBadRequest, version)
+	}
+	list := strings.Join(te, ",")
+	codings := strings.Split(list, ",")
+	for i, c := range codings {
+		c = strings.ToLower(strings.TrimSpace(c))
+		switch {
+		case i == len(codings)-1 && c != "chunked":
+			return nil, fmt.Errorf("%w: transfer coding %q doesn't end in chunked", ErrBadRequest, list)
+		case i < len(codings)-1 && c == "chunked":
+			return nil, fmt.Errorf("%w: chunked is not the last transfer coding", ErrBadRequest)
+		case !isToken(c):
+			return nil, fmt.Errorf("%w: bad transfer coding %q", ErrBadRequest, c)
+		}
+	}
+	if len(codings) > 1 {
+		return nil, fmt.Errorf("%w: transfer coding %q", ErrNotImplemented, list)
+	}
+	return &chunkedReader{r: r}, nil
+}
+
+// parseContentLength is the body size Content-Length declares, 0
+// without one. Repeats must all agree.
+func parseContentLength(header Header) (int64, error) {
+	size := int64(-1)
+	for _, line := range header.Values("Content-Length") {
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			n, err := strconv.ParseInt(item, 10, 64)
+			if err != nil || item == "" || strings.Trim(item, "0123456789") != "" {
+				return 0, fmt.Errorf("%w: bad Content-Length %q", ErrBadRequest, line)
+			}
+			if size >= 0 && n != size {
+				return 0, fmt.Errorf("%w: conflicting Content-Length %d and %d", ErrBadRequest, size, n)
+			}
+			size = n
+		}
+	}
+	return max(size, 0), nil
+}
+
+// isChunked reports whether the body after header is chunked, once
+// requestBody accepted it.
+func isChunked(header Header) bool {
+	return header.Get("Transfer-Encoding") != ""
+}
+
+// chunkedReader decodes a chunked body from r.
+type chunkedReader struct {
+	r       *bufio.Reader
+	left    int64 //bytes left in the current chunk
+	started bool  //a chunk was read, its CRLF comes before the next size
+	err     error //sticky: io.EOF after the trailers, or what broke
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.left == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = errCutShort
+	}
+	c.err = err
+	return n, err
+}
+
+// nextChunk reads up to the next chunk's data, or the trailers after
+// the last chunk (then returning io.EOF).
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		if line, err := readLine(c.r, 2); err != nil || line != "" {
+			return c.broken("missing CRLF after chunk data", err)
+		}
+	}
+	c.started = true
+	line, err := readLine(c.r, maxChunkLine)
+	if err != nil {
+		return c.broken("bad chunk size line", err)
+	}
+	sizeField, _, _ := strings.Cut(line, ";")
+	sizeField = strings.TrimSpace(sizeField)
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || strings.IndexFunc(sizeField, func(r rune) bool { return r > 0x7f || !isHex(byte(r)) }) >= 0 {
+		return c.broken(fmt.Sprintf("bad chunk size %q", sizeField), nil)
+	}
+	if size > 0 {
+		c.left = size
+		return nil
+	}
+	//Last chunk: skip the trailers up to the empty line
+	for total := 0; ; {
+		line, err := readLine(c.r, MaxHeaderBytes)
+		if err != nil {
+			return c.broken("bad trailer", err)
+		}
+		if line == "" {
+			return io.EOF
+		}
+		if total += len(line); total > MaxHeaderBytes {
+			return c.broken("trailers too long", nil)
+		}
+	}
+}
+
+func (c *chunkedReader) broken(what string, err error) error {
+	switch {
+	case errors.Is(err, ErrBadRequest) || err == nil:
+		return fmt.Errorf("%w: chunked body: %s", ErrBadRequest, what)
+	case err == io.EOF:
+		return errCutShort
+	}
+	return err //timed out
+}
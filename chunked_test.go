@@ -0,0 +1,139 @@
+// chunked_test.go
+
+package main
+
+import (
+	"bufio"   //request bytes
+	"errors"  //error kinds
+	"io"      //reading bodies
+	"strings" //request text
+	"testing" //test runner
+)
+
+// parseHead reads the header block of raw (the part after the request
+// line) and the body framing, like serveNext does.
+func parseHead(raw, version string) (*bufio.Reader, io.Reader, error) {
+	r := bufio.NewReader(strings.NewReader(strings.ReplaceAll(raw, "\n", "\r\n")))
+	header, err := readHeaders(r)
+	if err != nil {
+		return r, nil, err
+	}
+	body, err := requestBody(r, version, header)
+	return r, body, err
+}
+
+// The framing rules that keep us and a proxy from reading a request's
+// end differently (request smuggling).
+func TestRequestFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		head    string
+		wantErr error //nil = accepted
+	}{
+		{"content-length", "HTTP/1.1", "Content-Length: 5\n\n", nil},
+		{"no body", "HTTP/1.1", "Host: x\n\n", nil},
+		{"repeated, same value", "HTTP/1.1", "Content-Length: 5\nContent-Length: 5\n\n", nil},
+		{"list, same value", "HTTP/1.1", "Content-Length: 5, 5\n\n", nil},
+		{"chunked", "HTTP/1.1", "Transfer-Encoding: chunked\n\n", nil},
+		{"chunked, any case", "HTTP/1.1", "Transfer-Encoding: Chunked\n\n", nil},
+
+		{"both CL and TE", "HTTP/1.1", "Content-Length: 5\nTransfer-Encoding: chunked\n\n", ErrBadRequest},
+		{"both TE and CL", "HTTP/1.1", "Transfer-Encoding: chunked\nContent-Length: 5\n\n", ErrBadRequest},
+		{"conflicting lines", "HTTP/1.1", "Content-Length: 5\nContent-Length: 6\n\n", ErrBadRequest},
+		{"conflicting list", "HTTP/1.1", "Content-Length: 5, 6\n\n", ErrBadRequest},
+		{"plus sign", "HTTP/1.1", "Content-Length: +5\n\n", ErrBadRequest},
+		{"negative", "HTTP/1.1", "Content-Length: -1\n\n", ErrBadRequest},
+		{"hex", "HTTP/1.1", "Content-Length: 0x5\n\n", ErrBadRequest},
+		{"empty", "HTTP/1.1", "Content-Length: \n\n", ErrBadRequest},
+		{"empty list item", "HTTP/1.1", "Content-Length: 5,\n\n", ErrBadRequest},
+		{"overflow", "HTTP/1.1", "Content-Length: 99999999999999999999\n\n", ErrBadRequest},
+		{"TE in HTTP/1.0", "HTTP/1.0", "Transfer-Encoding: chunked\n\n", ErrBadRequest},
+		{"TE not ending in chunked", "HTTP/1.1", "Transfer-Encoding: chunked, gzip\n\n", ErrBadRequest},
+		{"TE identity", "HTTP/1.1", "Transfer-Encoding: identity\n\n", ErrBadRequest},
+		{"chunked twice", "HTTP/1.1", "Transfer-Encoding: chunked\nTransfer-Encoding: chunked\n\n", ErrBadRequest},
+		{"gzip, chunked", "HTTP/1.1", "Transfer-Encoding: gzip, chunked\n\n", ErrNotImplemented},
+		{"folded line", "HTTP/1.1", "Content-Length: 5\n 6\n\n", ErrBadRequest},
+		{"folded TE", "HTTP/1.1", "Transfer-Encoding:\n\tchunked\n\n", ErrBadRequest},
+		{"space before colon", "HTTP/1.1", "Content-Length : 5\n\n", ErrBadRequest},
+		{"no colon", "HTTP/1.1", "Content-Length 5\n\n", ErrBadRequest},
+		{"NUL in value", "HTTP/1.1", "X-A: a\x00b\n\n", ErrBadRequest},
+		{"bare CR in value", "HTTP/1.1", "X-A: a\rTransfer-Encoding: chunked\n\n", ErrBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseHead(tt.head, tt.version)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("refused: %v", err)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChunkedBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr error //nil = read to the end
+	}{
+		{"one chunk", "5\nhello\n0\n\n", "hello", nil},
+		{"several chunks", "3\nhel\n2\nlo\n0\n\n", "hello", nil},
+		{"upper case hex", "A\n0123456789\n0\n\n", "0123456789", nil},
+		{"extensions", "5;name=value\nhello\n0\n\n", "hello", nil},
+		{"trailers", "5\nhello\n0\nX-Sum: 1\nX-Other: 2\n\n", "hello", nil},
+		{"empty", "0\n\n", "", nil},
+
+		{"size not hex", "5g\nhello\n0\n\n", "", ErrBadRequest},
+		{"size with sign", "+5\nhello\n0\n\n", "", ErrBadRequest},
+		{"size with 0x", "0x5\nhello\n0\n\n", "", ErrBadRequest},
+		{"size empty", "\nhello\n0\n\n", "", ErrBadRequest},
+		{"size overflows", "fffffffffffffffff\nhello\n0\n\n", "", ErrBadRequest},
+		{"negative size", "-1\nhello\n0\n\n", "", ErrBadRequest},
+		{"data longer than size", "3\nhello\n0\n\n", "hel", ErrBadRequest},
+		{"no CRLF after data", "5\nhelloX\n0\n\n", "hello", ErrBadRequest},
+		{"cut short in data", "5\nhel", "hel", io.ErrUnexpectedEOF},
+		{"cut short before last chunk", "5\nhello\n", "hello", io.ErrUnexpectedEOF},
+		{"cut short in trailers", "5\nhello\n0\nX-Sum: 1\n", "hello", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, body, err := parseHead("Transfer-Encoding: chunked\n\n"+tt.body, "HTTP/1.1")
+			if err != nil {
+				t.Fatalf("framing refused: %v", err)
+			}
+			got, err := io.ReadAll(body)
+			if string(got) != tt.want {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("read error %v", err)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// The next request on the connection starts right after the body.
+func TestBodyEndsWhereTheNextRequestStarts(t *testing.T) {
+	for _, head := range []string{
+		"Content-Length: 5\n\nhello",
+		"Transfer-Encoding: chunked\n\n5\nhello\n0\n\n",
+	} {
+		r, body, err := parseHead(head+"GET /next HTTP/1.1\n", "HTTP/1.1")
+		if err != nil {
+			t.Fatalf("%q: %v", head, err)
+		}
+		if got, err := io.ReadAll(body); err != nil || string(got) != "hello" {
+			t.Fatalf("%q: body %q, %v", head, got, err)
+		}
+		if line, err := readRequestLine(r); err != nil || line != "GET /next HTTP/1.1" {
+			t.Errorf("%q: next request line %q, %v", head, line, err)
+		}
+	}
+}
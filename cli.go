@@ -0,0 +1,81 @@
+// cli.go
+
+package main
+
+import (
+	"fmt"     //usage
+	"os"      //stderr
+	"strings" //flag detection
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Subcommands
+//    - helix serve [flags]   run the server (also plain "helix [flags]",
+//                            so existing scripts keep working)
+//    - helix check [flags]   validate the configuration (check.go)
+//    - helix init            write a starter config, systemd unit and
+//                            Dockerfile from a few questions
+//                            (initconfig.go)
+//    - helix explain /path   show how a request would be resolved
+//                            (explain.go)
+//    - helix watermark FILE  who a leaked copy was served to
+//                            (watermark.go)
+//    - helix encrypt         encrypt a secret for the config file
+//                            (configcrypt.go)
+//    - helix version         print version + build info (version.go)
+//    - helix bench URL       measure a running server (bench.go)
+//    - helix soak            leak test under load (soak.go)
+//    - New tools are one more entry in cliCommands.
+// ─────────────────────────────────────────────────────────────────
+
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string) int //returns the exit status
+}
+
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{"serve", "run the server (default)", runServe},
+		{"check", "validate the configuration and exit", runCheck},
+		{"init", "ask a few questions and write a config, systemd unit and Dockerfile", runInit},
+		{"explain", "show which site, file and handler a path resolves to", runExplain},
+		{"watermark", "show who a watermarked copy of a file was served to", runWatermark},
+		{"encrypt", "encrypt a secret (read from stdin) for the config file", runEncrypt},
+		{"version", "print version and build information", runVersion},
+		{"bench", "send load to a URL and report latency", runBench},
+		{"soak", "run the server under load and check for leaks", runSoak},
+		{"help", "show this list", runHelp},
+	}
+}
+
+func runCLI(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+	for _, cmd := range cliCommands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "helix: unknown command %q\n\n", args[0])
+	printUsage()
+	return 2
+}
+
+func runHelp(args []string) int {
+	printUsage()
+	return 0
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: helix [command] [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range cliCommands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `Run "helix <command> -h" for a command's flags.`)
+}
@@ -0,0 +1,132 @@
+// cluster.go
+
+package main
+
+import (
+	"crypto/hmac" //constant-time token check
+	"fmt"         //errors
+	"net/url"     //update parameters
+	"strings"     //path + header handling
+	"time"        //ban TTLs
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Cluster updates
+//    - With ClusterPeers set, ban list changes made through the admin
+//      API on one instance are sent to every peer, so a ban behind a
+//      load balancer applies fleet-wide.
+//    - Peers are the public base URLs of the other instances (every
+//      instance lists all the others). They accept updates under
+//      ClusterPath with "Authorization: Bearer <ClusterToken>" and
+//      apply them locally without passing them on, so nothing loops.
+//    - Delivery is best effort (a few retries); a peer that was down
+//      misses the update. Permanent bans belong in BannedIPs.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	ClusterPeers = []string{}         //e.g. "http://10.0.0.2:8080"
+	ClusterToken = ""                 //shared secret or file:/env:/vault: reference; empty disables both sides
+	ClusterPath  = "/__helix/cluster" //where peers accept updates
+)
+
+func clusterEnabled() bool {
+	return ClusterToken != "" && len(ClusterPeers) > 0
+}
+
+// broadcastBanChange tells every peer about a ban added (op "add") or
+// lifted (op "remove") here. Runs in the background.
+func broadcastBanChange(op, entry string, ttl time.Duration, reason string) {
+	if !clusterEnabled() {
+		return
+	}
+	params := url.Values{"op": {op}, "ip": {entry}}
+	if op == "add" {
+		params.Set("reason", reason)
+		if ttl > 0 {
+			params.Set("ttl", ttl.String())
+		}
+	}
+	for _, peer := range ClusterPeers {
+		go func(peer string) {
+			resp, err := outboundDo(outboundRequest{
+				target:  "cluster",
+				method:  "POST",
+				url:     strings.TrimSuffix(peer, "/") + ClusterPath + "/bans?" + params.Encode(),
+				headers: map[string]string{"Authorization": "Bearer " + secret(ClusterToken)},
+				timeout: 5 * time.Second,
+				retries: 3,
+			})
+			if err == nil && resp.status >= 300 {
+				err = fmt.Errorf("answered %d", resp.status)
+			}
+			if err != nil {
+				logErrorf("cluster", "Ban update (%s %s) to %s failed: %v", op, entry, peer, err)
+			}
+		}(peer)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveCluster(w, req) error
+//    POST <ClusterPath>/bans?op=add&ip=<ip or cidr>[&ttl=1h][&reason=...]
+//    POST <ClusterPath>/bans?op=remove&ip=<ip or cidr>
+// ─────────────────────────────────────────────────────────────────
+
+// isClusterPath takes a request's cleanPath().
+func isClusterPath(path string) bool {
+	return path == ClusterPath || strings.HasPrefix(path, ClusterPath+"/")
+}
+
+// clusterAuthorized reports whether req is a cluster update with the
+// right token.
+func clusterAuthorized(req *request) bool {
+	if ClusterToken == "" || !isClusterPath(req.cleanPath()) {
+		return false
+	}
+	token, ok := strings.CutPrefix(req.header.Get("Authorization"), "Bearer ")
+	want := secret(ClusterToken)
+	return ok && want != "" && hmac.Equal([]byte(token), []byte(want))
+}
+
+func serveCluster(w ResponseWriter, req *request) error {
+	if ClusterToken == "" {
+		return ErrNotFound
+	}
+	if !clusterAuthorized(req) {
+		return fmt.Errorf("%w: bad cluster token", ErrForbidden)
+	}
+	_, rawQuery, _ := strings.Cut(req.rawPath, "?")
+	if req.cleanPath() != ClusterPath+"/bans" {
+		return ErrNotFound
+	}
+	if req.method != "POST" {
+		return onlyMethods("POST")
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+	entry := query.Get("ip")
+	switch query.Get("op") {
+	case "add":
+		var ttl time.Duration
+		if s := query.Get("ttl"); s != "" {
+			if ttl, err = time.ParseDuration(s); err != nil {
+				return fmt.Errorf("%w: bad ttl %q", ErrBadRequest, s)
+			}
+		}
+		if err := addBan(entry, ttl, "peer: "+query.Get("reason")); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadRequest, err)
+		}
+	case "remove":
+		if removeBan(entry) {
+			logInfof("accept", "Lifted ban on %s (from %s)", entry, req.clientAddr)
+		}
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrBadRequest, query.Get("op"))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, 200, "text/plain; charset=utf-8", []byte("ok\n"))
+	return nil
+}
@@ -0,0 +1,166 @@
+// conditional.go
+
+package main
+
+import (
+	"crypto/sha256" //strong ETags
+	"encoding/hex"  //strong ETags
+	"errors"        //error kinds
+	"fmt"           //ETag formatting, errors
+	"io"            //hashing files
+	"net/http"      //the HTTP date format
+	"os"            //reading files for strong ETags
+	"strings"       //If-Match/If-None-Match lists
+	"sync"          //strong ETag cache
+	"time"          //modification times
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Conditional GET
+//    - Static files carry "Last-Modified" (the file's mtime) and an
+//      ETag. By default (ETagMode "weak") it is derived from mtime
+//      and size, W/"18f2a3c4e5d6-4d2", which costs nothing; "strong"
+//      hashes the content instead (cached until the file changes),
+//      so identical files on several servers agree; "off" sends none.
+//    - A GET/HEAD whose If-None-Match lists the current ETag (or
+//      "*"), or, without If-None-Match, whose If-Modified-Since is at
+//      or after Last-Modified, gets "304 Not Modified" with the
+//      file's headers and no body, so browsers revalidate cached
+//      assets instead of downloading them again.
+//    - Tags are compared weakly (W/"x" matches "x"), as RFC 9110 asks
+//      for If-None-Match. Dates we can't parse are ignored.
+//    - The write-style preconditions come first (RFC 9110 13.2.2):
+//      an If-Match that doesn't list the current ETag (or "*"), or,
+//      without If-Match, an If-Unmodified-Since before Last-Modified,
+//      gets "412 Precondition Failed". If-Match compares strongly, so
+//      weak ETags never match it; clients that need it want "strong".
+// ─────────────────────────────────────────────────────────────────
+
+var ETagMode = "weak" //"weak", "strong" or "off"
+
+// ErrPreconditionFailed is for If-Match/If-Unmodified-Since that don't hold.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// httpDate formats t the way HTTP headers want it:
+// "Mon, 02 Jan 2006 15:04:05 GMT".
+func httpDate(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// checkPreconditions returns ErrPreconditionFailed unless the
+// client's If-Match/If-Unmodified-Since hold for the current version
+// of a resource, with etag ("" if none) last changed at modTime.
+func checkPreconditions(req *request, etag string, modTime time.Time) error {
+	if im := req.header.Values("If-Match"); len(im) > 0 {
+		list := strings.Join(im, ",")
+		if etagListMatches(list, etag, false) {
+			return nil
+		}
+		return fmt.Errorf("%w: If-Match %s, ETag %s", ErrPreconditionFailed, list, etag)
+	}
+	since := req.header.Get("If-Unmodified-Since")
+	if since == "" {
+		return nil
+	}
+	t, err := http.ParseTime(since)
+	if err != nil || !modTime.Truncate(time.Second).After(t) {
+		return nil
+	}
+	return fmt.Errorf("%w: modified %s, after If-Unmodified-Since %s", ErrPreconditionFailed, httpDate(modTime), since)
+}
+
+// notModified reports whether the client's copy is still current for
+// a file with etag ("" if none) last changed at modTime.
+func notModified(req *request, etag string, modTime time.Time) bool {
+	if !readMethod(req.method) {
+		return false
+	}
+	//If-None-Match wins; If-Modified-Since only counts without it
+	if inm := req.header.Values("If-None-Match"); len(inm) > 0 {
+		return etag != "" && etagListMatches(strings.Join(inm, ","), etag, true)
+	}
+	since := req.header.Get("If-Modified-Since")
+	if since == "" {
+		return false
+	}
+	t, err := http.ParseTime(since)
+	if err != nil {
+		return false
+	}
+	//HTTP dates have whole seconds
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// etagListMatches reports whether the If-Match/If-None-Match list
+// contains etag or is "*". Compared strongly, weak tags on either side
+// never match.
+func etagListMatches(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if !weak && (etag == "" || strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  ETags
+// ─────────────────────────────────────────────────────────────────
+
+type strongETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+var (
+	strongETagsMu sync.Mutex
+	strongETags   = make(map[string]strongETag) //by local path
+)
+
+// fileETag returns the ETag for the file at localPath, "" when off.
+func fileETag(localPath string, info os.FileInfo) (string, error) {
+	switch ETagMode {
+	case "off":
+		return "", nil
+	case "strong":
+		return strongFileETag(localPath, info)
+	}
+	return fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+}
+
+func strongFileETag(localPath string, info os.FileInfo) (string, error) {
+	strongETagsMu.Lock()
+	e, ok := strongETags[localPath]
+	strongETagsMu.Unlock()
+	if ok && e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+		return e.etag, nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("%w: open %s: %v", ErrForbidden, localPath, err)
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", fmt.Errorf("read %s: %w", localPath, err)
+	}
+	e = strongETag{modTime: info.ModTime(), size: info.Size(), etag: `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`}
+
+	strongETagsMu.Lock()
+	strongETags[localPath] = e
+	strongETagsMu.Unlock()
+	return e.etag, nil
+}
@@ -0,0 +1,611 @@
+// config.go
+
+package main
+
+import (
+	"fmt"           //errors
+	"os"            //reading the file
+	"path/filepath" //include patterns
+	"sort"          //stable key listing
+	"strconv"       //numbers + bools
+	"strings"       //key paths
+	"time"          //durations
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Config file (-config helix.yaml)
+//    - Nested keys are joined with dots: "log:\n  dir: /var/log"
+//      sets log.dir. configSettings lists every key we know; anything
+//      else is an error, so a typo doesn't get silently ignored.
+//    - Keys that are absent keep their defaults. Environment variables
+//      win over the file and flags given on the command line win over
+//      both (see loadEnvConfig).
+//
+//      listen: ":8080"
+//      root: /srv/www
+//      index: index.html
+//      log:
+//        dir: /var/log/helix
+//        level: info
+//      mime:
+//        .webmanifest: application/manifest+json
+//      error_pages:
+//        404: /errors/missing.html
+// ─────────────────────────────────────────────────────────────────
+
+type configSetting struct {
+	key string
+	set func(v any) error
+	get func() any
+
+	//setEntry makes key a group: "key.<name>" entries are each passed
+	//on whole, like the hosts under vhosts
+	setEntry func(name string, v any) error
+}
+
+// configSettings are all keys the config file may contain.
+var configSettings = []configSetting{
+	stringSetting("listen", &ListenAddr),
+	stringSetting("root", &DocRoot),
+	stringSetting("index", &IndexFile),
+	stringSetting("spa_fallback", &SPAFallback),
+	boolSetting("autoindex", &Autoindex),
+	boolSetting("pretty_urls", &PrettyURLs),
+	boolSetting("case_insensitive", &CaseInsensitivePaths),
+	intSetting("autoindex_page_size", &AutoindexPageSize),
+	stringSetting("autoindex_template", &AutoindexTemplate),
+	boolSetting("autoindex_readme", &AutoindexReadme),
+	boolSetting("startup.strict", &StrictStartup),
+	int64Setting("file_cache.size", &FileCacheSize),
+	int64Setting("file_cache.max_file", &FileCacheMaxFile),
+	boolSetting("fast_lane.enabled", &FastLane),
+	int64Setting("fast_lane.max_size", &FastLaneMaxSize),
+	stringListSetting("warmup.paths", &WarmupPaths),
+	intSetting("warmup.top", &WarmupTopN),
+	stringSetting("log.dir", &LogDir),
+	stringSetting("log.level", &LogLevel),
+	intSetting("log.retention_days", &LogRetentionDays),
+	stringSetting("privacy.ip_mode", &PrivacyIPMode),
+	durationSetting("privacy.salt_rotation", &PrivacySaltRotation),
+	boolSetting("privacy.drop_user_agent", &PrivacyDropUserAgent),
+	boolSetting("privacy.drop_referer", &PrivacyDropReferer),
+	stringSetting("admin.listen", &AdminListenAddr),
+	stringMapSetting("mime", &MIMETypes),
+	{key: "error_pages", set: setErrorPages, get: func() any { return ErrorDocuments }},
+	{key: "retry_after", set: setRetryAfter, get: retryAfterForConfig},
+	stringListSetting("bans", &BannedIPs),
+	{key: "route_limits", set: setRouteLimits, get: func() any { return RouteLimits }},
+	{key: "mirrors", set: setMirrors, get: func() any { return Mirrors }},
+	{key: "jobs", set: setJobSchedule, get: jobsForConfig},
+	{key: "exec", set: setExecRoutes, get: func() any { return ExecRoutes }},
+	boolSetting("chaos.enabled", &ChaosEnabled),
+	{key: "chaos.rules", set: setChaosRules, get: func() any { return ChaosRules }},
+	stringSetting("mirror_geoip_file", &MirrorGeoIPFile),
+	boolSetting("challenge.enabled", &ChallengeEnabled),
+	stringSetting("challenge.hcaptcha_site_key", &HCaptchaSiteKey),
+	stringSetting("challenge.hcaptcha_secret", &HCaptchaSecret),
+	stringSetting("statsd.addr", &StatsDAddr),
+	stringSetting("statsd.prefix", &StatsDPrefix),
+	durationSetting("statsd.flush_interval", &StatsDFlushInterval),
+	stringSetting("slo.webhook", &SLOWebhookURL),
+	durationSetting("slow_request_threshold", &SlowRequestThreshold),
+	stringSetting("sync.from", &SyncFrom),
+	stringSetting("sync.token", &SyncToken),
+	stringListSetting("cluster.peers", &ClusterPeers),
+	stringSetting("cluster.token", &ClusterToken),
+	stringSetting("signing.key_file", &SigningKeyFile),
+	stringListSetting("watermark.types", &WatermarkTypes),
+	stringSetting("watermark.secret", &WatermarkSecret),
+	stringSetting("leader.lock_file", &LeaderLockFile),
+	stringListSetting("echo.allowed", &EchoAllowedCIDRs),
+	boolSetting("search.enabled", &SearchEnabled),
+	stringSetting("search.path", &SearchPath),
+	stringSetting("search.index_file", &SearchIndexFile),
+	boolSetting("keepalive.enabled", &KeepAlive),
+	durationSetting("keepalive.idle_timeout", &IdleTimeout),
+	intSetting("keepalive.max_requests", &MaxKeepAliveRequests),
+	intSetting("limits.request_line", &MaxRequestLine),
+	intSetting("limits.header_bytes", &MaxHeaderBytes),
+	intSetting("limits.header_count", &MaxHeaderCount),
+	boolSetting("fonts.enabled", &FontsPreset),
+	stringListSetting("fonts.allow_origins", &FontAllowOrigins),
+	durationSetting("fonts.max_age", &FontMaxAge),
+	boolSetting("redirects", &RedirectsEnabled),
+	stringMapSetting("headers", &ResponseHeaders),
+	boolSetting("headers_file", &HeadersEnabled),
+	boolSetting("sidecars", &SidecarsEnabled),
+	boolSetting("rate_limit.enabled", &RateLimitEnabled),
+	intSetting("rate_limit.rate", &RateLimit),
+	intSetting("rate_limit.burst", &RateBurst),
+	stringSetting("etag", &ETagMode),
+	boolSetting("downloads.enabled", &DownloadStats),
+	stringSetting("downloads.file", &DownloadStatsFile),
+	boolSetting("downloads.stats_view", &DownloadStatsView),
+	stringSetting("feed.dir", &FeedDir),
+	stringSetting("feed.path", &FeedPath),
+	stringSetting("feed.format", &FeedFormat),
+	stringSetting("feed.title", &FeedTitle),
+	stringSetting("feed.site_url", &FeedSiteURL),
+	intSetting("feed.max_items", &FeedMaxItems),
+	stringSetting("torrent.dir", &TorrentDir),
+	stringSetting("torrent.base_url", &TorrentBaseURL),
+	stringListSetting("torrent.trackers", &TorrentTrackers),
+	int64Setting("torrent.piece_size", &TorrentPieceSize),
+	{key: "vhosts", setEntry: setVhost, get: vhostsForConfig},
+}
+
+// loadConfigFile applies the settings in path.
+func loadConfigFile(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	return applyConfig(values, path)
+}
+
+// readConfigFile parses path into dotted keys without applying them.
+func readConfigFile(path string) (map[string]any, error) {
+	return readConfigTree(path, map[string]bool{})
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  include + ${VAR}
+//    - "include: conf.d/*.yaml" (or a list of patterns) pulls in more
+//      files, relative to the including file, in name order. Their
+//      keys are read first; the including file's own keys win. The
+//      same key in two included files is an error, since which one
+//      "wins" would depend on file names.
+//    - ${VAR} in a value is replaced by the environment variable; an
+//      unset variable is an error rather than an empty secret. Only
+//      the braced form is expanded, so a "$" in a password is safe.
+// ─────────────────────────────────────────────────────────────────
+
+const maxIncludeDepth = 8
+
+func readConfigTree(path string, including map[string]bool) (map[string]any, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if including[abs] {
+		return nil, fmt.Errorf("%s: include loop", path)
+	}
+	if len(including) >= maxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes nested too deep", path)
+	}
+	including[abs] = true
+	defer delete(including, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	own := make(map[string]any)
+	flattenConfig("", doc, own)
+	for key, v := range own {
+		if own[key], err = expandConfigEnv(v); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+
+	include, hasInclude := own["include"]
+	delete(own, "include")
+	if !hasInclude {
+		return own, nil
+	}
+	var patterns []string
+	switch inc := include.(type) {
+	case string:
+		patterns = []string{inc}
+	case []any:
+		for _, item := range inc {
+			s, err := configString(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: include: %w", path, err)
+			}
+			patterns = append(patterns, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s: include: expected a file pattern or a list of them", path)
+	}
+
+	values := make(map[string]any)
+	from := make(map[string]string) //key → included file that set it
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %q: %w", path, pattern, err)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			included, err := readConfigTree(file, including)
+			if err != nil {
+				return nil, err
+			}
+			for key, v := range included {
+				if prev, dup := from[key]; dup {
+					return nil, fmt.Errorf("%s: %s is also set in %s", file, key, prev)
+				}
+				values[key], from[key] = v, file
+			}
+		}
+	}
+	for key, v := range own {
+		values[key] = v
+	}
+	return values, nil
+}
+
+// expandConfigEnv replaces ${VAR} in every string of v.
+func expandConfigEnv(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnvRefs(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			if out[i], err = expandConfigEnv(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			var err error
+			if out[k], err = expandConfigEnv(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func expandEnvRefs(s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		name := s[start+2 : start+end]
+		value, ok := os.LookupEnv(name)
+		if !ok || name == "" {
+			return "", fmt.Errorf("${%s} is not set", name)
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Environment overrides
+//    - Every setting can also come from HELIX_<KEY>, with the dots
+//      turned into underscores: HELIX_ROOT, HELIX_LOG_DIR,
+//      HELIX_STATSD_ADDR… (HELIX_ADDR for listen, like -addr).
+//    - Lists are comma separated (HELIX_BANS=203.0.113.7,10.0.0.0/8),
+//      mappings are key=value pairs (HELIX_MIME=.wasm=application/wasm).
+//    - Unknown HELIX_ variables are reported but not fatal; a child
+//      process environment (exec.go) may well have a few.
+// ─────────────────────────────────────────────────────────────────
+
+const envPrefix = "HELIX_"
+
+// envAliases maps variables that don't follow the key naming.
+var envAliases = map[string]string{
+	"HELIX_ADDR": "listen",
+}
+
+// configEnvName is the variable that overrides key.
+func configEnvName(key string) string {
+	for name, aliased := range envAliases {
+		if aliased == key {
+			return name
+		}
+	}
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(key))
+}
+
+// loadEnvConfig applies HELIX_* variables from the environment.
+func loadEnvConfig() error {
+	values, unknown, err := envConfigValues()
+	if err != nil {
+		return err
+	}
+	for _, name := range unknown {
+		fmt.Printf("Ignoring unknown setting %s\n", name)
+	}
+	return applyConfig(values, "environment")
+}
+
+// envConfigValues collects the HELIX_* variables by config key, plus
+// the names of those that match no setting.
+func envConfigValues() (values map[string]any, unknown []string, err error) {
+	byName := make(map[string]*configSetting, len(configSettings))
+	for i := range configSettings {
+		if configSettings[i].setEntry == nil { //groups only come from files
+			byName[configEnvName(configSettings[i].key)] = &configSettings[i]
+		}
+	}
+	values = make(map[string]any)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envPrefix) || name == configKeyEnv || name == configKeyFileEnv {
+			continue //the key for enc: values isn't a setting, see configcrypt.go
+		}
+		s := byName[name]
+		if s == nil {
+			unknown = append(unknown, name)
+			continue
+		}
+		v, err := envValue(s, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		values[s.key] = v
+	}
+	sort.Strings(unknown)
+	return values, unknown, nil
+}
+
+// envValue shapes a variable's text like the YAML parser would, going
+// by the kind of value the setting holds.
+func envValue(s *configSetting, value string) (any, error) {
+	switch s.get().(type) {
+	case []string:
+		items := []any{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	case map[string]string, map[int]string:
+		m := make(map[string]any)
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("expected key=value pairs, got %q", pair)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		return m, nil
+	}
+	return value, nil
+}
+
+func flattenConfig(prefix string, m map[string]any, out map[string]any) {
+	for key, v := range m {
+		full := key
+		if prefix != "" {
+			full = prefix + "." + key
+		}
+		s := findConfigSetting(full)
+		if nested, ok := v.(map[string]any); ok && s == nil {
+			flattenConfig(full, nested, out)
+			continue
+		}
+		if nested, ok := v.(map[string]any); ok && s.setEntry != nil && s.key == full {
+			for name, entry := range nested {
+				out[full+"."+name] = entry
+			}
+			continue
+		}
+		out[full] = v
+	}
+}
+
+// findConfigSetting returns the setting for key, or for the group key
+// belongs to ("vhosts" for "vhosts.example.com").
+func findConfigSetting(key string) *configSetting {
+	for i := range configSettings {
+		s := &configSettings[i]
+		if s.key == key || s.setEntry != nil && strings.HasPrefix(key, s.key+".") {
+			return s
+		}
+	}
+	return nil
+}
+
+func applyConfig(values map[string]any, source string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := findConfigSetting(key)
+		if s == nil {
+			return fmt.Errorf("%s: unknown setting %q", source, key)
+		}
+		set := s.set
+		if s.setEntry != nil {
+			if key == s.key {
+				return fmt.Errorf("%s: %s: expected a mapping", source, key)
+			}
+			set = func(v any) error { return s.setEntry(strings.TrimPrefix(key, s.key+"."), v) }
+		}
+		if err := set(values[key]); err != nil {
+			return fmt.Errorf("%s: %s: %w", source, key, err)
+		}
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Setting kinds
+// ─────────────────────────────────────────────────────────────────
+
+func configString(v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a single value")
+	}
+	return s, nil
+}
+
+func stringSetting(key string, p *string) configSetting {
+	return configSetting{key: key, get: func() any { return *p }, set: func(v any) error {
+		s, err := configString(v)
+		if err == nil {
+			*p = s
+		}
+		return err
+	}}
+}
+
+func boolSetting(key string, p *bool) configSetting {
+	return configSetting{key: key, get: func() any { return *p }, set: func(v any) error {
+		s, err := configString(v)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(s) {
+		case "true", "yes", "on", "1":
+			*p = true
+		case "false", "no", "off", "0":
+			*p = false
+		default:
+			return fmt.Errorf("expected true or false, got %q", s)
+		}
+		return nil
+	}}
+}
+
+func intSetting(key string, p *int) configSetting {
+	return configSetting{key: key, get: func() any { return *p }, set: func(v any) error {
+		s, err := configString(v)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("expected a number, got %q", s)
+		}
+		*p = n
+		return nil
+	}}
+}
+
+func int64Setting(key string, p *int64) configSetting {
+	return configSetting{key: key, get: func() any { return *p }, set: func(v any) error {
+		s, err := configString(v)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("expected a number, got %q", s)
+		}
+		*p = n
+		return nil
+	}}
+}
+
+func durationSetting(key string, p *time.Duration) configSetting {
+	return configSetting{key: key, get: func() any { return p.String() }, set: func(v any) error {
+		s, err := configString(v)
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("expected a duration like 30s, got %q", s)
+		}
+		*p = d
+		return nil
+	}}
+}
+
+func stringListSetting(key string, p *[]string) configSetting {
+	return configSetting{key: key, get: func() any { return *p }, set: func(v any) error {
+		if s, ok := v.(string); ok {
+			if s == "" {
+				*p = []string{}
+				return nil
+			}
+			*p = []string{s}
+			return nil
+		}
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("expected a list")
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected a list of values")
+			}
+			list = append(list, s)
+		}
+		*p = list
+		return nil
+	}}
+}
+
+func stringMapSetting(key string, p *map[string]string) configSetting {
+	return configSetting{key: key, get: func() any { return *p }, set: func(v any) error {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected a mapping")
+		}
+		out := make(map[string]string, len(m))
+		for k, item := range m {
+			s, err := configString(item)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = s
+		}
+		*p = out
+		return nil
+	}}
+}
+
+// setErrorPages reads a status code → path mapping into ErrorDocuments.
+func setErrorPages(v any) error {
+	docs, err := parseErrorPages(v)
+	if err == nil {
+		ErrorDocuments = docs
+	}
+	return err
+}
+
+func parseErrorPages(v any) (map[int]string, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping of status code to path")
+	}
+	docs := make(map[int]string, len(m))
+	for k, item := range m {
+		code, err := strconv.Atoi(k)
+		if err != nil || code < 400 || code > 599 {
+			return nil, fmt.Errorf("%q is not an error status", k)
+		}
+		path, err := configString(item)
+		if err != nil || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("%d: expected a path starting with /", code)
+		}
+		docs[code] = path
+	}
+	return docs, nil
+}
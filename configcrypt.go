@@ -0,0 +1,142 @@
+// configcrypt.go
+
+package main
+
+import (
+	"bufio"           //reading the value to encrypt
+	"crypto/aes"      //sealing values
+	"crypto/cipher"   //sealing values
+	"crypto/rand"     //nonces + new keys
+	"encoding/base64" //value + key text
+	"errors"          //decoding failures
+	"flag"            //subcommand flags
+	"fmt"             //errors + output
+	"os"              //key sources, stdin
+	"strings"         //trimming
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Encrypted config values
+//    - A secret setting (see secrets.go) can be written into the
+//      config encrypted, so the file can be committed with the rest
+//      of a deployment:
+//          sync:
+//            token: enc:Xq3…
+//      "enc:" is one more reference scheme: resolved at startup (a
+//      value that doesn't decrypt stops us) and shown as written in
+//      /config.
+//    - The key is 32 random bytes, base64, from HELIX_CONFIG_KEY or
+//      the file HELIX_CONFIG_KEY_FILE names, so it stays with the
+//      machine (or a KMS that puts it there), not the repository.
+//    - Values are AES-256-GCM with a random nonce; the same value
+//      encrypts differently every time.
+//    - "helix encrypt" reads a value on stdin and prints its enc:
+//      form; "helix encrypt -new-key" prints a fresh key.
+// ─────────────────────────────────────────────────────────────────
+
+const (
+	configKeyEnv     = "HELIX_CONFIG_KEY"
+	configKeyFileEnv = "HELIX_CONFIG_KEY_FILE"
+	configValueAD    = "helix-config-v1" //bound into every value
+)
+
+// configKey reads the key from the environment or the key file.
+func configKey() ([]byte, error) {
+	text, source := os.Getenv(configKeyEnv), configKeyEnv
+	if text == "" {
+		path := os.Getenv(configKeyFileEnv)
+		if path == "" {
+			return nil, fmt.Errorf("encrypted value but neither %s nor %s is set", configKeyEnv, configKeyFileEnv)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text, source = string(data), path
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s: expected 32 bytes, base64 (see helix encrypt -new-key)", source)
+	}
+	return key, nil
+}
+
+func configCipher() (cipher.AEAD, error) {
+	key, err := configKey()
+	if err != nil {
+		return nil, err
+	}
+	block, _ := aes.NewCipher(key) //a 32-byte key can't fail
+	return cipher.NewGCM(block)
+}
+
+// encryptConfigValue returns plain's enc: form.
+func encryptConfigValue(plain string) (string, error) {
+	aead, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), []byte(configValueAD))
+	return "enc:" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptConfigValue is encryptConfigValue backwards, for the part
+// after "enc:".
+func decryptConfigValue(text string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return "", errors.New("not an encrypted value")
+	}
+	aead, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(configValueAD))
+	if err != nil {
+		return "", fmt.Errorf("doesn't decrypt with this %s", configKeyEnv)
+	}
+	return string(plain), nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  helix encrypt [-new-key]
+//    - Reads one value from stdin (trailing newline cut), prints it
+//      encrypted with the key from the environment.
+// ─────────────────────────────────────────────────────────────────
+
+func runEncrypt(args []string) int {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	newKey := fs.Bool("new-key", false, "print a new random key for "+configKeyEnv)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *newKey {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			fmt.Fprintln(os.Stderr, "helix encrypt:", err)
+			return 1
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return 0
+	}
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n') //EOF ends the value too
+	plain := strings.TrimRight(line, "\r\n")
+	if plain == "" {
+		fmt.Fprintln(os.Stderr, "Usage: echo VALUE | helix encrypt   (or helix encrypt -new-key)")
+		return 2
+	}
+	value, err := encryptConfigValue(plain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "helix encrypt:", err)
+		return 1
+	}
+	fmt.Println(value)
+	return 0
+}
@@ -0,0 +1,149 @@
+// configdump.go
+
+package main
+
+import (
+	"encoding/json" //the dump
+	"net/http"      //admin endpoint
+	"strings"       //exec commands
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  GET /config on the admin listener
+//    - What the server is actually running with, as JSON:
+//        sources   the -profile, -config file, flags and HELIX_*
+//                  keys that went into it (names only)
+//        settings  every config key with its effective value, after
+//                  defaults, profile, file, ${VAR} expansion,
+//                  environment and flags; secrets given literally
+//                  show as "[redacted]", references as written
+//        sites     the default site and each vhost as of the last
+//                  reload, with the _redirects rules and _headers
+//                  blocks their roots hold right now
+//        routes    the handlers matchRoute() tries, in its order,
+//                  and whether each is switched on
+//    - Read-only; nothing here changes a setting.
+// ─────────────────────────────────────────────────────────────────
+
+// secretKeys are the config keys whose literal values are hidden.
+var secretKeys = map[string]bool{
+	"challenge.hcaptcha_secret": true,
+	"sync.token":                true,
+	"cluster.token":             true,
+	"watermark.secret":          true,
+}
+
+type routeEntry struct {
+	Match   string `json:"match"`
+	Handler string `json:"handler"`
+	Enabled bool   `json:"enabled"`
+}
+
+type siteDump struct {
+	Host            string              `json:"host,omitempty"`
+	Root            string              `json:"root"`
+	Index           string              `json:"index"`
+	SPAFallback     string              `json:"spa_fallback,omitempty"`
+	Autoindex       bool                `json:"autoindex"`
+	PrettyURLs      bool                `json:"pretty_urls"`
+	CaseInsensitive bool                `json:"case_insensitive"`
+	ErrorPages      map[int]string      `json:"error_pages,omitempty"`
+	RedirectsFile   string              `json:"redirects_file,omitempty"`
+	Redirects       []string            `json:"redirects,omitempty"`
+	HeadersFile     string              `json:"headers_file,omitempty"`
+	Headers         []map[string]string `json:"headers,omitempty"`
+}
+
+func init() {
+	adminMux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := json.MarshalIndent(effectiveConfig(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(body, '\n'))
+	})
+}
+
+func effectiveConfig() map[string]any {
+	reloadMu.Lock() //a consistent view, not half a reload
+	defer reloadMu.Unlock()
+
+	settings := make(map[string]any, len(configSettings))
+	for _, s := range configSettings {
+		v := s.get()
+		if str, ok := v.(string); ok && secretKeys[s.key] && str != "" && !isSecretRef(str) {
+			v = "[redacted]"
+		}
+		settings[s.key] = v
+	}
+	envKeys := []string{}
+	if values, _, err := envConfigValues(); err == nil {
+		envKeys = sortedKeys(values)
+	}
+
+	site := siteNow()
+	sites := []siteDump{dumpSite(site)}
+	for _, host := range sortedKeys(site.vhosts) {
+		if v := site.vhosts[host]; v.host == host { //aliases point to the same site
+			sites = append(sites, dumpSite(v))
+		}
+	}
+
+	return map[string]any{
+		"sources": map[string]any{
+			"profile":     configProfile,
+			"config_file": configFile,
+			"flags":       setByFlags,
+			"environment": envKeys,
+		},
+		"settings": settings,
+		"sites":    sites,
+		"routes":   routeTable(),
+	}
+}
+
+func dumpSite(site *siteSettings) siteDump {
+	d := siteDump{
+		Host: site.host, Root: site.root, Index: site.index, SPAFallback: site.spaFallback,
+		Autoindex: site.autoindex, PrettyURLs: site.prettyURLs, CaseInsensitive: site.caseFold,
+		ErrorPages: site.errorDocs,
+	}
+	if RedirectsEnabled {
+		if rules, path, ok := redirectFiles.get(site.root); ok {
+			d.RedirectsFile = path
+			for _, rule := range rules {
+				d.Redirects = append(d.Redirects, rule.String())
+			}
+		}
+	}
+	if HeadersEnabled {
+		if blocks, path, ok := headerFiles.get(site.root); ok {
+			d.HeadersFile = path
+			for _, b := range blocks {
+				block := map[string]string{"path": b.path.String()}
+				for name, values := range b.headers {
+					block[name] = strings.Join(values, ", ")
+				}
+				d.Headers = append(d.Headers, block)
+			}
+		}
+	}
+	return d
+}
+
+// routeTable lists the routes matchRoute() (internal.go) tries, in
+// its order, then the static fallback.
+func routeTable() []routeEntry {
+	var rows []routeEntry
+	for _, r := range routes {
+		rows = append(rows, r.describe()...)
+	}
+	return append(rows, routeEntry{"/", "static", true})
+}
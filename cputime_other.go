@@ -0,0 +1,10 @@
+// cputime_other.go
+
+//go:build !unix
+
+package main
+
+// processCPUTime isn't implemented here; /metrics leaves it out.
+func processCPUTime() (user, system float64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,17 @@
+// cputime_unix.go
+
+//go:build unix
+
+package main
+
+import "syscall" //getrusage
+
+// processCPUTime returns the user and system CPU seconds we used so far.
+func processCPUTime() (user, system float64, ok bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, false
+	}
+	seconds := func(tv syscall.Timeval) float64 { return float64(tv.Sec) + float64(tv.Usec)/1e6 }
+	return seconds(ru.Utime), seconds(ru.Stime), true
+}
@@ -0,0 +1,12 @@
+// diskusage_other.go
+
+//go:build !unix
+
+package main
+
+import "errors"
+
+// diskUsage isn't implemented here; the watchdog just reports the error.
+func diskUsage(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk usage not supported on this platform")
+}
@@ -0,0 +1,133 @@
+// diskwatch.go
+
+package main
+
+import (
+	"expvar" //current disk state in /debug/vars
+	"sync"   //guarding the state
+	"time"   //check interval
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Disk space watchdog
+//    - Every DiskCheckInterval we look at the free space of the volumes
+//      we depend on (logs, document root).
+//    - A volume is "low" below DiskLowFreePercent/DiskLowFreeBytes and
+//      "critical" below DiskCriticalFreePercent/DiskCriticalFreeBytes.
+//    - Changes are logged (WARN when it gets worse, INFO when it
+//      recovers) so the alert fires once, not every minute.
+// ─────────────────────────────────────────────────────────────────
+
+var DiskCheckInterval = 1 * time.Minute
+
+var (
+	DiskLowFreePercent      = 10.0
+	DiskLowFreeBytes        = uint64(1 << 30) //1 GiB
+	DiskCriticalFreePercent = 5.0
+	DiskCriticalFreeBytes   = uint64(256 << 20) //256 MiB
+)
+
+const (
+	diskOK       = "ok"
+	diskLow      = "low"
+	diskCritical = "critical"
+)
+
+type diskStatus struct {
+	Path        string  `json:"path"`
+	State       string  `json:"state"`
+	FreeBytes   uint64  `json:"free_bytes"`
+	TotalBytes  uint64  `json:"total_bytes"`
+	FreePercent float64 `json:"free_percent"`
+	Error       string  `json:"error,omitempty"`
+}
+
+var (
+	diskMu     sync.Mutex
+	diskStates = make(map[string]diskStatus) //keyed by volume name ("logs", "root")
+)
+
+func init() {
+	expvar.Publish("helix_disk", expvar.Func(func() any { return diskStatesSnapshot() }))
+}
+
+// diskStatesSnapshot returns a copy of the latest state of every volume.
+func diskStatesSnapshot() map[string]diskStatus {
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	out := make(map[string]diskStatus, len(diskStates))
+	for name, s := range diskStates {
+		out[name] = s
+	}
+	return out
+}
+
+// watchedVolumes maps a name to a path on the volume we care about.
+func watchedVolumes() map[string]string {
+	return map[string]string{
+		"logs": LogDir,
+		"root": siteNow().root,
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  startDiskWatchdog()
+//    - Checks once right away (so /debug/vars isn't empty) and then
+//      every DiskCheckInterval in the background.
+// ─────────────────────────────────────────────────────────────────
+
+func startDiskWatchdog() {
+	checkDisks()
+	go func() {
+		for range time.Tick(DiskCheckInterval) {
+			checkDisks()
+		}
+	}()
+}
+
+func checkDisks() {
+	for name, path := range watchedVolumes() {
+		status := diskStatus{Path: path}
+		free, total, err := diskUsage(path)
+		if err != nil {
+			status.State = diskOK //can't tell, don't cry wolf
+			status.Error = err.Error()
+		} else {
+			status.FreeBytes, status.TotalBytes = free, total
+			if total > 0 {
+				status.FreePercent = float64(free) / float64(total) * 100
+			}
+			status.State = diskState(status.FreeBytes, status.FreePercent)
+		}
+
+		diskMu.Lock()
+		previous, seen := diskStates[name]
+		diskStates[name] = status
+		diskMu.Unlock()
+
+		if !seen && status.State == diskOK {
+			continue
+		}
+		if !seen || previous.State != status.State {
+			reportDiskState(name, status)
+		}
+	}
+}
+
+func diskState(free uint64, freePercent float64) string {
+	switch {
+	case free < DiskCriticalFreeBytes || freePercent < DiskCriticalFreePercent:
+		return diskCritical
+	case free < DiskLowFreeBytes || freePercent < DiskLowFreePercent:
+		return diskLow
+	}
+	return diskOK
+}
+
+func reportDiskState(name string, s diskStatus) {
+	if s.State == diskOK {
+		logInfof("disk", "Disk space on %s volume (%s) back to normal: %s free (%.1f%%)", name, s.Path, humanBytes(int64(s.FreeBytes)), s.FreePercent)
+		return
+	}
+	logWarnf("disk", "Disk space on %s volume (%s) is %s: %s free (%.1f%%)", name, s.Path, s.State, humanBytes(int64(s.FreeBytes)), s.FreePercent)
+}
@@ -0,0 +1,319 @@
+// docsync.go
+
+package main
+
+import (
+	"crypto/hmac"   //constant-time token check
+	"crypto/sha256" //file checksums
+	"encoding/hex"  //checksums in the manifest
+	"encoding/json" //manifest
+	"errors"        //collecting failures
+	"fmt"           //errors
+	"io"            //hashing files
+	"io/fs"         //walking the docroot
+	"net/url"       //file URLs
+	"os"            //reading + writing files
+	"path/filepath" //docroot paths
+	"sort"          //stable manifest
+	"strconv"       //Content-Length
+	"strings"       //path handling
+	"sync"          //hash cache
+	"time"          //change detection
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Docroot sync
+//    - Keeps a small fleet of Helix instances serving the same files.
+//      One instance is the origin, the others pull from it.
+//    - Origin: with SyncToken set, serves a manifest of every file in
+//      the docroot (path, size, sha256) and the files themselves under
+//      SyncPath, to requests carrying "Authorization: Bearer <token>".
+//    - Replica: with SyncFrom set too, the docroot-sync job fetches the
+//      manifest, downloads what differs, checks each file's sha256
+//      before moving it into place, and removes files the origin no
+//      longer has. It runs every 5 minutes (config "jobs.docroot-sync.every" changes that).
+//    - Run the origin behind TLS (or over a private network) when the
+//      token or content crosses anything untrusted.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	SyncToken             = ""              //shared secret or file:/env:/vault: reference; empty disables both sides
+	SyncFrom              = ""              //origin base URL, e.g. "https://origin.example.com"
+	SyncPath              = "/__helix/sync" //where the origin serves manifest + files
+	SyncMaxFileSize int64 = 1 << 30         //bigger files are skipped
+)
+
+type syncEntry struct {
+	Path   string `json:"path"` //slash separated, relative to the docroot
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func init() {
+	registerJob("docroot-sync", 5*time.Minute, syncDocroot)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Manifest
+//    - Hashes are cached by path + size + mtime, so repeated manifest
+//      requests only hash files that changed.
+// ─────────────────────────────────────────────────────────────────
+
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+var (
+	hashCacheMu sync.Mutex
+	hashCache   = make(map[string]cachedHash)
+)
+
+func docrootManifest(root string) ([]syncEntry, error) {
+	var entries []syncEntry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		//Regular files only; symlinks could point outside the docroot
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), ".helix-sync") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sum, err := fileHash(path, info)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		entries = append(entries, syncEntry{Path: filepath.ToSlash(rel), Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, err
+}
+
+func fileHash(path string, info fs.FileInfo) (string, error) {
+	hashCacheMu.Lock()
+	c, ok := hashCache[path]
+	hashCacheMu.Unlock()
+	if ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	hashCacheMu.Lock()
+	hashCache[path] = cachedHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	hashCacheMu.Unlock()
+	return sum, nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveSync(w, req) error (origin side)
+//    GET <SyncPath>/manifest          → JSON list of syncEntry
+//    GET <SyncPath>/file?path=<rel>   → the file's bytes
+//    - Anything under SyncPath answers 404 while sync is off, and 403
+//      without the right token.
+// ─────────────────────────────────────────────────────────────────
+
+// isSyncPath takes a request's cleanPath().
+func isSyncPath(path string) bool {
+	return path == SyncPath || strings.HasPrefix(path, SyncPath+"/")
+}
+
+// syncAuthorized reports whether req is a sync request with the right
+// token.
+func syncAuthorized(req *request) bool {
+	if SyncToken == "" || !isSyncPath(req.cleanPath()) {
+		return false
+	}
+	token, ok := strings.CutPrefix(req.header.Get("Authorization"), "Bearer ")
+	want := secret(SyncToken)
+	return ok && want != "" && hmac.Equal([]byte(token), []byte(want))
+}
+
+func serveSync(w ResponseWriter, req *request) error {
+	if SyncToken == "" {
+		return ErrNotFound
+	}
+	if !syncAuthorized(req) {
+		return fmt.Errorf("%w: bad sync token", ErrForbidden)
+	}
+	if req.method != "GET" {
+		return onlyMethods("GET")
+	}
+
+	switch strings.TrimPrefix(req.cleanPath(), SyncPath) {
+	case "/manifest":
+		entries, err := docrootManifest(siteNow().root)
+		if err != nil {
+			return fmt.Errorf("sync manifest: %w", err)
+		}
+		body, _ := json.Marshal(entries)
+		w.Header().Set("Cache-Control", "no-store")
+		writeBody(w, 200, "application/json", body)
+		return nil
+	case "/file":
+		rel, err := sanitizePath("/" + req.query().Get("path"))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBadRequest, err)
+		}
+		localPath := filepath.Join(siteNow().root, rel)
+		info, err := os.Lstat(localPath)
+		if err != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf("%w: sync file %s", ErrNotFound, rel)
+		}
+		if info.Size() > SyncMaxFileSize {
+			return fmt.Errorf("%w: sync file %s is over SyncMaxFileSize", ErrTooLarge, rel)
+		}
+		f, err := os.Open(localPath)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", localPath, err)
+		}
+		defer f.Close()
+		//Streamed; a file that grew since the Lstat is cut at its size
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.WriteHeader(200)
+		_, err = io.Copy(w, io.LimitReader(f, info.Size()))
+		return err
+	}
+	return ErrNotFound
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  syncDocroot() error (replica side, the docroot-sync job)
+//    - Does nothing unless SyncFrom and SyncToken are set.
+//    - One bad file doesn't stop the rest; all failures are returned
+//      together so they show up in the job history.
+// ─────────────────────────────────────────────────────────────────
+
+func syncDocroot() error {
+	if SyncFrom == "" || SyncToken == "" {
+		return nil
+	}
+	base := strings.TrimSuffix(SyncFrom, "/") + SyncPath
+	auth := map[string]string{"Authorization": "Bearer " + secret(SyncToken)}
+
+	resp, err := outboundDo(outboundRequest{target: "docroot_sync", method: "GET", url: base + "/manifest", headers: auth, retries: 2, maxBody: 64 << 20})
+	if err != nil {
+		return err
+	}
+	if resp.status != 200 {
+		return fmt.Errorf("manifest: origin answered %d", resp.status)
+	}
+	var remote []syncEntry
+	if err := json.Unmarshal(resp.body, &remote); err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	local, err := docrootManifest(siteNow().root)
+	if err != nil {
+		return fmt.Errorf("local manifest: %w", err)
+	}
+	have := make(map[string]string, len(local))
+	for _, e := range local {
+		have[e.Path] = e.SHA256
+	}
+
+	var errs []error
+	var fetched, removed int
+	want := make(map[string]bool, len(remote))
+	for _, e := range remote {
+		rel, err := sanitizePath("/" + e.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %v", e.Path, err))
+			continue
+		}
+		want[strings.TrimPrefix(rel, "/")] = true
+		if have[e.Path] == e.SHA256 {
+			continue
+		}
+		if e.Size > SyncMaxFileSize {
+			errs = append(errs, fmt.Errorf("%s: %d bytes is over SyncMaxFileSize", e.Path, e.Size))
+			continue
+		}
+		if err := fetchSyncFile(base, auth, rel, e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Path, err))
+			continue
+		}
+		fetched++
+	}
+
+	//Only delete when we got a usable manifest, an empty one included
+	for _, e := range local {
+		if want[e.Path] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(siteNow().root, filepath.FromSlash(e.Path))); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	if fetched > 0 || removed > 0 {
+		logInfof("sync", "Docroot synced from %s: %d files updated, %d removed", SyncFrom, fetched, removed)
+	}
+	return errors.Join(errs...)
+}
+
+// fetchSyncFile streams one file into a temporary file next to its
+// final place, hashing it on the way, and renames it over the old one
+// once the checksum matches, so readers never see a half written file
+// and memory use doesn't grow with the file.
+func fetchSyncFile(base string, auth map[string]string, rel string, e syncEntry) error {
+	dst := filepath.Join(siteNow().root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".helix-sync"
+	defer os.Remove(tmp) //gone after the rename, or a failed download
+
+	var sum string
+	resp, err := outboundDo(outboundRequest{
+		target:  "docroot_sync",
+		method:  "GET",
+		url:     base + "/file?path=" + url.QueryEscape(e.Path),
+		headers: auth,
+		timeout: 5 * time.Minute,
+		retries: 2,
+		maxBody: SyncMaxFileSize,
+		stream: func(body io.Reader) error {
+			f, err := os.Create(tmp) //truncates what a failed attempt left
+			if err != nil {
+				return err
+			}
+			h := sha256.New()
+			_, err = io.Copy(io.MultiWriter(f, h), body)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			sum = hex.EncodeToString(h.Sum(nil))
+			return err
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if resp.status != 200 {
+		return fmt.Errorf("origin answered %d", resp.status)
+	}
+	if sum != e.SHA256 {
+		return errors.New("checksum mismatch")
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,247 @@
+// downloads.go
+
+package main
+
+import (
+	"encoding/json" //stats file, admin API, ?stats
+	"net/http"      //admin handler
+	"os"            //stats file
+	"path/filepath" //file paths under the root
+	"sort"          //most downloaded first
+	"strconv"       //?limit=
+	"strings"       //prefix matching
+	"sync"          //guarding the counters
+	"time"          //last download
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Download statistics (off unless DownloadStats)
+//    - Every file served by the static handler counts the bytes sent
+//      and, for responses starting at the first byte (a 200, or a 206
+//      from offset 0), one download. Resumed and chunked fetches add
+//      bytes only, so a download manager doesn't count ten times.
+//      HEAD requests and error pages don't count.
+//    - Counts are per site and file path under the root, kept in
+//      memory and saved to DownloadStatsFile by the save-downloads
+//      job every minute, so a restart loses at most that much.
+//    - Admin API: GET /downloads (?site=, ?prefix=, ?limit=).
+//    - With DownloadStatsView, "?stats" on a file or directory URL
+//      answers with the counts for it (and everything below) as JSON
+//      instead of the file.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	DownloadStats     = false
+	DownloadStatsFile = "" //"" = <log-dir>/downloads.json
+	DownloadStatsView = false
+)
+
+const downloadsSaveInterval = time.Minute
+
+type downloadKey struct {
+	site string //host, "" for the default site
+	path string //"/releases/v1.2.tar.gz"
+}
+
+type downloadStat struct {
+	Site      string    `json:"site,omitempty"`
+	Path      string    `json:"path"`
+	Downloads int64     `json:"downloads"`
+	Bytes     int64     `json:"bytes"`
+	Last      time.Time `json:"last"`
+}
+
+var (
+	downloadsMu    sync.Mutex
+	downloads      = make(map[downloadKey]*downloadStat)
+	downloadsDirty bool
+)
+
+func init() {
+	registerJob("save-downloads", downloadsSaveInterval, saveDownloadStats)
+	adminMux.HandleFunc("/downloads", handleDownloadsAdmin)
+}
+
+func downloadStatsPath() string {
+	if DownloadStatsFile != "" {
+		return DownloadStatsFile
+	}
+	return filepath.Join(LogDir, "downloads.json")
+}
+
+// loadDownloadStats reads the saved counts, called once at startup. A
+// missing file is a fresh start.
+func loadDownloadStats() {
+	if !DownloadStats {
+		return
+	}
+	data, err := os.ReadFile(downloadStatsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logWarnf("downloads", "Reading %s: %v", downloadStatsPath(), err)
+		}
+		return
+	}
+	var saved []*downloadStat
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logWarnf("downloads", "Reading %s: %v", downloadStatsPath(), err)
+		return
+	}
+	downloadsMu.Lock()
+	for _, s := range saved {
+		downloads[downloadKey{s.Site, s.Path}] = s
+	}
+	downloadsMu.Unlock()
+	logInfof("downloads", "Loaded download counts for %d files", len(saved))
+}
+
+// saveDownloadStats writes the counts if they changed, through a
+// temporary file so a crash never leaves half a file behind.
+func saveDownloadStats() error {
+	if !DownloadStats {
+		return nil
+	}
+	downloadsMu.Lock()
+	if !downloadsDirty {
+		downloadsMu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(downloadList("*", ""), "", "  ")
+	downloadsDirty = false
+	downloadsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := downloadStatsPath()
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		downloadsMu.Lock()
+		downloadsDirty = true //try again next time
+		downloadsMu.Unlock()
+	}
+	return err
+}
+
+// recordDownload counts the parts of the file at localPath sent for
+// req.
+func recordDownload(req *request, localPath string, sent []byteRange) {
+	if !DownloadStats || req.method != "GET" || req.errorPage {
+		return
+	}
+	rel, err := filepath.Rel(req.site.root, localPath)
+	if err != nil {
+		return
+	}
+	key := downloadKey{site: req.site.host, path: "/" + filepath.ToSlash(rel)}
+
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+	s, ok := downloads[key]
+	if !ok {
+		s = &downloadStat{Site: key.site, Path: key.path}
+		downloads[key] = s
+	}
+	if len(sent) > 0 && sent[0].start == 0 {
+		s.Downloads++
+	}
+	for _, r := range sent {
+		s.Bytes += r.end - r.start
+	}
+	s.Last = time.Now().UTC()
+	downloadsDirty = true
+}
+
+// downloadList copies the counts for site (all sites if "*") at or
+// below prefix, most downloaded first. Callers hold downloadsMu.
+func downloadList(site, prefix string) []downloadStat {
+	out := []downloadStat{}
+	for key, s := range downloads {
+		if site != "*" && key.site != site {
+			continue
+		}
+		if prefix != "" && key.path != prefix && !strings.HasPrefix(key.path, strings.TrimSuffix(prefix, "/")+"/") {
+			continue
+		}
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, k int) bool {
+		if out[i].Downloads != out[k].Downloads {
+			return out[i].Downloads > out[k].Downloads
+		}
+		return out[i].Site+out[i].Path < out[k].Site+out[k].Path
+	})
+	return out
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Admin API: /downloads
+//    GET → per-file counts, most downloaded first. ?site=<host>
+//          (default: every site), ?prefix=/releases/, ?limit=50
+// ─────────────────────────────────────────────────────────────────
+
+func handleDownloadsAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	site := r.URL.Query().Get("site")
+	if site == "" {
+		site = "*"
+	}
+	downloadsMu.Lock()
+	list := downloadList(site, r.URL.Query().Get("prefix"))
+	downloadsMu.Unlock()
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  ?stats view
+// ─────────────────────────────────────────────────────────────────
+
+// isDownloadStatsQuery reports whether req asks for "?stats".
+func isDownloadStatsQuery(req *request) bool {
+	if !DownloadStats || !DownloadStatsView {
+		return false
+	}
+	return req.query().Has("stats")
+}
+
+func serveDownloadStats(w ResponseWriter, req *request) error {
+	if !readMethod(req.method) {
+		return onlyMethods("GET", "HEAD")
+	}
+	cleanPath, err := sanitizePath(req.path())
+	if err != nil {
+		return ErrForbidden
+	}
+
+	downloadsMu.Lock()
+	list := downloadList(req.site.host, cleanPath)
+	downloadsMu.Unlock()
+	total := downloadStat{Site: req.site.host, Path: cleanPath}
+	for _, s := range list {
+		total.Downloads += s.Downloads
+		total.Bytes += s.Bytes
+		if s.Last.After(total.Last) {
+			total.Last = s.Last
+		}
+	}
+
+	body, _ := json.MarshalIndent(struct {
+		downloadStat
+		Files []downloadStat `json:"files"`
+	}{total, list}, "", "  ")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeBody(w, 200, "application/json", append(body, '\n'))
+	return nil
+}
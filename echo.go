@@ -0,0 +1,86 @@
+// echo.go
+
+package main
+
+import (
+	"encoding/json" //the echo
+	"fmt"           //redaction note
+	"io"            //counting the body
+	"net"           //allow-list
+	"strings"       //path + query
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  /debug/echo
+//    - Answers with the request exactly as Helix parsed it: request
+//      line, headers, the size of the body (however it was framed),
+//      the client address we saw and what we made of the client.
+//      Handy when a proxy or LB in front of us rewrites headers and
+//      nobody knows what actually arrives.
+//    - Only for clients in EchoAllowedCIDRs; everyone else gets a 404
+//      as if it didn't exist. Empty list disables it.
+//    - Credentials (Authorization, Cookie) are replaced by
+//      their length.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	EchoPath         = "/debug/echo"
+	EchoAllowedCIDRs = []string{"127.0.0.0/8", "::1/128"}
+)
+
+var echoRedacted = map[string]bool{"Authorization": true, "Cookie": true, "Proxy-Authorization": true}
+
+// isEchoPath takes a request's cleanPath().
+func isEchoPath(path string) bool {
+	return path == EchoPath
+}
+
+func echoAllowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, cidr := range EchoAllowedCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func serveEcho(w ResponseWriter, req *request) error {
+	ip := clientIP(req.clientAddr)
+	if !echoAllowed(ip) {
+		return ErrNotFound
+	}
+	headers := make(map[string]string, len(req.header))
+	for name, values := range req.header {
+		value := strings.Join(values, ", ")
+		if echoRedacted[name] {
+			value = fmt.Sprintf("[redacted, %d bytes]", len(value))
+		}
+		headers[name] = value
+	}
+	bodyBytes, err := io.Copy(io.Discard, req.body)
+	if err != nil {
+		return err
+	}
+	path, query, _ := strings.Cut(req.rawPath, "?")
+	body, _ := json.MarshalIndent(map[string]any{
+		"id":           req.id,
+		"request_line": req.requestLine,
+		"method":       req.method,
+		"path":         path,
+		"query":        query,
+		"version":      req.version,
+		"headers":      headers,
+		"body_bytes":   bodyBytes,
+		"client_addr":  req.clientAddr,
+		"client_ip":    ip,
+		"client_tag":   req.clientTag.String(),
+		"tls":          nil, //plain TCP only, for now
+	}, "", "  ")
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, 200, "application/json", append(body, '\n'))
+	return nil
+}
@@ -0,0 +1,180 @@
+// errorpages.go
+
+package main
+
+import (
+	"bytes"         //rendering the template
+	"encoding/json" //JSON error bodies
+	"fmt"           //"/404.html" style paths
+	"html/template" //built-in error pages
+	"strconv"       //q-values, Retry-After
+	"strings"       //parsing Accept
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Error documents
+//    - For every status below we look for a custom page, in order:
+//        1. ErrorDocuments[status] (any path under the document root)
+//        2. /<status>.html in the document root
+//        3. the built-in assets/error.html template
+// ─────────────────────────────────────────────────────────────────
+
+// ErrorDocuments overrides the page served for a status code, e.g.
+// {404: "/errors/missing.html"}. Paths are relative to the document root.
+var ErrorDocuments = map[int]string{}
+
+// errorMessages are the statuses we have pages for, with the sentence
+// shown by the built-in template.
+var errorMessages = map[int]string{
+	400: "The server could not understand your request.",
+	401: "You need to log in to access this page.",
+	403: "You don't have permission to access this page.",
+	404: "Sorry, the page you requested could not be found.",
+	405: "This method is not supported for the requested resource.",
+	412: "The page has changed since you last loaded it.",
+	413: "The request is larger than the server is willing to process.",
+	416: "The requested range is not available in this file.",
+	429: "You are sending too many requests. Please slow down and try again later.",
+	500: "Something went wrong on our end. Please try again later.",
+	501: "The server does not support what this request needs.",
+	502: "The server got an invalid response from an upstream server.",
+	503: "The server is temporarily unable to handle your request. Please try again later.",
+	504: "The server did not get a response in time. Please try again later.",
+	505: "This HTTP version is not supported by the server.",
+}
+
+// errorDocCandidates lists the custom pages to try for a status code,
+// most specific first.
+func errorDocCandidates(site *siteSettings, statusCode int) []string {
+	if _, ok := errorMessages[statusCode]; !ok {
+		return nil
+	}
+	var candidates []string
+	if doc, ok := site.errorDocs[statusCode]; ok {
+		candidates = append(candidates, doc)
+	}
+	return append(candidates, fmt.Sprintf("/%d.html", statusCode))
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  builtinErrorPage(statusCode int) []byte
+//    - Last link of the fallback chain, always works.
+// ─────────────────────────────────────────────────────────────────
+
+// errorPageTemplate is the styled page compiled into the binary (see assets.go).
+var errorPageTemplate = template.Must(template.ParseFS(assetFS, "assets/error.html"))
+
+func builtinErrorPage(statusCode int) []byte {
+	var buf bytes.Buffer
+	errorPageTemplate.Execute(&buf, struct {
+		Code    int
+		Text    string
+		Message string
+	}{statusCode, statusTextFor(statusCode), errorMessages[statusCode]})
+	return buf.Bytes()
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Error body formats
+//    - API clients (fetch, curl -H 'Accept: application/json', ...)
+//      get {"status":404,"error":"Not Found","message":"..."}; clients
+//      asking for text/plain get a single line; everyone else HTML.
+// ─────────────────────────────────────────────────────────────────
+
+const (
+	formatHTML  = "text/html"
+	formatJSON  = "application/json"
+	formatPlain = "text/plain"
+)
+
+// errorFormats in order of preference when the client likes several
+// equally (browsers and "*/*" end up with HTML).
+var errorFormats = []string{formatHTML, formatJSON, formatPlain}
+
+// ─────────────────────────────────────────────────────────────────
+//  negotiateErrorFormat(accept string) string
+//    - Picks the format from errorFormats with the highest q-value in
+//      the Accept header. Exact types beat "type/*", which beats "*/*".
+//    - No Accept header at all means HTML.
+// ─────────────────────────────────────────────────────────────────
+
+func negotiateErrorFormat(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return formatHTML
+	}
+	best, bestQ := formatHTML, 0.0
+	for _, format := range errorFormats {
+		if q := acceptQuality(accept, format); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value the Accept header gives mediaType,
+// using the most specific matching range (0 if none matches).
+func acceptQuality(accept, mediaType string) float64 {
+	majorType, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		rng := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		var s int
+		switch rng {
+		case mediaType:
+			s = 2
+		case majorType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		rangeQ := 1.0
+		for _, param := range fields[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(k) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					rangeQ = parsed
+				}
+			}
+		}
+		q, specificity = rangeQ, s
+	}
+	return q
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  writeErrorBody(w, statusCode, format)
+//    - JSON and plain text bodies. If a Retry-After header was set
+//      (429/503), JSON clients get it as "retry_after" too.
+// ─────────────────────────────────────────────────────────────────
+
+func writeErrorBody(w ResponseWriter, statusCode int, format string) {
+	message := errorMessages[statusCode]
+	if message == "" {
+		message = statusTextFor(statusCode)
+	}
+
+	if format == formatPlain {
+		body := fmt.Sprintf("%d %s: %s\n", statusCode, statusTextFor(statusCode), message)
+		writeBody(w, statusCode, "text/plain; charset=utf-8", []byte(body))
+		return
+	}
+
+	payload := map[string]any{
+		"status":  statusCode,
+		"error":   statusTextFor(statusCode),
+		"message": message,
+	}
+	if secs, err := strconv.Atoi(w.Header().Get("Retry-After")); err == nil {
+		payload["retry_after"] = secs
+	}
+	body, _ := json.Marshal(payload)
+	writeBody(w, statusCode, "application/json", body)
+}
@@ -0,0 +1,94 @@
+// errors.go
+
+package main
+
+import (
+	"errors"   //sentinels + errors.Is
+	"io/fs"    //mapping raw OS errors
+	"net/http" //status texts
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Error kinds
+//    - Handlers return (possibly wrapped) errors instead of writing
+//      error pages themselves, e.g.
+//          return fmt.Errorf("%w: open %s: %v", ErrForbidden, path, err)
+//    - handleError() maps them to a status code in one place.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	ErrBadRequest       = errors.New("bad request")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrNotFound         = errors.New("not found")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrTooLarge         = errors.New("too large")
+	ErrUpstream         = errors.New("upstream failure")
+	ErrGatewayTimeout   = errors.New("gateway timeout")
+	ErrRequestTimeout   = errors.New("request timeout")
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  statusForError(err error) int
+//    - Our own error kinds first, then a couple of raw OS errors a
+//      handler might pass through unwrapped. Anything else is a 500.
+// ─────────────────────────────────────────────────────────────────
+
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, ErrBadRequest):
+		return 400
+	case errors.Is(err, ErrUnauthorized):
+		return 401
+	case errors.Is(err, ErrForbidden), errors.Is(err, fs.ErrPermission):
+		return 403
+	case errors.Is(err, ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return 404
+	case errors.Is(err, ErrMethodNotAllowed):
+		return 405
+	case errors.Is(err, ErrRequestTimeout):
+		return 408
+	case errors.Is(err, ErrPreconditionFailed):
+		return 412
+	case errors.Is(err, ErrTooLarge):
+		return 413
+	case errors.Is(err, ErrRangeNotSatisfiable):
+		return 416
+	case errors.Is(err, ErrNotImplemented):
+		return 501
+	case errors.Is(err, ErrUpstream):
+		return 502
+	case errors.Is(err, ErrGatewayTimeout):
+		return 504
+	case errors.Is(err, ErrVersionNotSupported):
+		return 505
+	}
+	return 500
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  handleError(w, req, err)
+//    - Logs the cause for the statuses worth looking at (403s may be
+//      traversal attempts or permission problems, 5xx are our fault
+//      but for a 501, which is about what the client asked for).
+//    - Serves the matching error page.
+// ─────────────────────────────────────────────────────────────────
+
+func handleError(w ResponseWriter, req *request, err error) {
+	statusCode := statusForError(err)
+	if statusCode == 403 || (statusCode >= 500 && statusCode != 501) {
+		logErrorf("server", "id=%s – %s – %q – %d: %v", req.id, logAddr(req.clientAddr), req.requestLine, statusCode, err)
+	}
+	if allow := allowHeader(err); allow != "" {
+		w.Header().Set("Allow", allow) //405s say what would work, see options.go
+	}
+	serveErrorPage(w, req, statusCode)
+}
+
+// statusTextFor gives the reason phrase for a status code ("Not Found").
+func statusTextFor(statusCode int) string {
+	if text := http.StatusText(statusCode); text != "" {
+		return text
+	}
+	return "Error"
+}
@@ -0,0 +1,241 @@
+// exec.go
+
+package main
+
+import (
+	"bytes"         //capturing stdout
+	"context"       //timeouts
+	"errors"        //lingering output pipes
+	"fmt"           //wrapping errors
+	"io"            //limiting output
+	"os/exec"       //running the command
+	"path/filepath" //absolute commands
+	"strconv"       //Content-Length
+	"strings"       //query splitting
+	"sync"          //per-route semaphores
+	"time"          //timeouts
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Exec handler
+//    - Maps a URL path to a fixed, allow-listed command whose stdout
+//      becomes the response body. Think small status scripts, not CGI:
+//      nothing from the request ends up on the command line.
+//    - Each run gets a timeout (→ 504), a clean environment, and the
+//      route has a cap on concurrent runs (→ 503 when full).
+//    - Routes are in the config, by exact path:
+//          exec:
+//            /status:
+//              command: [/usr/local/bin/helix-status, --short]
+//              timeout: 2s
+//              content_type: application/json
+//              max_concurrent: 2
+//    - HEAD runs the command like GET and drops the body, so the
+//      headers (Content-Length included) are the real ones.
+//    - Output pipes are closed execWaitDelay after the command exits
+//      or times out, so a background child still holding stdout open
+//      can't keep the request waiting.
+// ─────────────────────────────────────────────────────────────────
+
+type execRoute struct {
+	Command       []string      `json:"command"`        //argv, Command[0] is an absolute path
+	Timeout       time.Duration `json:"timeout"`        //0 = DefaultExecTimeout
+	ContentType   string        `json:"content_type"`   //"" = text/plain; charset=utf-8
+	MaxConcurrent int           `json:"max_concurrent"` //0 = DefaultExecConcurrency
+}
+
+// ExecRoutes is the allow-list (config "exec"), keyed by exact URL path, e.g.
+//
+//	"/status": {Command: []string{"/usr/local/bin/helix-status"}}
+var ExecRoutes = map[string]execRoute{}
+
+const (
+	DefaultExecTimeout     = 5 * time.Second
+	DefaultExecConcurrency = 4
+	MaxExecOutput          = 1 << 20 //1 MiB of stdout, more is an error
+	execWaitDelay          = time.Second
+)
+
+// execPath is the only PATH commands get to see.
+const execPath = "/usr/local/bin:/usr/bin:/bin"
+
+var (
+	execSlotsMu sync.Mutex
+	execSlots   = make(map[string]chan struct{})
+)
+
+// execRouteFor returns the exec route for a request's cleanPath(), if any.
+func execRouteFor(path string) (string, execRoute, bool) {
+	route, ok := ExecRoutes[path]
+	return path, route, ok
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveExec(w, req, path, route) error
+// ─────────────────────────────────────────────────────────────────
+
+func serveExec(w ResponseWriter, req *request, path string, route execRoute) error {
+	if !readMethod(req.method) {
+		return onlyMethods("GET", "HEAD")
+	}
+
+	//Concurrency cap: don't queue, just say we're busy
+	slots := execSlotsFor(path, route)
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	default:
+		writeLimitResponse(w, req, 503, 0)
+		return nil
+	}
+
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, route.Command[0], route.Command[1:]...)
+	cmd.Env = execEnv(req)
+	cmd.Dir = "/"
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedBuffer{buf: &stdout, limit: MaxExecOutput}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: 4096}
+	cmd.WaitDelay = execWaitDelay
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %s ran longer than %s", ErrGatewayTimeout, route.Command[0], timeout)
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		//It exited fine; something it started kept the pipe open
+		logWarnf("exec", "%s left a process holding its output open", route.Command[0])
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v (stderr: %q)", ErrUpstream, route.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	ctype := route.ContentType
+	if ctype == "" {
+		ctype = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.Itoa(stdout.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	signResponse(w, req, stdout.Bytes())
+	w.WriteHeader(200)
+	w.Write(stdout.Bytes())
+	return nil
+}
+
+func execSlotsFor(path string, route execRoute) chan struct{} {
+	execSlotsMu.Lock()
+	defer execSlotsMu.Unlock()
+	slots, ok := execSlots[path]
+	if !ok {
+		n := route.MaxConcurrent
+		if n <= 0 {
+			n = DefaultExecConcurrency
+		}
+		slots = make(chan struct{}, n)
+		execSlots[path] = slots
+	}
+	return slots
+}
+
+// execEnv is everything the command gets in its environment: a fixed
+// PATH and a few request facts. Nothing is inherited from our own
+// environment, so secrets in it can't leak into script output.
+func execEnv(req *request) []string {
+	path, query, _ := strings.Cut(req.rawPath, "?")
+	return []string{
+		"PATH=" + execPath,
+		"LANG=C",
+		"HELIX_REQUEST_ID=" + req.id,
+		"HELIX_REQUEST_METHOD=" + req.method,
+		"HELIX_REQUEST_PATH=" + envSafe(path),
+		"HELIX_QUERY_STRING=" + envSafe(query),
+		"HELIX_CLIENT_IP=" + clientIP(req.clientAddr),
+	}
+}
+
+// envSafe drops control characters (NUL would even fail exec).
+func envSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// limitedBuffer fails writes once limit bytes were stored, which makes
+// the command fail instead of us buffering unlimited output.
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	if l.buf.Len()+len(p) > l.limit {
+		return 0, io.ErrShortWrite
+	}
+	return l.buf.Write(p)
+}
+
+// setExecRoutes reads "exec": a mapping of path to command, timeout,
+// content_type and max_concurrent.
+func setExecRoutes(v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a mapping of path to command, timeout, content_type, max_concurrent")
+	}
+	routes := make(map[string]execRoute, len(m))
+	for path, item := range m {
+		fields, ok := item.(map[string]any)
+		if !ok || !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("%s: expected a path with command, timeout, content_type, max_concurrent", path)
+		}
+		var route execRoute
+		for name, fv := range fields {
+			if name == "command" {
+				var command []string
+				if err := stringListSetting(name, &command).set(fv); err != nil {
+					return fmt.Errorf("%s.command: %w", path, err)
+				}
+				route.Command = command
+				continue
+			}
+			s, err := configString(fv)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", path, name, err)
+			}
+			switch name {
+			case "timeout":
+				d, err := time.ParseDuration(s)
+				if err != nil || d < 0 {
+					return fmt.Errorf("%s.timeout: expected a duration like 5s, got %q", path, s)
+				}
+				route.Timeout = d
+			case "content_type":
+				route.ContentType = s
+			case "max_concurrent":
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					return fmt.Errorf("%s.max_concurrent: expected a number, got %q", path, s)
+				}
+				route.MaxConcurrent = n
+			default:
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+		}
+		if len(route.Command) == 0 || !filepath.IsAbs(route.Command[0]) {
+			return fmt.Errorf("%s: command must start with an absolute path", path)
+		}
+		routes[path] = route
+	}
+	ExecRoutes = routes
+	return nil
+}
@@ -0,0 +1,222 @@
+// explain.go
+
+package main
+
+import (
+	"flag"    //same flags as the server
+	"fmt"     //report lines
+	"io"      //discarding log output
+	"log"     //logger for the loaders
+	"strings" //flag detection
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  helix explain [flags] /path [-host example.com] [-method GET]
+//    - Loads the configuration like the server would and prints which
+//      site, root, route limit and handler a request would hit, the
+//      file it maps to, and any internal rewrite (SPA fallback, 200
+//      rules in _redirects) or error page on the way.
+//    - Goes through matchRoute(), matchRedirect(), resolveStatic() and
+//      spaFallbackFor(), the same code dispatch() uses, so it can't
+//      drift from the live server. Nothing is served or executed.
+//    - Per-client decisions (rate limits, bans, challenges) depend on
+//      who asks, not on the path, and are not shown.
+// ─────────────────────────────────────────────────────────────────
+
+func runExplain(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	defineFlags(fs)
+	host := fs.String("host", "", "Host header of the request (picks the vhost)")
+	method := fs.String("method", "GET", "request method")
+
+	//The path may come before or after the flags
+	var paths []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		paths = append(paths, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(paths) != 1 || !strings.HasPrefix(paths[0], "/") {
+		fmt.Println("Usage: helix explain [flags] /path [-host example.com] [-method GET]")
+		return 2
+	}
+	logWriter = log.New(io.Discard, "", 0) //the loaders log, we report
+
+	if err := loadSettings(fs); err != nil {
+		fmt.Println("Invalid configuration:", err)
+		return 1
+	}
+	if err := checkFlags(); err != nil {
+		fmt.Println("Invalid configuration:", err)
+		return 1
+	}
+	if err := loadListingTemplate(); err != nil {
+		fmt.Println("Invalid autoindex template:", err)
+		return 1
+	}
+
+	normalized, err := normalizeHost(*host)
+	if err != nil {
+		fmt.Println("Invalid -host:", err)
+		return 2
+	}
+	req := &request{
+		method:  strings.ToUpper(*method),
+		rawPath: paths[0],
+		version: "HTTP/1.1",
+		header:  Header{"Host": {*host}},
+		host:    normalized,
+		body:    strings.NewReader(""),
+		site:    siteFromSettings().siteFor(normalized),
+	}
+	req.requestLine = req.method + " " + req.rawPath + " " + req.version
+	explainRequest(req)
+	return 0
+}
+
+func explainLine(key, format string, args ...any) {
+	fmt.Printf("%-12s %s\n", key+":", fmt.Sprintf(format, args...))
+}
+
+func explainRequest(req *request) {
+	explainLine("request", "%s (Host: %q)", req.requestLine, req.header.Get("Host"))
+	if req.site.host != "" {
+		explainLine("site", "vhost %s", req.site.host)
+	} else {
+		explainLine("site", "default")
+	}
+	explainLine("root", "%s", req.site.root)
+	if limit, ok := routeLimitFor(req.cleanPath()); ok {
+		perIP := ""
+		if limit.MaxQueuePerIP > 0 {
+			perIP = fmt.Sprintf(", %d per IP", limit.MaxQueuePerIP)
+		}
+		explainLine("route limit", "%s (max %d concurrent, queue of %d%s)", limit.Prefix, limit.MaxConcurrent, limit.MaxQueue, perIP)
+	}
+	headers := siteHeadersFor(req)
+	for _, name := range sortedKeys(headers) {
+		explainLine("header", "%s: %s", name, strings.Join(headers[name], ", "))
+	}
+
+	for {
+		name, _ := matchRoute(req)
+		explainLine("handler", "%s", name)
+
+		var rewrite string
+		if m, ok := matchRedirect(req); ok && name == m.String() {
+			//200 rules are rewrites, everything else answers right here
+			if m.hidden || m.rule.status != 200 {
+				explainRedirect(m)
+				return
+			}
+			rewrite = m.target
+		} else if name != "static" {
+			//Other handlers decide at request time; nothing to resolve
+			return
+		} else {
+			err := explainStatic(req)
+			fallback, ok := spaFallbackFor(req, err)
+			if !ok {
+				if err != nil {
+					explainErrorPage(req, statusForError(err))
+				}
+				return
+			}
+			rewrite = fallback
+		}
+
+		if req.redirects >= MaxInternalRedirects {
+			explainLine("result", "500 %s", ErrRedirectLoop)
+			return
+		}
+		explainLine("rewrite", "to %s", rewrite)
+		sub := *req
+		sub.rawPath = rewrite
+		sub.redirects++
+		req = &sub
+	}
+}
+
+// explainStatic reports what serveStatic would do with req.
+func explainStatic(req *request) error {
+	if !readMethod(req.method) {
+		err := onlyMethods("GET", "HEAD")
+		if req.method == "OPTIONS" {
+			explainLine("result", "204 %s (Allow: %s)", statusTextFor(204), allowHeader(err))
+			return nil
+		}
+		explainLine("result", "405 %s", statusTextFor(405))
+		return err
+	}
+	target, err := resolveStatic(req.site, req.rawPath)
+	if err != nil && showsWelcome(req, err) {
+		explainLine("result", "200 built-in welcome page (%v)", err)
+		return nil
+	}
+	if err != nil {
+		status := statusForError(err)
+		explainLine("result", "%d %s (%v)", status, statusTextFor(status), err)
+		return err
+	}
+	if target.redirect != "" {
+		explainLine("result", "301 Location: %s (canonical URL)", target.redirect)
+		return nil
+	}
+	if target.listing {
+		explainLine("result", "200 directory listing of %s", target.localPath)
+		return nil
+	}
+	explainLine("file", "%s (%d bytes)", target.localPath, target.info.Size())
+	meta, err := sidecarFor(target.localPath)
+	if err != nil {
+		explainLine("result", "403 %s (%v)", statusTextFor(403), err)
+		return err
+	}
+	ctype := detectContentType(req.site, target.localPath)
+	if meta != nil {
+		explainLine("sidecar", "%s", target.localPath+sidecarSuffix)
+		if meta.Auth != nil {
+			explainLine("auth", "Basic, %d user(s), 401 without credentials", len(meta.Auth.Users))
+		}
+		if meta.ContentType != "" {
+			ctype = meta.ContentType
+		}
+	}
+	explainLine("result", "200 %s", ctype)
+	return nil
+}
+
+// explainRedirect reports what a non-rewrite rule answers.
+func explainRedirect(m redirectMatch) {
+	switch {
+	case m.hidden:
+		explainLine("result", "404 %s", statusTextFor(404))
+	case m.rule.status < 400:
+		explainLine("result", "%d Location: %s", m.rule.status, m.target)
+	default:
+		explainLine("result", "%d %s", m.rule.status, statusTextFor(m.rule.status))
+		explainLine("error page", "%s", m.target)
+	}
+}
+
+// explainErrorPage reports which page serveErrorPage would send.
+func explainErrorPage(req *request, statusCode int) {
+	for _, doc := range errorDocCandidates(req.site, statusCode) {
+		sub := *req
+		sub.method, sub.rawPath = "GET", doc
+		if name, _ := matchRoute(&sub); name != "static" {
+			explainLine("error page", "%s (%s)", doc, name)
+			return
+		}
+		if target, err := resolveStatic(sub.site, doc); err == nil && !target.listing {
+			explainLine("error page", "%s (%s)", doc, target.localPath)
+			return
+		}
+	}
+	explainLine("error page", "built-in assets/error.html")
+}
@@ -0,0 +1,156 @@
+// fastlane.go
+
+package main
+
+import (
+	"maps"    //copying the header
+	"os"      //re-checking the file
+	"strings" //query checks
+	"sync"    //guarding the entries
+	"time"    //entry lifetime
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Fast lane
+//    - The full path for a static file (route table, _redirects,
+//      sidecars, ETag, file cache, site headers) costs a good part of
+//      the latency of a tiny cached asset. After serveStatic answered
+//      a plain GET/HEAD with a 200 of at most FastLaneMaxSize bytes
+//      from the file cache, the finished response is kept: the body
+//      and its headers already serialized, for a 200 and for a 304.
+//      serveNext answers the same URL on the same site straight from
+//      it, adding only Date, Connection and X-Request-Id, without
+//      going through handleRequest.
+//    - Overload shedding and the per-IP rate limit still apply;
+//      everything that could answer differently per client or per
+//      request is never remembered: query strings, Range requests,
+//      sidecars (auth), route limits, mirrors, internal redirects.
+//      Challenges and chaos switch the fast lane off while on.
+//    - The file is stat'ed on every hit, so edits show up at once.
+//      Entries live for fastLaneTTL, which bounds how long a new
+//      _redirects rule or _headers line can be missed, and a reload
+//      starts over (they belong to the old site settings).
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	FastLane        = true
+	FastLaneMaxSize = int64(16 << 10) //bytes
+)
+
+const (
+	fastLaneTTL        = time.Second
+	fastLaneMaxEntries = 4096 //all dropped when reached
+)
+
+type fastEntry struct {
+	site      *siteSettings
+	localPath string
+	modTime   time.Time
+	size      int64
+	etag      string
+	header    []byte //header lines as sent, without the per-request ones
+	header304 []byte //the same without the body's headers
+	body      []byte //shared with the file cache
+	stored    time.Time
+}
+
+var (
+	fastMu      sync.RWMutex
+	fastEntries = make(map[string]*fastEntry) //by Host + request target
+)
+
+func fastKey(req *request) string {
+	return req.site.host + " " + req.rawPath
+}
+
+// fastLaneOpen reports whether req may use (or fill) the fast lane.
+func fastLaneOpen(req *request) bool {
+	return FastLane && readMethod(req.method) && !ChallengeEnabled && !chaosOn.Load() &&
+		!strings.Contains(req.rawPath, "?") && req.header.Get("Range") == ""
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveFast(w, req) bool
+//    - Called from serveNext() before handleRequest(); false means
+//      nothing was written and the request takes the full path.
+// ─────────────────────────────────────────────────────────────────
+
+func serveFast(w *responseWriter, req *request) bool {
+	if !fastLaneOpen(req) {
+		return false
+	}
+	fastMu.RLock()
+	e, ok := fastEntries[fastKey(req)]
+	fastMu.RUnlock()
+	if !ok || e.site != req.site || time.Since(e.stored) > fastLaneTTL {
+		return false
+	}
+	info, err := os.Stat(e.localPath)
+	if err != nil || info.Size() != e.size || !info.ModTime().Equal(e.modTime) {
+		return false
+	}
+	if checkPreconditions(req, e.etag, e.modTime) != nil {
+		return false //the 412 comes from the full path
+	}
+
+	//The same gates handleRequest starts with; saying no is left to it
+	if overloaded(activeConns.Add(1)) {
+		activeConns.Add(-1)
+		return false
+	}
+	defer activeConns.Add(-1)
+	ip := clientIP(req.clientAddr)
+	req.clientTag = classifyClient(ip, req.header.Get("User-Agent"))
+	if ok, _ := allowRequest(ip, req.clientTag); !ok {
+		return false
+	}
+	req.clientTag = verifyClaim(ip, req.clientTag)
+
+	incCounter("fast_lane", []string{"result:hit"}, 1)
+	if notModified(req, e.etag, e.modTime) {
+		w.writeHeaderBlock(304, e.header304)
+		return true
+	}
+	w.writeHeaderBlock(200, e.header)
+	w.Write(e.body)
+	recordDownload(req, e.localPath, []byteRange{{0, e.size}})
+	return true
+}
+
+// rememberFast keeps the 200 serveStatic just sent for req, if it is
+// one the fast lane may answer (see above). h is the header as sent.
+func rememberFast(req *request, localPath string, info os.FileInfo, etag string, h Header, body []byte) {
+	if !fastLaneOpen(req) || int64(len(body)) > FastLaneMaxSize || req.redirects > 0 || req.errorPage {
+		return
+	}
+	if _, limited := routeLimitFor(req.cleanPath()); limited {
+		return
+	}
+	if _, mirrored := mirrorSetFor(req.cleanPath()); mirrored {
+		return
+	}
+	header := maps.Clone(h)
+	for _, name := range []string{"Date", "Connection", "X-Request-Id"} {
+		header.Del(name)
+	}
+	e := &fastEntry{site: req.site, localPath: localPath, modTime: info.ModTime(), size: info.Size(),
+		etag: etag, header: appendHeaderLines(nil, header), body: body, stored: time.Now()}
+	//What serveStatic sends for a 304: the file's headers, no body
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Digest", "X-Helix-Signature"} {
+		header.Del(name)
+	}
+	e.header304 = appendHeaderLines(nil, header)
+
+	fastMu.Lock()
+	defer fastMu.Unlock()
+	if len(fastEntries) >= fastLaneMaxEntries {
+		clear(fastEntries)
+	}
+	fastEntries[fastKey(req)] = e
+}
+
+func clearFastLane() {
+	fastMu.Lock()
+	defer fastMu.Unlock()
+	clear(fastEntries)
+}
@@ -0,0 +1,92 @@
+// fdbudget.go
+
+package main
+
+import (
+	"expvar"      //budget in /debug/vars
+	"net"         //the connection to turn away
+	"sync/atomic" //open connection count
+	"time"        //write deadline
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  File descriptor budget
+//    - At startup the soft open files limit (RLIMIT_NOFILE) is raised
+//      to the hard limit; Go does this on its own too, but this way
+//      the limit we got is logged.
+//    - Every connection holds a descriptor for as long as it's open
+//      (idle keep-alive ones too). The rest of the limit, fdReserve,
+//      is kept for everything else: log files, the files being
+//      served, outbound requests, listeners. (The file cache holds
+//      bytes, not descriptors.)
+//    - Once the open connections use up their share, new ones get a
+//      canned "503 Service Unavailable" with Retry-After and are
+//      closed right after accept, instead of some request failing
+//      halfway because a file or log couldn't be opened. Reaching the
+//      budget logs a WARN, going back under it an INFO; each turned
+//      away connection counts in connections_rejected{reason:fd_budget}.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	fdLimit    int64 //soft RLIMIT_NOFILE, 0 if unknown (no budget)
+	connBudget int64 //connections we take at most
+	openConns  atomic.Int64
+	fdBudgetAt atomic.Bool //at the budget right now
+)
+
+// fdReserve is how much of limit we keep for things other than
+// connections: an eighth, at least 32 and at most 1024.
+func fdReserve(limit int64) int64 {
+	return min(max(limit/8, 32), 1024)
+}
+
+// fdBudgetResponse is all a turned away connection gets.
+const fdBudgetResponse = "HTTP/1.1 503 Service Unavailable\r\nRetry-After: 5\r\nContent-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Length: 20\r\nConnection: close\r\n\r\nToo many connections"
+
+func init() {
+	expvar.Publish("helix_fd_budget", expvar.Func(func() any {
+		return map[string]int64{"limit": fdLimit, "connection_budget": connBudget, "open_connections": openConns.Load()}
+	}))
+}
+
+// setupFDBudget raises the open files limit and works out the
+// connection budget, called once at startup.
+func setupFDBudget() {
+	limit, err := raiseOpenFileLimit()
+	if err != nil {
+		logWarnf("server", "Could not raise the open files limit (staying at %d): %v", limit, err)
+	}
+	if limit == 0 || limit > 1<<30 {
+		return //unknown or unlimited, no budget needed
+	}
+	fdLimit = int64(limit)
+	connBudget = max(fdLimit-fdReserve(fdLimit), fdLimit/2)
+	logInfof("server", "Open files limit %d, up to %d connections", fdLimit, connBudget)
+}
+
+// admitConnection counts conn as open, or answers it with a 503 and
+// closes it if the budget is used up. The caller calls connClosed
+// once an admitted connection is closed.
+func admitConnection(conn net.Conn) bool {
+	n := openConns.Add(1)
+	if connBudget == 0 || n <= connBudget {
+		if fdBudgetAt.Load() && n < connBudget*9/10 && fdBudgetAt.CompareAndSwap(true, false) {
+			logInfof("server", "Back under the connection budget (%d open)", n)
+		}
+		return true
+	}
+	openConns.Add(-1)
+	if fdBudgetAt.CompareAndSwap(false, true) {
+		logWarnf("server", "Connection budget of %d reached (open files limit %d), turning new connections away", connBudget, fdLimit)
+	}
+	incCounter("connections_rejected", []string{"reason:fd_budget"}, 1)
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte(fdBudgetResponse))
+	conn.Close()
+	return false
+}
+
+func connClosed() {
+	openConns.Add(-1)
+}
@@ -0,0 +1,10 @@
+// fdlimit_other.go
+
+//go:build !unix
+
+package main
+
+// raiseOpenFileLimit isn't implemented here; 0 means no fd budget.
+func raiseOpenFileLimit() (limit uint64, err error) {
+	return 0, nil
+}
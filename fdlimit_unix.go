@@ -0,0 +1,24 @@
+// fdlimit_unix.go
+
+//go:build unix
+
+package main
+
+import "syscall" //getrlimit/setrlimit
+
+// raiseOpenFileLimit lifts the soft RLIMIT_NOFILE to the hard limit and
+// returns the soft limit in effect afterwards.
+func raiseOpenFileLimit() (limit uint64, err error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+	if rl.Cur < rl.Max {
+		current := uint64(rl.Cur)
+		rl.Cur = rl.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+			return current, err
+		}
+	}
+	return uint64(rl.Cur), nil
+}
@@ -0,0 +1,316 @@
+// feed.go
+
+package main
+
+import (
+	"encoding/xml"  //Atom + RSS documents
+	"fmt"           //front matter errors
+	"io/fs"         //walking the content directory
+	"os"            //reading entries
+	"path/filepath" //paths
+	"sort"          //newest first
+	"strings"       //front matter, summaries
+	"sync"          //cached feed
+	"time"          //dates
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Feed (off unless FeedDir is set)
+//    - GET FeedPath (/feed.xml) returns an Atom (or, with FeedFormat
+//      "rss", RSS 2.0) feed of the newest FeedMaxItems .html/.md files
+//      under FeedDir, a URL path in the default document root such as
+//      "/posts/".
+//    - Title, date and summary come from front matter when a file
+//      starts with one:
+//        ---
+//        title: Hello
+//        date: 2024-05-01
+//        summary: First post
+//        ---
+//      otherwise from <title> / the first "# " heading, the
+//      modification time and <meta name="description">.
+//    - The feed is rebuilt when a file in the directory changes
+//      (checked by modification time and size on each request), so
+//      publishing a post needs nothing but copying the file.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	FeedDir      = "" //e.g. "/posts/"; "" disables the feed
+	FeedPath     = "/feed.xml"
+	FeedFormat   = "atom" //or "rss"
+	FeedTitle    = "Helix"
+	FeedSiteURL  = "" //absolute base for links, e.g. "https://example.com"
+	FeedMaxItems = 20
+)
+
+type feedItem struct {
+	Path    string //URL path
+	Title   string
+	Summary string
+	Date    time.Time
+}
+
+var (
+	feedMu          sync.Mutex
+	feedFingerprint string //of the files the cached feed was built from
+	feedBody        []byte
+)
+
+// isFeedPath takes a request's cleanPath().
+func isFeedPath(path string) bool {
+	return FeedDir != "" && path == FeedPath
+}
+
+func serveFeed(w ResponseWriter, req *request) error {
+	if !readMethod(req.method) {
+		return onlyMethods("GET", "HEAD")
+	}
+	body, err := currentFeed(req.site.root)
+	if err != nil {
+		return err
+	}
+	ctype := "application/atom+xml; charset=utf-8"
+	if FeedFormat == "rss" {
+		ctype = "application/rss+xml; charset=utf-8"
+	}
+	w.Header().Set("Cache-Control", "max-age=300")
+	writeBody(w, 200, ctype, body)
+	return nil
+}
+
+// currentFeed returns the cached feed, rebuilding it if a file changed.
+func currentFeed(root string) ([]byte, error) {
+	dir := filepath.Join(root, filepath.FromSlash(FeedDir))
+	files, fingerprint, err := feedFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: feed directory %s: %v", ErrNotFound, FeedDir, err)
+	}
+
+	feedMu.Lock()
+	defer feedMu.Unlock()
+	if fingerprint == feedFingerprint && feedBody != nil {
+		return feedBody, nil
+	}
+	var items []feedItem
+	for _, f := range files {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			continue
+		}
+		rel, _ := filepath.Rel(root, f.path)
+		items = append(items, feedEntry("/"+filepath.ToSlash(rel), data, f.modTime))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Date.After(items[j].Date) })
+	if len(items) > FeedMaxItems {
+		items = items[:FeedMaxItems]
+	}
+
+	var body []byte
+	if FeedFormat == "rss" {
+		body, err = renderRSS(items)
+	} else {
+		body, err = renderAtom(items)
+	}
+	if err != nil {
+		return nil, err
+	}
+	feedBody, feedFingerprint = body, fingerprint
+	logInfof("feed", "Feed rebuilt: %d items from %s", len(items), FeedDir)
+	return body, nil
+}
+
+type feedFile struct {
+	path    string
+	modTime time.Time
+}
+
+// feedFiles lists the entry files of dir, with a fingerprint that
+// changes whenever one of them is added, removed or modified.
+func feedFiles(dir string) ([]feedFile, string, error) {
+	var files []feedFile
+	var fp strings.Builder
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || (ext != ".html" && ext != ".md") || gated(path) {
+			return nil //behind sidecar auth or not an entry
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, feedFile{path: path, modTime: info.ModTime()})
+		fmt.Fprintf(&fp, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return files, fp.String(), err
+}
+
+// feedEntry reads title, date and summary from one file.
+func feedEntry(urlPath string, data []byte, modTime time.Time) feedItem {
+	item := feedItem{Path: urlPath, Date: modTime}
+	text := string(data)
+	if meta, rest, ok := frontMatter(text); ok {
+		item.Title = meta["title"]
+		item.Summary = meta["summary"]
+		if d, err := parseFeedDate(meta["date"]); err == nil {
+			item.Date = d
+		}
+		text = rest
+	}
+	if item.Title == "" {
+		if strings.HasSuffix(urlPath, ".md") {
+			for _, line := range strings.Split(text, "\n") {
+				if strings.HasPrefix(line, "# ") {
+					item.Title = strings.TrimSpace(line[2:])
+					break
+				}
+			}
+		} else {
+			item.Title = htmlTitle(text)
+		}
+	}
+	if item.Summary == "" && !strings.HasSuffix(urlPath, ".md") {
+		item.Summary = htmlMetaDescription(text)
+	}
+	if item.Title == "" {
+		item.Title = filepath.Base(urlPath)
+	}
+	return item
+}
+
+// frontMatter splits off a leading "---" block of "key: value" lines.
+func frontMatter(text string) (map[string]string, string, bool) {
+	text = strings.TrimPrefix(text, "\ufeff")
+	if !strings.HasPrefix(text, "---\n") && !strings.HasPrefix(text, "---\r\n") {
+		return nil, text, false
+	}
+	_, body, _ := strings.Cut(text, "\n")
+	block, rest, ok := strings.Cut(body, "\n---")
+	if !ok {
+		return nil, text, false
+	}
+	doc, err := parseYAML(block)
+	if err != nil {
+		return nil, text, false
+	}
+	meta := make(map[string]string)
+	for k, v := range doc {
+		if s, ok := v.(string); ok {
+			meta[strings.ToLower(k)] = s
+		}
+	}
+	_, rest, _ = strings.Cut(rest, "\n")
+	return meta, rest, true
+}
+
+func parseFeedDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", s)
+}
+
+func htmlMetaDescription(page string) string {
+	lower := strings.ToLower(page)
+	i := strings.Index(lower, `name="description"`)
+	if i < 0 {
+		return ""
+	}
+	start := strings.LastIndex(lower[:i], "<meta")
+	end := strings.IndexByte(lower[i:], '>')
+	if start < 0 || end < 0 {
+		return ""
+	}
+	tag := page[start : i+end]
+	_, content, ok := strings.Cut(tag, `content="`)
+	if !ok {
+		return ""
+	}
+	content, _, _ = strings.Cut(content, `"`)
+	return strings.TrimSpace(htmlText(content))
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Rendering
+// ─────────────────────────────────────────────────────────────────
+
+func feedURL(path string) string {
+	return strings.TrimSuffix(FeedSiteURL, "/") + path
+}
+
+func renderAtom(items []feedItem) ([]byte, error) {
+	type link struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+	}
+	type entry struct {
+		Title   string `xml:"title"`
+		Link    link   `xml:"link"`
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Summary string `xml:"summary,omitempty"`
+	}
+	type atom struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string   `xml:"title"`
+		ID      string   `xml:"id"`
+		Links   []link   `xml:"link"`
+		Updated string   `xml:"updated"`
+		Entries []entry  `xml:"entry"`
+	}
+	feed := atom{
+		Title:   FeedTitle,
+		ID:      feedURL(FeedDir),
+		Links:   []link{{Href: feedURL(FeedPath), Rel: "self"}, {Href: feedURL(FeedDir)}},
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(items) > 0 {
+		feed.Updated = items[0].Date.UTC().Format(time.RFC3339)
+	}
+	for _, it := range items {
+		feed.Entries = append(feed.Entries, entry{
+			Title:   it.Title,
+			Link:    link{Href: feedURL(it.Path)},
+			ID:      feedURL(it.Path),
+			Updated: it.Date.UTC().Format(time.RFC3339),
+			Summary: it.Summary,
+		})
+	}
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	return append([]byte(xml.Header), out...), err
+}
+
+func renderRSS(items []feedItem) ([]byte, error) {
+	type item struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		GUID        string `xml:"guid"`
+		PubDate     string `xml:"pubDate"`
+		Description string `xml:"description,omitempty"`
+	}
+	type rss struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string   `xml:"version,attr"`
+		Title   string   `xml:"channel>title"`
+		Link    string   `xml:"channel>link"`
+		Desc    string   `xml:"channel>description"`
+		Items   []item   `xml:"channel>item"`
+	}
+	feed := rss{Version: "2.0", Title: FeedTitle, Link: feedURL(FeedDir), Desc: FeedTitle}
+	for _, it := range items {
+		feed.Items = append(feed.Items, item{
+			Title:       it.Title,
+			Link:        feedURL(it.Path),
+			GUID:        feedURL(it.Path),
+			PubDate:     it.Date.UTC().Format(time.RFC1123Z),
+			Description: it.Summary,
+		})
+	}
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	return append([]byte(xml.Header), out...), err
+}
@@ -0,0 +1,227 @@
+// filecache.go
+
+package main
+
+import (
+	"bufio"          //scanning server.log
+	"container/list" //LRU order
+	"expvar"         //cache stats in /debug/vars
+	"os"             //stat + read
+	"path/filepath"  //docroot paths
+	"sort"           //top N paths
+	"strings"        //parsing log lines
+	"sync"           //guarding the cache
+	"time"           //modification times, warm-up duration
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  File cache
+//    - serveStatic keeps the bodies of small files in memory, least
+//      recently used evicted first once FileCacheSize is reached.
+//    - An entry is only used while the file's size and modification
+//      time are unchanged, so edits and docroot syncs show up at once.
+//    - With a memory limit (memlimit.go) the cache never takes more
+//      than a quarter of it, and memory pressure empties it.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	FileCacheSize    = int64(64 << 20) //bytes, 0 disables the cache
+	FileCacheMaxFile = int64(1 << 20)  //larger files are always read from disk
+)
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	data    []byte
+}
+
+var (
+	fileCacheMu    sync.Mutex
+	fileCache      = make(map[string]*list.Element) //path → element holding *cachedFile
+	fileCacheLRU   = list.New()                     //front = most recently used
+	fileCacheBytes int64
+)
+
+func init() {
+	expvar.Publish("helix_file_cache", expvar.Func(func() any {
+		fileCacheMu.Lock()
+		defer fileCacheMu.Unlock()
+		return map[string]int64{"files": int64(len(fileCache)), "bytes": fileCacheBytes, "limit": fileCacheLimit()}
+	}))
+}
+
+// fileCacheLimit is FileCacheSize, capped by the memory limit.
+func fileCacheLimit() int64 {
+	if memoryLimit > 0 && FileCacheSize > memoryLimit/4 {
+		return memoryLimit / 4
+	}
+	return FileCacheSize
+}
+
+// fileCacheGet returns the cached body of path if info still matches.
+func fileCacheGet(path string, info os.FileInfo) ([]byte, bool) {
+	fileCacheMu.Lock()
+	defer fileCacheMu.Unlock()
+	elem, ok := fileCache[path]
+	if !ok {
+		incCounter("file_cache", []string{"result:miss"}, 1)
+		return nil, false
+	}
+	f := elem.Value.(*cachedFile)
+	if f.size != info.Size() || !f.modTime.Equal(info.ModTime()) {
+		removeCachedFile(elem)
+		incCounter("file_cache", []string{"result:stale"}, 1)
+		return nil, false
+	}
+	fileCacheLRU.MoveToFront(elem)
+	incCounter("file_cache", []string{"result:hit"}, 1)
+	return f.data, true
+}
+
+// fileCachePut stores data as the body of path, evicting as needed.
+func fileCachePut(path string, info os.FileInfo, data []byte) {
+	limit := fileCacheLimit()
+	size := int64(len(data))
+	if size > FileCacheMaxFile || size > limit {
+		return
+	}
+	fileCacheMu.Lock()
+	defer fileCacheMu.Unlock()
+	if elem, ok := fileCache[path]; ok {
+		removeCachedFile(elem)
+	}
+	for fileCacheBytes+size > limit {
+		removeCachedFile(fileCacheLRU.Back())
+	}
+	fileCache[path] = fileCacheLRU.PushFront(&cachedFile{path: path, size: info.Size(), modTime: info.ModTime(), data: data})
+	fileCacheBytes += size
+}
+
+// removeCachedFile drops one entry; fileCacheMu must be held.
+func removeCachedFile(elem *list.Element) {
+	f := fileCacheLRU.Remove(elem).(*cachedFile)
+	delete(fileCache, f.path)
+	fileCacheBytes -= int64(len(f.data))
+}
+
+func clearFileCache() {
+	fileCacheMu.Lock()
+	defer fileCacheMu.Unlock()
+	clear(fileCache)
+	fileCacheLRU.Init()
+	fileCacheBytes = 0
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Warm-up
+//    - Before the listener accepts anything, WarmupPaths and the
+//      WarmupTopN most requested paths of server.log are read into
+//      the file cache, so the first requests after a deploy don't all
+//      go to disk at once.
+//    - Files are served as they are on disk (no compression), so
+//      there are no compressed variants to prime.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	WarmupPaths = []string{} //URL paths, e.g. "/", "/app.js"
+	WarmupTopN  = 0          //also the N most requested paths in server.log
+)
+
+// warmupLogTail is how much of server.log we look at for WarmupTopN.
+const warmupLogTail = 16 << 20
+
+func warmUp() {
+	paths := append([]string{}, WarmupPaths...)
+	if WarmupTopN > 0 {
+		paths = append(paths, topLoggedPaths(serverLogPath(), WarmupTopN)...)
+	}
+	if len(paths) == 0 || fileCacheLimit() <= 0 {
+		return
+	}
+
+	start := time.Now()
+	site := siteNow()
+	files, bytes := 0, int64(0)
+	for _, urlPath := range paths {
+		localPath, info, ok := warmupFile(site, urlPath)
+		if !ok {
+			continue
+		}
+		if _, cached := fileCacheGet(localPath, info); cached {
+			continue
+		}
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			continue
+		}
+		fileCachePut(localPath, info, data)
+		files++
+		bytes += int64(len(data))
+	}
+	logInfof("filecache", "Warm-up: cached %d of %d paths (%s) in %s", files, len(paths), humanBytes(bytes), time.Since(start).Round(time.Millisecond))
+}
+
+// warmupFile resolves a URL path the way serveStatic does.
+func warmupFile(site *siteSettings, urlPath string) (string, os.FileInfo, bool) {
+	urlPath, _, _ = strings.Cut(urlPath, "?")
+	cleanPath, err := sanitizePath(urlPath)
+	if err != nil {
+		return "", nil, false
+	}
+	localPath := filepath.Join(site.root, cleanPath)
+	info, err := os.Stat(localPath)
+	if err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, site.index)
+		info, err = os.Stat(localPath)
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, false
+	}
+	return localPath, info, true
+}
+
+// topLoggedPaths returns the n paths most often served with a 200 in
+// the tail of the request log.
+func topLoggedPaths(logPath string, n int) []string {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > warmupLogTail {
+		f.Seek(info.Size()-warmupLogTail, 0)
+	}
+
+	counts := make(map[string]int)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		//[INFO] <ts> – <client> – "GET /path HTTP/1.1" – 200
+		line := s.Text()
+		_, rest, ok := strings.Cut(line, ` – "GET `)
+		if !ok {
+			continue
+		}
+		target, rest, ok := strings.Cut(rest, " ")
+		if !ok || !strings.Contains(rest, `" – 200`) {
+			continue
+		}
+		target, _, _ = strings.Cut(target, "?")
+		counts[target]++
+	}
+
+	paths := make([]string, 0, len(counts))
+	for p := range counts {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if counts[paths[i]] != counts[paths[j]] {
+			return counts[paths[i]] > counts[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
@@ -0,0 +1,16 @@
+// filelock_other.go
+
+//go:build !unix
+
+package main
+
+import (
+	"errors" //unsupported
+	"os"     //signature
+)
+
+// tryLockFile isn't implemented here; leader election reports the error
+// and this instance stays a follower.
+func tryLockFile(path string) (*os.File, bool, error) {
+	return nil, false, errors.New("file locks not supported on this platform")
+}
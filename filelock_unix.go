@@ -0,0 +1,28 @@
+// filelock_unix.go
+
+//go:build unix
+
+package main
+
+import (
+	"os"      //opening the lock file
+	"syscall" //flock
+)
+
+// tryLockFile takes an exclusive, non-blocking flock on path. The lock
+// lasts as long as the returned file stays open, and the kernel drops
+// it if we die, so a crashed leader never blocks the others.
+func tryLockFile(path string) (*os.File, bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return f, true, nil
+}
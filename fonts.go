@@ -0,0 +1,63 @@
+// fonts.go
+
+package main
+
+import (
+	"path/filepath" //extensions
+	"slices"        //origin list
+	"strconv"       //max-age
+	"strings"       //lowercasing
+	"time"          //max-age
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Fonts preset (fonts.enabled: true)
+//    - Web fonts loaded from another origin (a CDN host, the app on a
+//      different subdomain) need CORS, browsers are picky about their
+//      types, and they never change under a versioned name. With the
+//      preset on, every .woff2/.woff/.ttf/.otf/.eot file gets:
+//        Content-Type                 font/woff2, font/ttf, ...
+//        Access-Control-Allow-Origin  "*", or the request's Origin if
+//                                     it is in FontAllowOrigins
+//        Cache-Control                public, max-age=<FontMaxAge>, immutable
+//    - A type from the "mime" setting still wins, and _headers can
+//      override the rest (see headers.go).
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	FontsPreset      = false
+	FontAllowOrigins = []string{"*"}
+	FontMaxAge       = 365 * 24 * time.Hour
+)
+
+var fontTypes = map[string]string{
+	".woff2": "font/woff2",
+	".woff":  "font/woff",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+}
+
+// applyFontPreset adds the preset's headers if localPath is a font.
+func applyFontPreset(h Header, req *request, localPath string) {
+	ext := strings.ToLower(filepath.Ext(localPath))
+	ctype, ok := fontTypes[ext]
+	if !FontsPreset || !ok {
+		return
+	}
+	if _, custom := req.site.mime[ext]; !custom {
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(FontMaxAge.Seconds()))+", immutable")
+
+	origin := req.header.Get("Origin")
+	switch {
+	case slices.Contains(FontAllowOrigins, "*"):
+		h.Set("Access-Control-Allow-Origin", "*")
+	case origin != "" && slices.Contains(FontAllowOrigins, origin):
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	default:
+		h.Add("Vary", "Origin") //the answer differs for listed origins
+	}
+}
@@ -0,0 +1,206 @@
+// headers.go
+
+package main
+
+import (
+	"bufio"         //reading the file line by line
+	"fmt"           //parse errors
+	"net/textproto" //canonical header names
+	"os"            //open
+	"path/filepath" //hiding the file
+	"strings"       //parsing
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Response headers from config and _headers (Netlify style)
+//    - ResponseHeaders (config "headers") are added to every response
+//      a site serves (not to the 429/503 answers of the limiters).
+//    - A "_headers" file in a site's root adds headers per path:
+//          /*
+//            X-Frame-Options: DENY
+//          /assets/*
+//            Cache-Control: public, max-age=31536000, immutable
+//      A path line starts a block, indented "Name: value" lines below
+//      belong to it. Path patterns work like in _redirects.
+//    - Every matching block applies, in file order; a name set in
+//      several blocks is joined with ", ". The file's value for a name
+//      replaces the config's, and both replace what the handler set,
+//      so a site can carry its own policy.
+//    - Framing headers (Content-Length, Connection, ...) can't be set.
+//      The file is re-read when it changes and is never served itself.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	ResponseHeaders = map[string]string{}
+	HeadersEnabled  = true
+)
+
+type headerBlock struct {
+	path    pathPattern
+	headers Header
+}
+
+// headerFiles caches each site's parsed blocks (see sitefiles.go).
+var headerFiles = newSiteFile(parseHeaders, "_headers")
+
+// protectedHeaders are managed by the server and can't be configured.
+var protectedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Connection":        true,
+	"Date":              true,
+	"Transfer-Encoding": true,
+	"Keep-Alive":        true,
+	"Upgrade":           true,
+}
+
+func parseHeaders(path string) ([]headerBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var blocks []headerBlock
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			if !strings.HasPrefix(text, "/") {
+				logWarnf("headers", "%s:%d: path %q must start with /, block skipped", path, n, text)
+				blocks = append(blocks, headerBlock{}) //swallows the block's lines
+				continue
+			}
+			blocks = append(blocks, headerBlock{path: parsePathPattern(text), headers: make(Header)})
+			continue
+		}
+		if len(blocks) == 0 {
+			logWarnf("headers", "%s:%d: header before any path, skipped", path, n)
+			continue
+		}
+		block := blocks[len(blocks)-1]
+		if block.headers == nil {
+			continue
+		}
+		name, value, err := parseHeaderLine(text)
+		if err != nil {
+			logWarnf("headers", "%s:%d: %v, skipped", path, n, err)
+			continue
+		}
+		block.headers.Add(name, value)
+	}
+
+	valid := blocks[:0]
+	for _, b := range blocks {
+		if b.headers != nil {
+			valid = append(valid, b)
+		}
+	}
+	logInfof("headers", "Loaded %d header blocks from %s", len(valid), path)
+	return valid, scanner.Err()
+}
+
+func parseHeaderLine(text string) (string, string, error) {
+	name, value, ok := strings.Cut(text, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("%q is not a \"Name: value\" line", text)
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if protectedHeaders[name] {
+		return "", "", fmt.Errorf("%s is set by the server", name)
+	}
+	return name, value, nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  siteHeadersFor(req) Header
+//    - Config headers, then every matching _headers block on top.
+// ─────────────────────────────────────────────────────────────────
+
+func siteHeadersFor(req *request) Header {
+	h := make(Header)
+	for name, value := range ResponseHeaders {
+		if name = textproto.CanonicalMIMEHeaderKey(name); !protectedHeaders[name] {
+			h[name] = []string{value}
+		}
+	}
+	if !HeadersEnabled {
+		return h
+	}
+	blocks, _, ok := headerFiles.get(req.site.root)
+	if !ok {
+		return h
+	}
+	path := req.cleanPath() //"/./admin/x" gets /admin/* headers too (see target.go)
+	fromFile := make(Header)
+	for _, b := range blocks {
+		if _, ok := b.path.match(path); !ok {
+			continue
+		}
+		for name, values := range b.headers {
+			fromFile[name] = append(fromFile[name], values...)
+		}
+	}
+	for name, values := range fromFile {
+		h[name] = []string{strings.Join(values, ", ")}
+	}
+	return h
+}
+
+// isHeadersFile reports whether req asks for the _headers file itself.
+func isHeadersFile(req *request) bool {
+	if !HeadersEnabled {
+		return false
+	}
+	_, file, ok := headerFiles.get(req.site.root)
+	path := req.cleanPath()
+	return ok && filepath.Join(req.site.root, filepath.FromSlash(path)) == file
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  headerPolicyWriter
+//    - Lays the site's headers over the handler's right before the
+//      header goes out.
+// ─────────────────────────────────────────────────────────────────
+
+type headerPolicyWriter struct {
+	ResponseWriter
+	headers     Header
+	wroteHeader bool
+}
+
+func withSiteHeaders(w ResponseWriter, req *request) ResponseWriter {
+	h := siteHeadersFor(req)
+	if len(h) == 0 {
+		return w
+	}
+	return &headerPolicyWriter{ResponseWriter: w, headers: h}
+}
+
+func (p *headerPolicyWriter) WriteHeader(statusCode int) {
+	if !p.wroteHeader {
+		p.wroteHeader = true
+		for name, values := range p.headers {
+			p.Header()[name] = values
+		}
+	}
+	p.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (p *headerPolicyWriter) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(200)
+	}
+	return p.ResponseWriter.Write(b)
+}
+
+func (p *headerPolicyWriter) Flush() error {
+	if !p.wroteHeader {
+		p.WriteHeader(200)
+	}
+	return p.ResponseWriter.Flush()
+}
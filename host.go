@@ -0,0 +1,81 @@
+// host.go
+
+package main
+
+import (
+	"fmt"       //errors
+	"net/netip" //IPv6 literals
+	"strings"   //parsing
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Host header
+//    - An HTTP/1.1 request must carry exactly one Host header (RFC
+//      9112, section 3.2); a missing one, two of them or a malformed
+//      value is a 400. HTTP/1.0 clients may leave it out.
+//    - req.host is the value normalized for vhost routing: lower case,
+//      without the port and a trailing dot, an IPv6 literal without
+//      its brackets. "Example.COM.:8080" is "example.com".
+//    - An empty Host is allowed (the target carries no authority) and
+//      gets the default site, like a missing one from HTTP/1.0.
+// ─────────────────────────────────────────────────────────────────
+
+// checkHost returns the normalized Host of a request with header.
+func checkHost(version string, header Header) (string, error) {
+	values := header.Values("Host")
+	switch {
+	case len(values) > 1:
+		return "", fmt.Errorf("%w: %d Host headers", ErrBadRequest, len(values))
+	case len(values) == 0 && http11(version):
+		return "", fmt.Errorf("%w: missing Host header", ErrBadRequest)
+	case len(values) == 0:
+		return "", nil
+	}
+	return normalizeHost(values[0])
+}
+
+// normalizeHost checks a Host value, "host[:port]", and returns the
+// host part normalized.
+func normalizeHost(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	host, port := value, ""
+	if strings.HasPrefix(value, "[") {
+		end := strings.IndexByte(value, ']')
+		if end < 0 {
+			return "", fmt.Errorf("%w: bad Host %q", ErrBadRequest, value)
+		}
+		host, port = value[1:end], value[end+1:]
+		if addr, err := netip.ParseAddr(host); err != nil || !addr.Is6() {
+			return "", fmt.Errorf("%w: bad Host %q", ErrBadRequest, value)
+		}
+	} else if colon := strings.LastIndexByte(value, ':'); colon >= 0 {
+		host, port = value[:colon], value[colon:]
+	}
+	if port != "" && (port[0] != ':' || strings.Trim(port[1:], "0123456789") != "") {
+		return "", fmt.Errorf("%w: bad port in Host %q", ErrBadRequest, value)
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || !strings.HasPrefix(value, "[") && !isRegName(host) {
+		return "", fmt.Errorf("%w: bad Host %q", ErrBadRequest, value)
+	}
+	return host, nil
+}
+
+// isRegName reports whether s only has the characters of a host name
+// or IPv4 address (RFC 3986 reg-name).
+func isRegName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', isDigit(c), strings.IndexByte("-._~!$&'()*+,;=", c) >= 0:
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,254 @@
+// initconfig.go
+
+package main
+
+import (
+	"bufio"         //reading answers
+	"flag"          //subcommand flags
+	"fmt"           //prompts + file contents
+	"io"            //answers source
+	"os"            //writing the files
+	"path/filepath" //output paths
+	"strconv"       //port check
+	"strings"       //answers
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  helix init [-dir .] [-yes] [-force]
+//    - Asks a few questions (port, document root, profile, TLS, auth)
+//      and writes a commented helix.yaml, a helix.service systemd
+//      unit and a Dockerfile into -dir, as a starting point to edit.
+//    - Helix doesn't terminate TLS: answering yes makes it listen on
+//      127.0.0.1 only, for a TLS proxy in front, and the config says
+//      so. Auth means sidecars (per-file Basic auth, see sidecar.go).
+//    - -yes takes every default without asking; so does an answer
+//      left empty, or stdin running out. Existing files are only
+//      replaced with -force.
+//    - The written config is read back to make sure it parses.
+// ─────────────────────────────────────────────────────────────────
+
+type initAnswers struct {
+	port    int
+	root    string
+	profile string
+	tls     bool //behind a TLS proxy
+	auth    bool //sidecars with Basic auth
+}
+
+func runInit(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "directory to write helix.yaml, helix.service and Dockerfile to")
+	yes := fs.Bool("yes", false, "take the defaults without asking")
+	force := fs.Bool("force", false, "replace files that already exist")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if *yes {
+		in = strings.NewReader("")
+	}
+	a := askInit(bufio.NewReader(in))
+
+	files := []struct{ name, content string }{
+		{"helix.yaml", initConfig(a)},
+		{"helix.service", initUnit(a)},
+		{"Dockerfile", initDockerfile(a)},
+	}
+	if !*force {
+		for _, f := range files {
+			if _, err := os.Stat(filepath.Join(*dir, f.name)); err == nil {
+				fmt.Printf("%s already exists, not replacing it (use -force)\n", filepath.Join(*dir, f.name))
+				return 1
+			}
+		}
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fmt.Printf("Could not create %s: %v\n", *dir, err)
+		return 1
+	}
+	for _, f := range files {
+		path := filepath.Join(*dir, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0644); err != nil {
+			fmt.Printf("Could not write %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Println("Wrote", path)
+	}
+	if _, err := readConfigFile(filepath.Join(*dir, "helix.yaml")); err != nil {
+		fmt.Printf("The written config doesn't parse, please report this: %v\n", err)
+		return 1
+	}
+	fmt.Printf("\nTry it: helix serve -profile %s -config %s\n", a.profile, filepath.Join(*dir, "helix.yaml"))
+	return 0
+}
+
+// askInit asks the questions on stdout, reading answers from in.
+func askInit(in *bufio.Reader) initAnswers {
+	a := initAnswers{}
+	for {
+		port, err := strconv.Atoi(askLine(in, "Port to listen on", "8080"))
+		if err == nil && port > 0 && port < 65536 {
+			a.port = port
+			break
+		}
+		fmt.Println("  expected a port number from 1 to 65535")
+	}
+	a.root = askLine(in, "Directory to serve", DefaultRoot)
+	for {
+		a.profile = askLine(in, "Profile (dev, prod or hardened)", "prod")
+		if _, ok := configProfiles[a.profile]; ok {
+			break
+		}
+		fmt.Println("  expected dev, prod or hardened")
+	}
+	a.tls = askYesNo(in, "Will a TLS proxy (Caddy, nginx, a load balancer) sit in front?")
+	a.auth = askYesNo(in, "Password-protect some files?")
+	return a
+}
+
+// askLine asks question and returns the answer, def if empty.
+func askLine(in *bufio.Reader, question, def string) string {
+	fmt.Printf("%s [%s]: ", question, def)
+	line, err := in.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if err != nil {
+			fmt.Println() //no answer coming, end the prompt line
+		}
+		return def
+	}
+	return line
+}
+
+func askYesNo(in *bufio.Reader, question string) bool {
+	for {
+		switch strings.ToLower(askLine(in, question+" (y/n)", "n")) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Println("  expected y or n")
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Generated files
+// ─────────────────────────────────────────────────────────────────
+
+func initConfig(a initAnswers) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# helix.yaml, written by \"helix init\". Every key is optional;\n")
+	fmt.Fprintf(&b, "# all of them are listed in configSettings in config.go.\n")
+	fmt.Fprintf(&b, "# Run with: helix serve -profile %s -config helix.yaml\n", a.profile)
+	fmt.Fprintf(&b, "# The profile sets defaults for timeouts, caching, logging and\n")
+	fmt.Fprintf(&b, "# security headers; keys below override it.\n\n")
+
+	if a.tls {
+		fmt.Fprintf(&b, "# Helix speaks plain HTTP: the TLS proxy in front connects here,\n")
+		fmt.Fprintf(&b, "# and nothing else can.\n")
+		fmt.Fprintf(&b, "listen: \"127.0.0.1:%d\"\n", a.port)
+	} else {
+		fmt.Fprintf(&b, "listen: \":%d\"\n", a.port)
+	}
+	fmt.Fprintf(&b, "root: %s\n", yamlQuote(a.root))
+	fmt.Fprintf(&b, "index: index.html\n\n")
+
+	fmt.Fprintf(&b, "log:\n")
+	fmt.Fprintf(&b, "  dir: %s\n", DefaultLogDir)
+	fmt.Fprintf(&b, "  # level: info\n\n")
+
+	fmt.Fprintf(&b, "# Internals (metrics, /reload, pprof); keep it on loopback.\n")
+	fmt.Fprintf(&b, "admin:\n")
+	fmt.Fprintf(&b, "  listen: %s\n\n", yamlQuote(AdminListenAddr))
+
+	if a.auth {
+		fmt.Fprintf(&b, "# A file is protected by a <name>.meta.json next to it, e.g.\n")
+		fmt.Fprintf(&b, "# report.pdf.meta.json:\n")
+		fmt.Fprintf(&b, "#   {\"auth\": {\"realm\": \"Reports\", \"users\": {\"alice\": \"s3cret\"}}}\n")
+		fmt.Fprintf(&b, "sidecars: true\n\n")
+	}
+
+	fmt.Fprintf(&b, "# Headers for every response; this replaces the profile's list:\n")
+	fmt.Fprintf(&b, "# headers:\n")
+	fmt.Fprintf(&b, "#   X-Frame-Options: DENY\n\n")
+	fmt.Fprintf(&b, "# mime:\n")
+	fmt.Fprintf(&b, "#   .webmanifest: application/manifest+json\n")
+	fmt.Fprintf(&b, "# error_pages:\n")
+	fmt.Fprintf(&b, "#   404: /errors/missing.html\n")
+	return b.String()
+}
+
+func initUnit(a initAnswers) string {
+	root := a.root
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# helix.service, written by \"helix init\". Install with:\n")
+	fmt.Fprintf(&b, "#   cp helix /usr/local/bin/ && cp helix.yaml /etc/helix/\n")
+	fmt.Fprintf(&b, "#   cp helix.service /etc/systemd/system/ && systemctl enable --now helix\n\n")
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=Helix web server\n")
+	fmt.Fprintf(&b, "After=network-online.target\n")
+	fmt.Fprintf(&b, "Wants=network-online.target\n\n")
+	fmt.Fprintf(&b, "[Service]\n")
+	fmt.Fprintf(&b, "ExecStart=/usr/local/bin/helix serve -profile %s -config /etc/helix/helix.yaml -root %s -log-dir /var/log/helix\n", a.profile, unitQuote(root))
+	fmt.Fprintf(&b, "# SIGHUP reloads the config without dropping connections\n")
+	fmt.Fprintf(&b, "ExecReload=/bin/kill -HUP $MAINPID\n")
+	fmt.Fprintf(&b, "Restart=on-failure\n")
+	fmt.Fprintf(&b, "DynamicUser=yes\n")
+	fmt.Fprintf(&b, "LogsDirectory=helix\n")
+	fmt.Fprintf(&b, "LimitNOFILE=65536\n")
+	if a.port < 1024 {
+		fmt.Fprintf(&b, "AmbientCapabilities=CAP_NET_BIND_SERVICE\n")
+	}
+	fmt.Fprintf(&b, "NoNewPrivileges=yes\n")
+	fmt.Fprintf(&b, "ProtectSystem=strict\n")
+	fmt.Fprintf(&b, "PrivateTmp=yes\n\n")
+	fmt.Fprintf(&b, "[Install]\n")
+	fmt.Fprintf(&b, "WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+func initDockerfile(a initAnswers) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Dockerfile, written by \"helix init\". Build from the Helix source\n")
+	fmt.Fprintf(&b, "# tree with your site and helix.yaml next to it:\n")
+	fmt.Fprintf(&b, "#   docker build -t my-site . && docker run -p %d:%d my-site\n\n", a.port, a.port)
+	fmt.Fprintf(&b, "FROM golang:1.24 AS build\n")
+	fmt.Fprintf(&b, "WORKDIR /src\n")
+	fmt.Fprintf(&b, "COPY . .\n")
+	fmt.Fprintf(&b, "RUN CGO_ENABLED=0 go build -o /helix .\n\n")
+	fmt.Fprintf(&b, "FROM gcr.io/distroless/static:nonroot\n")
+	fmt.Fprintf(&b, "COPY --from=build /helix /usr/local/bin/helix\n")
+	fmt.Fprintf(&b, "COPY helix.yaml /etc/helix/helix.yaml\n")
+	if filepath.IsAbs(a.root) {
+		fmt.Fprintf(&b, "# The site lives outside the build context (%s): copy it in first\n", a.root)
+		fmt.Fprintf(&b, "COPY public /srv/www\n")
+	} else {
+		fmt.Fprintf(&b, "COPY [%q, \"/srv/www\"]\n", filepath.ToSlash(filepath.Clean(a.root)))
+	}
+	fmt.Fprintf(&b, "EXPOSE %d\n", a.port)
+	fmt.Fprintf(&b, "# Listens on all interfaces inside the container whatever helix.yaml says\n")
+	fmt.Fprintf(&b, "ENTRYPOINT [\"/usr/local/bin/helix\", \"serve\", \"-profile\", %q, \"-config\", \"/etc/helix/helix.yaml\",\n", a.profile)
+	fmt.Fprintf(&b, "  \"-root\", \"/srv/www\", \"-log-dir\", \"/tmp/helix\", \"-addr\", \":%d\"]\n", a.port)
+	return b.String()
+}
+
+// unitQuote quotes s for a systemd command line if it needs it.
+func unitQuote(s string) string {
+	if strings.ContainsAny(s, " \t\"'\\$%") {
+		return strconv.Quote(strings.ReplaceAll(s, "%", "%%"))
+	}
+	return s
+}
+
+// yamlQuote quotes s for the config file if it needs it.
+func yamlQuote(s string) string {
+	if s == "" || strings.ContainsAny(s, ":#'\"{}[],&*!|>%@`") || strings.TrimSpace(s) != s {
+		return strconv.Quote(s)
+	}
+	return s
+}
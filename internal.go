@@ -0,0 +1,292 @@
+// internal.go
+
+package main
+
+import (
+	"errors"        //loop detection error
+	"fmt"           //wrapping
+	"maps"          //copying headers for error documents
+	"path/filepath" //extension check for the SPA fallback
+	"strings"       //query splitting
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Internal redirects
+//    - A handler can re-dispatch a request to a different path without
+//      the client noticing (no 30x round trip), e.g. to serve an error
+//      document or an SPA's index.html for unknown routes.
+//    - The original request line is kept for logging.
+// ─────────────────────────────────────────────────────────────────
+
+// MaxInternalRedirects bounds how often a single request may be
+// re-dispatched, so two rules pointing at each other can't spin forever.
+const MaxInternalRedirects = 10
+
+// SPAFallback, when set (e.g. "/index.html"), is served for GET/HEAD requests
+// to extensionless paths that don't exist, so client side routers in
+// single page apps work on reload.
+var SPAFallback = ""
+
+// ErrRedirectLoop is returned once a request hit MaxInternalRedirects.
+var ErrRedirectLoop = errors.New("internal redirect loop")
+
+// ─────────────────────────────────────────────────────────────────
+//  dispatch(w, req) error
+//    - Picks the handler for a (possibly re-dispatched) request.
+//    - Everything that can be a redirect target goes through here.
+// ─────────────────────────────────────────────────────────────────
+
+func dispatch(w ResponseWriter, req *request) error {
+	_, serve := matchRoute(req)
+	err := serve(w, req)
+	if fallback, ok := spaFallbackFor(req, err); ok {
+		return internalRedirect(w, req, fallback)
+	}
+	return err
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  matchRoute(req) (name, handler)
+//    - Picks the handler for a request: the first of routes that
+//      matches, static files if none does. Shared by dispatch() and
+//      "helix explain" (explain.go), so the two can't disagree, and
+//      routeTable() (configdump.go) lists the same routes for the
+//      admin /config dump.
+// ─────────────────────────────────────────────────────────────────
+
+type handlerFunc = func(ResponseWriter, *request) error
+
+// route is one entry of the table matchRoute goes through.
+type route struct {
+	//match returns the handler and its name (for logs and explain),
+	//ok false to try the next route. path is req.cleanPath().
+	match func(req *request, path string) (name string, handler handlerFunc, ok bool)
+
+	//describe is the route's rows in /config, with whether it's on
+	describe func() []routeEntry
+}
+
+// simpleRoute is a route with one fixed handler, taken when test holds.
+func simpleRoute(name string, handler handlerFunc, test func(req *request, path string) bool, describe func() routeEntry) route {
+	return route{
+		match: func(req *request, path string) (string, handlerFunc, bool) {
+			return name, handler, test(req, path)
+		},
+		describe: func() []routeEntry { return []routeEntry{describe()} },
+	}
+}
+
+// notServed answers 404 for files that are configuration, not content.
+func notServed(ResponseWriter, *request) error { return ErrNotFound }
+
+// routes are tried in order, first match wins. Set in init(): the
+// handlers re-dispatch through matchRoute, which reads routes.
+var routes []route
+
+func init() {
+	routes = []route{
+		//Methods nobody here implements: 501 rather than a 405 (see options.go)
+		simpleRoute("unknown method", serveUnknownMethod,
+			func(req *request, _ string) bool { return !knownMethods[req.method] },
+			func() routeEntry { return routeEntry{"method not defined by HTTP", "unknown method (501)", true} }),
+
+		//"OPTIONS *", about the server rather than a resource (see options.go)
+		simpleRoute("server-wide OPTIONS", serveServerWideOptions,
+			func(req *request, _ string) bool { return isServerWideOptions(req) },
+			func() routeEntry { return routeEntry{"OPTIONS *", "server-wide OPTIONS", true} }),
+
+		//Diagnostics for allow-listed clients (see echo.go)
+		simpleRoute("echo", serveEcho,
+			func(_ *request, path string) bool { return isEchoPath(path) },
+			func() routeEntry { return routeEntry{EchoPath, "echo", len(EchoAllowedCIDRs) > 0} }),
+
+		//Load balancer health checks (see ready.go)
+		simpleRoute("readiness", serveReadiness,
+			func(_ *request, path string) bool { return isReadinessPath(path) },
+			func() routeEntry { return routeEntry{ReadinessPath, "readiness", true} }),
+
+		//Docroot sync between instances (see docsync.go)
+		simpleRoute("docroot sync", serveSync,
+			func(_ *request, path string) bool { return isSyncPath(path) },
+			func() routeEntry { return routeEntry{SyncPath + "/...", "docroot sync", SyncToken != ""} }),
+
+		//Ban list updates from peers (see cluster.go)
+		simpleRoute("cluster", serveCluster,
+			func(_ *request, path string) bool { return isClusterPath(path) },
+			func() routeEntry { return routeEntry{ClusterPath + "/...", "cluster", ClusterToken != ""} }),
+
+		//Full-text search over the default site, when on (see search.go)
+		simpleRoute("search", serveSearch,
+			func(req *request, path string) bool { return req.site.host == "" && isSearchPath(path) },
+			func() routeEntry { return routeEntry{SearchPath + " (default site)", "search", SearchEnabled} }),
+
+		//Atom/RSS feed of a content directory, when on (see feed.go)
+		simpleRoute("feed", serveFeed,
+			func(req *request, path string) bool { return req.site.host == "" && isFeedPath(path) },
+			func() routeEntry { return routeEntry{FeedPath + " (default site)", "feed", FeedDir != ""} }),
+
+		//Allow-listed commands (see exec.go)
+		{
+			match: func(req *request, path string) (string, handlerFunc, bool) {
+				path, cmd, ok := execRouteFor(path)
+				if !ok {
+					return "", nil, false
+				}
+				return "exec " + strings.Join(cmd.Command, " "), func(w ResponseWriter, req *request) error {
+					return serveExec(w, req, path, cmd)
+				}, true
+			},
+			describe: func() []routeEntry {
+				var rows []routeEntry
+				for _, path := range sortedKeys(ExecRoutes) {
+					rows = append(rows, routeEntry{path, "exec " + strings.Join(ExecRoutes[path].Command, " "), true})
+				}
+				return rows
+			},
+		},
+
+		//_headers is configuration, not content (see headers.go)
+		simpleRoute("headers file (not served)", notServed,
+			func(req *request, _ string) bool { return isHeadersFile(req) },
+			func() routeEntry { return routeEntry{"/_headers", "headers file (not served)", HeadersEnabled} }),
+
+		//Sidecars are metadata, not content (see sidecar.go)
+		simpleRoute("sidecar file (not served)", notServed,
+			func(req *request, _ string) bool { return isSidecarFile(req) },
+			func() routeEntry {
+				return routeEntry{"*" + sidecarSuffix, "sidecar file (not served)", SidecarsEnabled}
+			}),
+
+		//"?stats" on a file or directory, when on (see downloads.go)
+		simpleRoute("download stats", serveDownloadStats,
+			func(req *request, _ string) bool { return isDownloadStatsQuery(req) },
+			func() routeEntry { return routeEntry{"?stats", "download stats", DownloadStats && DownloadStatsView} }),
+
+		//Generated .torrent/.meta4 for big downloads, when on (see torrent.go)
+		simpleRoute("torrent/metalink", serveTorrentDescriptor,
+			func(req *request, _ string) bool { return isTorrentDescriptor(req) },
+			func() routeEntry {
+				match := strings.TrimSuffix(TorrentDir, "/") + "/*" + torrentSuffix + ", *" + metalinkSuffix
+				return routeEntry{match, "torrent/metalink", TorrentDir != ""}
+			}),
+
+		//_redirects in the site's root (see redirects.go)
+		{
+			match: func(req *request, _ string) (string, handlerFunc, bool) {
+				m, ok := matchRedirect(req)
+				if !ok {
+					return "", nil, false
+				}
+				return m.String(), m.serve, true
+			},
+			describe: func() []routeEntry {
+				return []routeEntry{{"_redirects rules (see sites)", "redirect", RedirectsEnabled}}
+			},
+		},
+
+		//Big downloads from a mirror near the client, when set (see mirrors.go)
+		{
+			match: func(req *request, _ string) (string, handlerFunc, bool) {
+				m, ok := matchMirror(req)
+				if !ok {
+					return "", nil, false
+				}
+				return m.String(), m.serve, true
+			},
+			describe: func() []routeEntry {
+				var rows []routeEntry
+				for _, set := range Mirrors {
+					rows = append(rows, routeEntry{set.Prefix, "mirror", true})
+				}
+				return rows
+			},
+		},
+	}
+}
+
+func matchRoute(req *request) (string, handlerFunc) {
+	//However the path is spelled: "/%72eady" and "//ready" are "/ready" (see target.go)
+	path := req.cleanPath()
+	for _, r := range routes {
+		if name, handler, ok := r.match(req, path); ok {
+			return name, handler
+		}
+	}
+	return "static", serveStatic
+}
+
+// spaFallbackFor says where a failed request is re-dispatched to:
+// extension-less GETs that 404 go to the SPA fallback, when set.
+func spaFallbackFor(req *request, err error) (string, bool) {
+	fallback := req.site.spaFallback
+	if err == nil || fallback == "" || !errors.Is(err, ErrNotFound) || !readMethod(req.method) {
+		return "", false
+	}
+	path, _, _ := strings.Cut(req.rawPath, "?")
+	return fallback, filepath.Ext(path) == "" && path != fallback
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  internalRedirect(w, req, target) error
+//    - Re-dispatches req to target, keeping its method and headers.
+// ─────────────────────────────────────────────────────────────────
+
+func internalRedirect(w ResponseWriter, req *request, target string) error {
+	return redispatch(w, req, req.method, target)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveErrorDocument(w, req, statusCode, target) error
+//    - GETs target as a subrequest but answers with statusCode, e.g.
+//      the 404 page is /404.html served with "404 Not Found".
+//    - Returns the subrequest's error (and writes nothing) if the
+//      document can't be served, so the caller can fall back.
+//    - The page is always sent whole and unconditionally: Range and
+//      conditional headers meant for the original target don't apply.
+// ─────────────────────────────────────────────────────────────────
+
+func serveErrorDocument(w ResponseWriter, req *request, statusCode int, target string) error {
+	sub := *req
+	sub.header = maps.Clone(req.header)
+	sub.header.Del("Range")
+	sub.header.Del("If-Modified-Since")
+	sub.header.Del("If-None-Match")
+	sub.header.Del("If-Match")
+	sub.header.Del("If-Unmodified-Since")
+	sub.errorPage = true
+	return redispatch(&statusOverrideWriter{ResponseWriter: w, status: statusCode}, &sub, "GET", target)
+}
+
+func redispatch(w ResponseWriter, req *request, method, target string) error {
+	if req.redirects >= MaxInternalRedirects {
+		return fmt.Errorf("%w: %d redirects, last to %s", ErrRedirectLoop, req.redirects, target)
+	}
+	sub := *req
+	sub.method = method
+	sub.rawPath = target
+	sub.redirects++
+	return dispatch(w, &sub)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  statusOverrideWriter
+//    - Whatever status the handler writes, the client gets ours.
+// ─────────────────────────────────────────────────────────────────
+
+type statusOverrideWriter struct {
+	ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusOverrideWriter) WriteHeader(int) {
+	s.wroteHeader = true
+	s.ResponseWriter.WriteHeader(s.status)
+}
+
+func (s *statusOverrideWriter) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(s.status)
+	}
+	return s.ResponseWriter.Write(p)
+}
@@ -0,0 +1,126 @@
+// keepalive.go
+
+package main
+
+import (
+	"errors"  //telling timeouts apart
+	"fmt"     //wrapping
+	"io"      //discard
+	"net"     //read deadlines
+	"os"      //deadline errors
+	"strings" //Connection tokens
+	"time"    //idle timeout
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Persistent connections
+//    - handleConnection() serves requests on a connection one after
+//      another until the client says "Connection: close" (HTTP/1.0:
+//      unless it says "keep-alive"), the connection sits idle for
+//      IdleTimeout, or MaxKeepAliveRequests were served.
+//    - The idle timeout also bounds how long a client may take to
+//      send a request line and headers, and how long it may stall in
+//      the middle of its body: every read of req.body (the handler's
+//      and discardBody's) gets IdleTimeout again. A handler that
+//      doesn't read isn't timed, it takes as long as it takes.
+//    - We can only keep going if we know where the next request
+//      starts: whatever a handler left unread of req.body is skipped
+//      (for bodies up to maxDiscardBody, chunked ones too; see
+//      chunked.go), and a response without Content-Length closes the
+//      connection for an HTTP/1.0 client (HTTP/1.1 gets it chunked,
+//      see response.go).
+//    - What a client gets is decided by the version it speaks, see
+//      http11(): HTTP/1.0 never sees chunked framing and only keeps
+//      the connection when it asked to. Our status line says HTTP/1.1
+//      either way (RFC 9110, section 6.2), it doesn't echo theirs.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	KeepAlive            = true
+	IdleTimeout          = 60 * time.Second
+	MaxKeepAliveRequests = 1000
+)
+
+// maxDiscardBody is the largest unread request body we skip to keep
+// the connection; bigger ones aren't worth the bandwidth.
+const maxDiscardBody = 256 << 10
+
+// wantsKeepAlive decides whether the connection may stay open after
+// req, the served'th request on it.
+func wantsKeepAlive(req *request, served int) bool {
+	size := contentLength(req.header)
+	if !KeepAlive || served >= MaxKeepAliveRequests || size > maxDiscardBody {
+		return false
+	}
+	switch {
+	case size < 0 && !isChunked(req.header):
+		//Can't tell where the body ends
+		return false
+	case hasToken(req.header.Get("Connection"), "close"):
+		return false
+	case http11(req.version):
+		return true
+	}
+	return hasToken(req.header.Get("Connection"), "keep-alive")
+}
+
+// http11 reports whether a client speaking version (parseRequestLine
+// only lets "HTTP/1.x" through) understands HTTP/1.1: chunked bodies
+// and connections kept open by default. "HTTP/1.2" would.
+func http11(version string) bool {
+	return version != "HTTP/1.0"
+}
+
+// hasToken looks for token in a comma separated header value.
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentLength is the size of the body that follows the headers, 0
+// if there is none and -1 if we can't tell where it ends.
+func contentLength(h Header) int64 {
+	if h.Get("Transfer-Encoding") != "" {
+		return -1
+	}
+	n, err := parseContentLength(h) //see chunked.go
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// bodyReader reads a request body with a read deadline that each
+// read pushes out again, so a client can't hold a handler forever by
+// going quiet halfway through its upload. A timeout is an
+// ErrRequestTimeout (408), and sticks: discardBody gives up at once.
+type bodyReader struct {
+	conn    net.Conn
+	r       io.Reader
+	timeout error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.timeout != nil {
+		return 0, b.timeout
+	}
+	b.conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+	n, err := b.r.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		b.timeout = fmt.Errorf("%w: no request body for %s", ErrRequestTimeout, IdleTimeout)
+		err = b.timeout
+	}
+	return n, err
+}
+
+// discardBody skips what the handler left of req's body, so the next
+// request on the connection starts where it should. A chunked body
+// longer than maxDiscardBody isn't worth it either.
+func discardBody(req *request) bool {
+	n, err := io.Copy(io.Discard, io.LimitReader(req.body, maxDiscardBody+1))
+	return err == nil && n <= maxDiscardBody
+}
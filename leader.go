@@ -0,0 +1,66 @@
+// leader.go
+
+package main
+
+import (
+	"expvar"      //leadership next to the other stats
+	"os"          //hostname + pid in the lock file
+	"strconv"     //pid
+	"sync/atomic" //leader flag read by the scheduler
+	"time"        //retry interval
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Leader election
+//    - Some jobs should run once per fleet, not once per instance.
+//      Those are registered with registerSingletonJob() and only run
+//      on the leader.
+//    - The leader is whoever holds an exclusive lock on LeaderLockFile,
+//      which has to live on a filesystem all instances share (and that
+//      supports flock, e.g. NFSv4). Followers retry every leaderRetry,
+//      so if the leader dies another instance takes over.
+//    - Without LeaderLockFile every instance is its own leader, which
+//      is right for a single server.
+// ─────────────────────────────────────────────────────────────────
+
+var LeaderLockFile = ""
+
+const leaderRetry = 15 * time.Second
+
+var leader atomic.Bool
+
+func isLeader() bool {
+	return leader.Load()
+}
+
+func init() {
+	expvar.Publish("helix_leader", expvar.Func(func() any { return isLeader() }))
+}
+
+// startLeaderElection keeps trying to take the lock in the background.
+// Once we have it, we keep it until we exit.
+func startLeaderElection() {
+	if LeaderLockFile == "" {
+		leader.Store(true)
+		return
+	}
+	go func() {
+		var lastErr string
+		for {
+			f, ok, err := tryLockFile(LeaderLockFile)
+			if ok {
+				host, _ := os.Hostname()
+				f.Truncate(0)
+				f.WriteString(host + " " + strconv.Itoa(os.Getpid()) + "\n")
+				leader.Store(true)
+				logInfof("leader", "This instance is now the leader (%s)", LeaderLockFile)
+				return //f stays open, and with it the lock
+			}
+			if err != nil && err.Error() != lastErr {
+				logErrorf("leader", "Could not lock %s: %v", LeaderLockFile, err)
+				lastErr = err.Error()
+			}
+			time.Sleep(leaderRetry)
+		}
+	}()
+}
@@ -0,0 +1,180 @@
+// leakwatch.go
+
+package main
+
+import (
+	"expvar"      //current numbers in /debug/vars
+	"os"          //counting fds
+	"runtime"     //goroutines
+	"sync"        //samples + handler registry
+	"sync/atomic" //handler IDs
+	"time"        //ages
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Leak watch
+//    - Every minute (the leak-check job) samples the goroutine count,
+//      open file descriptors and live connection handlers.
+//    - A real leak grows steadily, while load only moves numbers up
+//      and down. So we warn when a number went up in every one of the
+//      last leakWindow samples and by at least its threshold overall,
+//      once per streak.
+//    - Connection handlers are tracked from start to end, so a handler
+//      stuck longer than StuckHandlerAge gets reported too.
+//    - Current numbers are in expvar as helix_leaks.
+// ─────────────────────────────────────────────────────────────────
+
+const leakWindow = 10 //consecutive rising samples before we warn
+
+var (
+	LeakGoroutineGrowth = 200 //minimum growth over the window
+	LeakFDGrowth        = 200
+	StuckHandlerAge     = 10 * time.Minute
+)
+
+type leakSample struct {
+	Goroutines int `json:"goroutines"`
+	OpenFDs    int `json:"open_fds"` //-1 where we can't count them
+	Handlers   int `json:"handlers"`
+}
+
+var (
+	leakMu      sync.Mutex
+	leakSamples []leakSample //oldest first, at most leakWindow+1
+	leakWarned  = map[string]bool{}
+	stuckWarned = map[uint64]bool{}
+)
+
+func init() {
+	registerJob("leak-check", time.Minute, checkLeaks)
+	expvar.Publish("helix_leaks", expvar.Func(func() any {
+		s := takeLeakSample()
+		oldest, _ := oldestHandler()
+		return map[string]any{
+			"goroutines":             s.Goroutines,
+			"open_fds":               s.OpenFDs,
+			"handlers":               s.Handlers,
+			"oldest_handler_seconds": int64(oldest.Seconds()),
+		}
+	}))
+}
+
+// openFDs counts our open file descriptors (sockets included), or -1
+// where there's no /proc.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+func takeLeakSample() leakSample {
+	handlersMu.Lock()
+	n := len(handlers)
+	handlersMu.Unlock()
+	return leakSample{Goroutines: runtime.NumGoroutine(), OpenFDs: openFDs(), Handlers: n}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Handler lifetimes
+// ─────────────────────────────────────────────────────────────────
+
+type handlerInfo struct {
+	start      time.Time
+	clientAddr string
+}
+
+var (
+	handlersMu    sync.Mutex
+	handlers      = make(map[uint64]handlerInfo)
+	nextHandlerID atomic.Uint64
+)
+
+// trackHandler registers a running connection handler; call the
+// returned func when it ends.
+func trackHandler(clientAddr string, start time.Time) func() {
+	id := nextHandlerID.Add(1)
+	handlersMu.Lock()
+	handlers[id] = handlerInfo{start: start, clientAddr: clientAddr}
+	handlersMu.Unlock()
+	return func() {
+		handlersMu.Lock()
+		delete(handlers, id)
+		handlersMu.Unlock()
+	}
+}
+
+func oldestHandler() (time.Duration, string) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	var oldest time.Duration
+	var addr string
+	for _, h := range handlers {
+		if age := time.Since(h.start); age > oldest {
+			oldest, addr = age, h.clientAddr
+		}
+	}
+	return oldest, addr
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  checkLeaks() error (the leak-check job)
+// ─────────────────────────────────────────────────────────────────
+
+func checkLeaks() error {
+	s := takeLeakSample()
+
+	leakMu.Lock()
+	leakSamples = append(leakSamples, s)
+	if len(leakSamples) > leakWindow+1 {
+		leakSamples = leakSamples[1:]
+	}
+	samples := append([]leakSample(nil), leakSamples...)
+	leakMu.Unlock()
+
+	checkGrowth("goroutines", samples, func(s leakSample) int { return s.Goroutines }, LeakGoroutineGrowth)
+	if s.OpenFDs >= 0 {
+		checkGrowth("open fds", samples, func(s leakSample) int { return s.OpenFDs }, LeakFDGrowth)
+	}
+
+	//Handlers that have been running for far too long
+	handlersMu.Lock()
+	var stuck []handlerInfo
+	for id, h := range handlers {
+		if time.Since(h.start) > StuckHandlerAge && !stuckWarned[id] {
+			stuckWarned[id] = true
+			stuck = append(stuck, h)
+		}
+	}
+	for id := range stuckWarned {
+		if _, ok := handlers[id]; !ok {
+			delete(stuckWarned, id)
+		}
+	}
+	handlersMu.Unlock()
+	for _, h := range stuck {
+		logWarnf("leak", "Connection handler for %s running since %s (%s)", logAddr(h.clientAddr), h.start.UTC().Format(time.RFC3339), roundDuration(time.Since(h.start)))
+	}
+	return nil
+}
+
+// checkGrowth warns once when value() rose in every sample of a full
+// window, by at least minGrowth overall.
+func checkGrowth(name string, samples []leakSample, value func(leakSample) int, minGrowth int) {
+	leakMu.Lock()
+	defer leakMu.Unlock()
+	rising := len(samples) == leakWindow+1
+	for i := 1; rising && i < len(samples); i++ {
+		rising = value(samples[i]) > value(samples[i-1])
+	}
+	first, last := value(samples[0]), value(samples[len(samples)-1])
+	if !rising || last-first < minGrowth {
+		leakWarned[name] = false
+		return
+	}
+	if !leakWarned[name] {
+		leakWarned[name] = true
+		logWarnf("leak", "Possible %s leak: grew every minute for %d minutes, %d → %d", name, leakWindow, first, last)
+	}
+}
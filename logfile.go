@@ -0,0 +1,142 @@
+// logfile.go
+
+package main
+
+import (
+	"compress/gzip" //compressing rotated logs
+	"errors"        //collecting failures
+	"io"            //copying into the gzip writer
+	"os"            //opening the log file
+	"path/filepath" //finding rotated logs
+	"strings"       //skipping compressed ones
+	"sync"          //swapping the file while others write
+	"time"          //leaving fresh files alone
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  logFile
+//    - An append-only log file that can be closed and opened again
+//      under the same name while the server keeps running.
+//    - This is what logrotate & co. need: they rename server.log to
+//      server.log.1, send us SIGUSR1, and we start a fresh server.log
+//      (see reopen_unix.go). Without the reopen we'd keep writing into
+//      the renamed file forever.
+// ─────────────────────────────────────────────────────────────────
+
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func openLogFile(path string) (*logFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) //flags to append and create the file if not existing
+	if err != nil {
+		return nil, err
+	}
+	return &logFile{path: path, f: f}, nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+// Reopen opens the path again and only then closes the old file, so a
+// failed reopen leaves us logging into the old one rather than nowhere.
+func (l *logFile) Reopen() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+	return old.Close()
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// serverLogPath is where the server log lives (-log-dir).
+func serverLogPath() string {
+	return filepath.Join(LogDir, "server.log")
+}
+
+// reopenLogs is called when the reopen signal arrives.
+func reopenLogs(lf *logFile) {
+	if err := lf.Reopen(); err != nil {
+		logErrorf("log", "Could not reopen log file %s: %v", lf.path, err)
+		return
+	}
+	logInfof("log", "Log file reopened")
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  compressRotatedLogs()
+//    - Scheduled job: gzips rotated logs (server.log.1, ...) for setups
+//      that rotate without compressing. Files touched in the last
+//      rotatedLogQuiet are left alone, we may still be writing to one
+//      until the reopen signal arrives.
+// ─────────────────────────────────────────────────────────────────
+
+const rotatedLogQuiet = 10 * time.Minute
+
+func init() {
+	registerJob("compress-logs", time.Hour, func() error { return compressRotatedLogs(serverLogPath()) })
+}
+
+func compressRotatedLogs(current string) error {
+	matches, err := filepath.Glob(current + ".*")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range matches {
+		if strings.HasSuffix(path, ".gz") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < rotatedLogQuiet {
+			continue
+		}
+		if err := gzipFile(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logInfof("log", "Compressed %s", path)
+	}
+	return errors.Join(errs...)
+}
+
+// gzipFile replaces path with path.gz, removing the original only once
+// the compressed copy is complete.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
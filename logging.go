@@ -0,0 +1,253 @@
+// logging.go
+
+package main
+
+import (
+	"encoding/json" //admin API responses
+	"fmt"           //formatting log lines
+	"net/http"      //admin API handler
+	"sort"          //stable header dumps
+	"strings"       //parsing levels
+	"sync"          //guarding the revert timer
+	"sync/atomic"   //lock-free settings reads on the hot path
+	"time"          //timestamps + auto revert
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Log levels
+//    - Every line still looks like "[LEVEL] <timestamp> – message".
+//    - A line is written if its level is at or above the level of its
+//      module (if one was set) or else the global level.
+//    - Access log lines (logRequest) are not affected by levels.
+// ─────────────────────────────────────────────────────────────────
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[logLevel]string{
+	levelDebug: "DEBUG",
+	levelInfo:  "INFO",
+	levelWarn:  "WARN",
+	levelError: "ERROR",
+}
+
+func parseLogLevel(s string) (logLevel, bool) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// LogLevel is the level we start with (and revert to).
+var LogLevel = "info"
+
+// DebugToggleDuration is how long runtime changes made through the admin
+// API last before we go back to the startup settings, so nobody forgets
+// debug logging on in production. Callers can pick another duration.
+var DebugToggleDuration = 15 * time.Minute
+
+// logSettings is swapped as a whole, never modified in place.
+type logSettings struct {
+	level        logLevel
+	modules      map[string]logLevel //per-module overrides, e.g. "bot" → DEBUG
+	dumpRequests bool                //log request line + headers of every request
+	revertAt     time.Time           //zero when these are the startup settings
+}
+
+var (
+	currentLog  atomic.Pointer[logSettings]
+	revertMu    sync.Mutex
+	revertTimer *time.Timer
+)
+
+func startupLogSettings() *logSettings {
+	level, ok := parseLogLevel(LogLevel)
+	if !ok {
+		level = levelInfo
+	}
+	return &logSettings{level: level, modules: map[string]logLevel{}}
+}
+
+func logSettingsNow() *logSettings {
+	if s := currentLog.Load(); s != nil {
+		return s
+	}
+	return startupLogSettings()
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  logf(level, module, format, args...)
+//    - logDebugf/logInfof/logWarnf/logErrorf are the shortcuts used
+//      throughout the code. module is a short name like "bot".
+//    - Debug lines carry the module name so they can be told apart.
+// ─────────────────────────────────────────────────────────────────
+
+func logEnabled(level logLevel, module string) bool {
+	s := logSettingsNow()
+	if moduleLevel, ok := s.modules[module]; ok {
+		return level >= moduleLevel
+	}
+	return level >= s.level
+}
+
+func logf(level logLevel, module, format string, args ...any) {
+	if !logEnabled(level, module) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if level == levelDebug {
+		msg = module + " – " + msg
+	}
+	logWriter.Printf("[%s] %s – %s\n", levelNames[level], time.Now().UTC().Format(time.RFC3339), msg)
+}
+
+func logDebugf(module, format string, args ...any) { logf(levelDebug, module, format, args...) }
+func logInfof(module, format string, args ...any)  { logf(levelInfo, module, format, args...) }
+func logWarnf(module, format string, args ...any)  { logf(levelWarn, module, format, args...) }
+func logErrorf(module, format string, args ...any) { logf(levelError, module, format, args...) }
+
+// dumpRequest logs everything we parsed off the wire for a request,
+// when request dumping is switched on.
+func dumpRequest(req *request) {
+	if !logSettingsNow().dumpRequests {
+		return
+	}
+	names := make([]string, 0, len(req.header))
+	for name := range req.header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		if droppedHeader(name) {
+			continue //see privacy.go
+		}
+		for _, value := range req.header[name] {
+			fmt.Fprintf(&b, " | %s: %s", name, value)
+		}
+	}
+	logWriter.Printf("[DEBUG] %s – dump – %s – %q%s\n", time.Now().UTC().Format(time.RFC3339), logAddr(req.clientAddr), req.requestLine, b.String())
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Admin API: /logging
+//    GET  → current settings as JSON
+//    POST → change them, query or form parameters:
+//             level=debug          global level
+//             module=bot:debug     per-module level (repeatable)
+//             dump=1               request dumping on/off
+//             for=30m              how long until we revert
+//                                  (default DebugToggleDuration)
+//             reset=1              back to startup settings now
+// ─────────────────────────────────────────────────────────────────
+
+func init() {
+	adminMux.HandleFunc("/logging", handleLoggingAdmin)
+}
+
+func handleLoggingAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Form.Get("reset") != "" {
+			revertLogSettings()
+			break
+		}
+		if err := applyLogSettings(r.Form.Get("level"), r.Form["module"], r.Form.Get("dump"), r.Form.Get("for")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := logSettingsNow()
+	modules := make(map[string]string, len(s.modules))
+	for module, level := range s.modules {
+		modules[module] = levelNames[level]
+	}
+	out := map[string]any{
+		"level":         levelNames[s.level],
+		"modules":       modules,
+		"dump_requests": s.dumpRequests,
+	}
+	if !s.revertAt.IsZero() {
+		out["revert_at"] = s.revertAt.UTC().Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// applyLogSettings builds new settings on top of the current ones and
+// schedules the revert.
+func applyLogSettings(level string, modules []string, dump, duration string) error {
+	cur := logSettingsNow()
+	next := &logSettings{level: cur.level, modules: map[string]logLevel{}, dumpRequests: cur.dumpRequests}
+	for module, l := range cur.modules {
+		next.modules[module] = l
+	}
+
+	if level != "" {
+		l, ok := parseLogLevel(level)
+		if !ok {
+			return fmt.Errorf("unknown level %q", level)
+		}
+		next.level = l
+	}
+	for _, m := range modules {
+		module, lvl, ok := strings.Cut(m, ":")
+		l, valid := parseLogLevel(lvl)
+		if !ok || !valid || module == "" {
+			return fmt.Errorf("module must look like name:level, got %q", m)
+		}
+		next.modules[module] = l
+	}
+	if dump != "" {
+		next.dumpRequests = dump == "1" || strings.EqualFold(dump, "true") || strings.EqualFold(dump, "on")
+	}
+
+	d := DebugToggleDuration
+	if duration != "" {
+		parsed, err := time.ParseDuration(duration)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("bad duration %q", duration)
+		}
+		d = parsed
+	}
+	next.revertAt = time.Now().Add(d)
+
+	revertMu.Lock()
+	defer revertMu.Unlock()
+	if revertTimer != nil {
+		revertTimer.Stop()
+	}
+	revertTimer = time.AfterFunc(d, revertLogSettings)
+	currentLog.Store(next)
+	logWriter.Printf("[INFO] %s – Log settings changed until %s\n", time.Now().UTC().Format(time.RFC3339), next.revertAt.UTC().Format(time.RFC3339))
+	return nil
+}
+
+func revertLogSettings() {
+	revertMu.Lock()
+	defer revertMu.Unlock()
+	if revertTimer != nil {
+		revertTimer.Stop()
+		revertTimer = nil
+	}
+	currentLog.Store(startupLogSettings())
+	logWriter.Printf("[INFO] %s – Log settings reverted to startup settings\n", time.Now().UTC().Format(time.RFC3339))
+}
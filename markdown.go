@@ -0,0 +1,169 @@
+// markdown.go
+
+package main
+
+import (
+	"html"          //escaping
+	"html/template" //the result is trusted HTML
+	"strings"       //line + inline handling
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  renderMarkdown(src) template.HTML
+//    - Just enough Markdown for a README above a directory listing:
+//      # headings, paragraphs, - / 1. lists, ``` code blocks, > quotes,
+//      `code`, **bold**, *italic* and [links](url).
+//    - Everything else comes out as escaped text. Raw HTML is escaped
+//      too and links only keep http(s), mailto and relative targets,
+//      so a README can't inject script into the listing page.
+// ─────────────────────────────────────────────────────────────────
+
+func renderMarkdown(src string) template.HTML {
+	var b strings.Builder
+	var para []string
+	list := "" //"ul" or "ol" while inside a list
+	inCode := false
+
+	flushPara := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + renderInline(strings.Join(para, " ")) + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if inCode {
+			if strings.HasPrefix(trimmed, "```") {
+				b.WriteString("</code></pre>\n")
+				inCode = false
+				continue
+			}
+			b.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flushPara()
+			closeList()
+			b.WriteString("<pre><code>")
+			inCode = true
+		case trimmed == "":
+			flushPara()
+			closeList()
+		case strings.HasPrefix(trimmed, "#"):
+			flushPara()
+			closeList()
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			if level > 6 || !strings.HasPrefix(trimmed[level:], " ") {
+				para = append(para, trimmed)
+				continue
+			}
+			tag := "h" + string(rune('0'+level))
+			b.WriteString("<" + tag + ">" + renderInline(strings.TrimSpace(trimmed[level:])) + "</" + tag + ">\n")
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+			flushPara()
+			if list != "ul" {
+				closeList()
+				b.WriteString("<ul>\n")
+				list = "ul"
+			}
+			b.WriteString("<li>" + renderInline(trimmed[2:]) + "</li>\n")
+		case orderedItem(trimmed) != "":
+			flushPara()
+			if list != "ol" {
+				closeList()
+				b.WriteString("<ol>\n")
+				list = "ol"
+			}
+			b.WriteString("<li>" + renderInline(orderedItem(trimmed)) + "</li>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flushPara()
+			closeList()
+			b.WriteString("<blockquote>" + renderInline(strings.TrimSpace(trimmed[1:])) + "</blockquote>\n")
+		default:
+			closeList()
+			para = append(para, trimmed)
+		}
+	}
+	if inCode {
+		b.WriteString("</code></pre>\n")
+	}
+	flushPara()
+	closeList()
+	return template.HTML(b.String())
+}
+
+// orderedItem returns the text of a "1. text" line, or "".
+func orderedItem(line string) string {
+	digits := len(line) - len(strings.TrimLeft(line, "0123456789"))
+	if digits == 0 || !strings.HasPrefix(line[digits:], ". ") {
+		return ""
+	}
+	return line[digits+2:]
+}
+
+// renderInline handles `code`, **bold**, *italic* and [text](url),
+// escaping everything else.
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch {
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(rest[1:1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**"):
+			if end := strings.Index(rest[2:], "**"); end > 0 {
+				b.WriteString("<strong>" + renderInline(rest[2:2+end]) + "</strong>")
+				i += end + 4
+				continue
+			}
+		case rest[0] == '*':
+			if end := strings.IndexByte(rest[1:], '*'); end > 0 {
+				b.WriteString("<em>" + renderInline(rest[1:1+end]) + "</em>")
+				i += end + 2
+				continue
+			}
+		case rest[0] == '[':
+			if mid := strings.Index(rest, "]("); mid > 0 {
+				if end := strings.IndexByte(rest[mid:], ')'); end > 0 {
+					text, target := rest[1:mid], rest[mid+2:mid+end]
+					if safeLink(target) {
+						b.WriteString(`<a href="` + html.EscapeString(target) + `">` + renderInline(text) + "</a>")
+					} else {
+						b.WriteString(renderInline(text))
+					}
+					i += mid + end + 1
+					continue
+				}
+			}
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// safeLink allows relative links and a few harmless schemes.
+func safeLink(target string) bool {
+	scheme, _, found := strings.Cut(target, ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return true //no scheme, relative
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// memlimit.go
+
+package main
+
+import (
+	"os"            //cgroup files + GOMEMLIMIT
+	"runtime"       //heap stats
+	"runtime/debug" //SetMemoryLimit
+	"strconv"       //parsing limits
+	"strings"       //trimming file contents
+	"time"          //check interval
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Memory limit
+//    - In a container the kernel OOM-kills us at the cgroup limit, and
+//      the Go GC doesn't know about it. At startup we read the limit
+//      (cgroup v2 memory.max, else v1 memory.limit_in_bytes) and set
+//      the GC's soft limit to MemoryLimitFraction of it, so the GC
+//      works harder before we get anywhere near the kill.
+//    - An explicit GOMEMLIMIT in the environment always wins.
+//    - The memory-pressure job empties our caches (crawler verdicts,
+//      sync checksums) and hands memory back to the OS when the heap
+//      gets above MemoryPressureFraction of the limit.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	MemoryLimitFraction    = 0.9
+	MemoryPressureFraction = 0.8
+)
+
+// memoryLimit is the limit we found (0 = none), set once at startup.
+var memoryLimit int64
+
+var cgroupMemoryFiles = []string{
+	"/sys/fs/cgroup/memory.max",                   //v2
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes", //v1
+}
+
+// unlimitedMemory: cgroup v1 reports "no limit" as a huge number just
+// below 2^63 rounded to pages; anything above this is no real limit.
+const unlimitedMemory = 1 << 62
+
+func init() {
+	registerJob("memory-pressure", 15*time.Second, relieveMemoryPressure)
+}
+
+// cgroupMemoryLimit returns the container's memory limit in bytes, or 0.
+func cgroupMemoryLimit() int64 {
+	for _, path := range cgroupMemoryFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		s := strings.TrimSpace(string(data))
+		if s == "max" {
+			return 0
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 || n >= unlimitedMemory {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
+
+// applyMemoryLimit sets the GC soft limit, called once at startup.
+func applyMemoryLimit() {
+	if os.Getenv("GOMEMLIMIT") != "" {
+		memoryLimit = debug.SetMemoryLimit(-1) //the runtime already applied it
+		logInfof("memory", "Using GOMEMLIMIT from the environment: %s", humanBytes(memoryLimit))
+		return
+	}
+	limit := cgroupMemoryLimit()
+	if limit == 0 {
+		return
+	}
+	soft := int64(float64(limit) * MemoryLimitFraction)
+	debug.SetMemoryLimit(soft)
+	memoryLimit = soft
+	logInfof("memory", "Container memory limit %s, GC soft limit set to %s", humanBytes(limit), humanBytes(soft))
+}
+
+// relieveMemoryPressure is the memory-pressure job.
+func relieveMemoryPressure() error {
+	if memoryLimit <= 0 {
+		return nil
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	inUse := int64(m.HeapInuse + m.StackInuse)
+	if float64(inUse) < float64(memoryLimit)*MemoryPressureFraction {
+		return nil
+	}
+	logWarnf("memory", "Memory pressure: %s in use of %s, dropping caches", humanBytes(inUse), humanBytes(memoryLimit))
+	dropCaches()
+	debug.FreeOSMemory()
+	return nil
+}
+
+// dropCaches empties everything we only keep to save work later.
+func dropCaches() {
+	crawlerCacheMu.Lock()
+	clear(crawlerCache)
+	crawlerCacheMu.Unlock()
+
+	hashCacheMu.Lock()
+	clear(hashCache)
+	hashCacheMu.Unlock()
+
+	clearFileCache()
+	clearFastLane()
+	clearDirListings()
+}
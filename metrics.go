@@ -0,0 +1,133 @@
+// metrics.go
+
+package main
+
+import (
+	"fmt"       //status classes
+	"math/rand" //reservoir sampling
+	"net"       //stripping the port off Host
+	"sort"      //stable tag order
+	"strings"   //joining tags
+	"sync"      //guarding the registry
+	"time"      //request durations
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Metrics registry
+//    - Counters are cumulative since startup; exporters (statsd.go)
+//      work out deltas themselves.
+//    - Timings are kept as a bounded sample per flush window for the
+//      push exporter, they are not stored forever.
+//    - Tags are "key:value" strings, e.g. "status_class:2xx".
+// ─────────────────────────────────────────────────────────────────
+
+type metricKey struct {
+	name string
+	tags string //sorted, comma separated
+}
+
+func newMetricKey(name string, tags []string) metricKey {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	return metricKey{name: name, tags: strings.Join(sorted, ",")}
+}
+
+// maxTimingSamples caps the samples kept per timing metric between two
+// flushes; above that we keep a random subset and report a sample rate.
+const maxTimingSamples = 1000
+
+type timingSamples struct {
+	seen    int64 //how many were observed
+	samples []time.Duration
+}
+
+var (
+	metricsMu sync.Mutex
+	counters  = make(map[metricKey]int64)
+	timings   = make(map[metricKey]*timingSamples)
+)
+
+func incCounter(name string, tags []string, delta int64) {
+	key := newMetricKey(name, tags)
+	metricsMu.Lock()
+	counters[key] += delta
+	metricsMu.Unlock()
+}
+
+func observeTiming(name string, tags []string, d time.Duration) {
+	key := newMetricKey(name, tags)
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	t, ok := timings[key]
+	if !ok {
+		t = &timingSamples{}
+		timings[key] = t
+	}
+	t.seen++
+	if len(t.samples) < maxTimingSamples {
+		t.samples = append(t.samples, d)
+		return
+	}
+	//Reservoir sampling: every observation has the same chance to be kept
+	if i := rand.Int63n(t.seen); i < maxTimingSamples {
+		t.samples[i] = d
+	}
+}
+
+// counterSnapshot copies the current counter values.
+func counterSnapshot() map[metricKey]int64 {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	snap := make(map[metricKey]int64, len(counters))
+	for k, v := range counters {
+		snap[k] = v
+	}
+	return snap
+}
+
+// takeTimings hands over the samples collected so far and starts a new
+// window.
+func takeTimings() map[metricKey]*timingSamples {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	taken := timings
+	timings = make(map[metricKey]*timingSamples)
+	return taken
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  recordRequestMetrics(req, statusCode, bytes, duration)
+//    - Called once per request after it was logged.
+//    - Tagged with the vhost (Host header without port) and the
+//      status class ("2xx", "4xx", ...).
+// ─────────────────────────────────────────────────────────────────
+
+func recordRequestMetrics(req *request, statusCode int, bytes int64, duration time.Duration) {
+	tags := []string{
+		"status_class:" + statusClass(statusCode),
+		"vhost:" + vhostTag(req.header.Get("Host")),
+	}
+	incCounter("requests", tags, 1)
+	incCounter("response_bytes", tags, bytes)
+	observeTiming("request_duration", tags, duration)
+	//Health checks stay out of the SLOs: a 503 while draining isn't an
+	//outage, and would keep us "not ready" through the error rate
+	if !isReadinessPath(req.cleanPath()) {
+		observeRoute(req.rawPath, statusCode, duration) //per-route latency + SLOs, see slo.go
+	}
+}
+
+func statusClass(statusCode int) string {
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
+func vhostTag(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if host == "" {
+		return "none"
+	}
+	return host
+}
@@ -0,0 +1,284 @@
+// mirrors.go
+
+package main
+
+import (
+	"bufio"     //reading the GeoIP file
+	"fmt"       //config errors
+	"net/netip" //GeoIP ranges
+	"os"        //GeoIP file
+	"sort"      //range lookups
+	"strings"   //parsing, prefix matching
+	"sync"      //GeoIP table + health
+	"time"      //health checks, change detection
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Mirror redirects
+//    - Mirrors maps download path prefixes to mirrors per client
+//      region:
+//          mirrors:
+//            /releases/:
+//              eu: https://eu.mirror.example/releases/
+//              na: https://na.mirror.example/releases/
+//              "*": https://mirror.example/releases/
+//      A GET/HEAD under the prefix for a file we have is answered
+//      with a 302 to the mirror for the client's region (or "*"),
+//      the rest of the path appended. Without a healthy mirror for
+//      the client, we serve the file ourselves.
+//    - Regions come from MirrorGeoIPFile, "network,region" lines
+//      (203.0.113.0/24,eu; a GeoIP CSV export cut to two columns
+//      works). Networks shouldn't overlap; the file is re-read when
+//      it changes. Clients not in it only get the "*" mirror.
+//    - The mirror-health job HEADs every mirror's URL each
+//      mirrorHealthInterval; a mirror that fails (no answer or a 5xx;
+//      a 403/404 for the bare directory is fine) gets no redirects
+//      until it passes again.
+// ─────────────────────────────────────────────────────────────────
+
+type mirrorSet struct {
+	Prefix  string            //e.g. "/releases/"
+	Regions map[string]string //region → base URL, "*" for everyone else
+}
+
+var (
+	Mirrors         = []mirrorSet{}
+	MirrorGeoIPFile = ""
+)
+
+const mirrorHealthInterval = 30 * time.Second
+
+type geoRange struct {
+	first, last netip.Addr
+	region      string
+}
+
+var (
+	geoMu      sync.RWMutex
+	geoRanges  []geoRange //sorted by first
+	geoModTime time.Time
+
+	mirrorHealthMu sync.Mutex
+	mirrorDown     = make(map[string]bool) //base URL → failed its last check
+)
+
+func init() {
+	registerJob("mirror-health", mirrorHealthInterval, checkMirrors)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  matchMirror(req) (mirrorMatch, bool)
+//    - Called from matchRoute() before the static handler.
+// ─────────────────────────────────────────────────────────────────
+
+type mirrorMatch struct {
+	region string
+	target string
+}
+
+func (m mirrorMatch) String() string {
+	return "mirror " + m.region + " → " + m.target
+}
+
+func (m mirrorMatch) serve(w ResponseWriter, req *request) error {
+	incCounter("mirror_redirects", []string{"region:" + m.region}, 1)
+	w.Header().Set("Location", m.target)
+	w.Header().Set("Cache-Control", "private, no-cache") //depends on the client's address
+	writeBody(w, 302, "text/html", nil)
+	return nil
+}
+
+func matchMirror(req *request) (mirrorMatch, bool) {
+	if len(Mirrors) == 0 || !readMethod(req.method) {
+		return mirrorMatch{}, false
+	}
+	urlPath := req.cleanPath() //how resolveStatic() finds the file, however it's spelled
+	_, rawQuery, _ := strings.Cut(req.rawPath, "?")
+	set, ok := mirrorSetFor(urlPath)
+	if !ok {
+		return mirrorMatch{}, false
+	}
+	region := geoRegion(clientIP(req.clientAddr))
+	base, ok := set.Regions[region]
+	if !ok || mirrorIsDown(base) {
+		region, base = "*", set.Regions["*"]
+	}
+	if base == "" || mirrorIsDown(base) {
+		return mirrorMatch{}, false
+	}
+	//Only files we have: a mirror can't fix a 404 and shouldn't get listings
+	target, err := resolveStatic(req.site, req.rawPath)
+	if err != nil || target.listing || target.redirect != "" {
+		return mirrorMatch{}, false
+	}
+	location := strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(escapePath(urlPath), escapePath(set.Prefix))
+	return mirrorMatch{region: region, target: withQuery(location, rawQuery)}, true
+}
+
+// mirrorSetFor returns the mirrors with the longest prefix of path.
+func mirrorSetFor(path string) (mirrorSet, bool) {
+	var best mirrorSet
+	found := false
+	for _, m := range Mirrors {
+		if strings.HasPrefix(path, m.Prefix) && (!found || len(m.Prefix) > len(best.Prefix)) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+func mirrorIsDown(base string) bool {
+	mirrorHealthMu.Lock()
+	defer mirrorHealthMu.Unlock()
+	return mirrorDown[base]
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  GeoIP
+// ─────────────────────────────────────────────────────────────────
+
+// geoRegion returns the region of ip, "" if unknown.
+func geoRegion(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	geoMu.RLock()
+	defer geoMu.RUnlock()
+	//Last range starting at or before addr
+	i := sort.Search(len(geoRanges), func(i int) bool { return addr.Less(geoRanges[i].first) }) - 1
+	if i < 0 || geoRanges[i].last.Less(addr) || geoRanges[i].first.BitLen() != addr.BitLen() {
+		return ""
+	}
+	return geoRanges[i].region
+}
+
+// loadGeoIP (re)reads MirrorGeoIPFile if it changed.
+func loadGeoIP() error {
+	if MirrorGeoIPFile == "" {
+		return nil
+	}
+	info, err := os.Stat(MirrorGeoIPFile)
+	if err != nil {
+		return err
+	}
+	geoMu.RLock()
+	unchanged := info.ModTime().Equal(geoModTime)
+	geoMu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	f, err := os.Open(MirrorGeoIPFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var ranges []geoRange
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		network, region, _ := strings.Cut(line, ",")
+		region, _, _ = strings.Cut(region, ",") //extra columns are ignored
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(network))
+		if err != nil {
+			if lineNo == 1 {
+				continue //a CSV header
+			}
+			return fmt.Errorf("%s:%d: %v", MirrorGeoIPFile, lineNo, err)
+		}
+		prefix = prefix.Masked()
+		ranges = append(ranges, geoRange{first: prefix.Addr(), last: lastAddr(prefix), region: strings.TrimSpace(region)})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first.Less(ranges[j].first) })
+
+	geoMu.Lock()
+	geoRanges, geoModTime = ranges, info.ModTime()
+	geoMu.Unlock()
+	logInfof("mirrors", "Loaded %d GeoIP networks from %s", len(ranges), MirrorGeoIPFile)
+	return nil
+}
+
+// lastAddr is the highest address in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for bit := p.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  checkMirrors() error
+//    - The mirror-health job; also picks up GeoIP file changes.
+// ─────────────────────────────────────────────────────────────────
+
+func checkMirrors() error {
+	if len(Mirrors) == 0 {
+		return nil
+	}
+	geoErr := loadGeoIP()
+
+	bases := map[string]bool{}
+	for _, m := range Mirrors {
+		for _, base := range m.Regions {
+			bases[base] = true
+		}
+	}
+	for base := range bases {
+		resp, err := outboundDo(outboundRequest{target: "mirror_health", method: "HEAD", url: base, timeout: 5 * time.Second})
+		if err == nil && resp.status >= 500 {
+			err = fmt.Errorf("status %d", resp.status)
+		}
+		down := err != nil
+		mirrorHealthMu.Lock()
+		changed := mirrorDown[base] != down
+		mirrorDown[base] = down
+		mirrorHealthMu.Unlock()
+		switch {
+		case changed && down:
+			logWarnf("mirrors", "Mirror %s is down (%v), serving its clients ourselves", base, err)
+		case changed:
+			logInfof("mirrors", "Mirror %s is back up", base)
+		}
+	}
+	return geoErr
+}
+
+// setMirrors reads mirrors from the config file, see above.
+func setMirrors(v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a mapping of path prefix to mirrors")
+	}
+	sets := make([]mirrorSet, 0, len(m))
+	for prefix, item := range m {
+		regions, ok := item.(map[string]any)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("%s: expected a path prefix with region: URL entries", prefix)
+		}
+		set := mirrorSet{Prefix: prefix, Regions: map[string]string{}}
+		for region, rv := range regions {
+			base, err := configString(rv)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", prefix, region, err)
+			}
+			if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+				return fmt.Errorf("%s.%s: expected an http(s) URL, got %q", prefix, region, base)
+			}
+			set.Regions[region] = base
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Prefix < sets[j].Prefix })
+	Mirrors = sets
+	return nil
+}
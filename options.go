@@ -0,0 +1,96 @@
+// options.go
+
+package main
+
+import (
+	"errors"  //finding the allowed methods
+	"fmt"     //wrapping
+	"strings" //Allow header
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Allowed methods and OPTIONS
+//    - A handler turning a method down returns onlyMethods("GET",
+//      "HEAD") rather than the bare ErrMethodNotAllowed, so the 405
+//      carries an "Allow: GET, HEAD, OPTIONS" header.
+//    - OPTIONS on a resource goes to its handler like any request;
+//      when the handler turns it down with the methods it does take,
+//      the answer is "204 No Content" with that Allow header instead
+//      of a 405. CORS preflights get their Access-Control-* headers
+//      from the headers config / _headers (see headers.go), like any
+//      other response. Handlers that take every method answer OPTIONS
+//      themselves.
+//    - "OPTIONS *" asks about the server as a whole and gets the
+//      methods of static content; "*" with any other method is a 400.
+//    - A method that isn't one of knownMethods is "501 Not
+//      Implemented" wherever it is sent: no handler here knows it.
+//      A known method a resource doesn't take is a 405, which always
+//      has an Allow header (the static content's methods if the
+//      handler didn't say).
+// ─────────────────────────────────────────────────────────────────
+
+// knownMethods are the methods of RFC 9110, plus PATCH (RFC 5789).
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"CONNECT": true, "OPTIONS": true, "TRACE": true, "PATCH": true,
+}
+
+func serveUnknownMethod(w ResponseWriter, req *request) error {
+	return fmt.Errorf("%w: method %s", ErrNotImplemented, req.method)
+}
+
+// allowedMethods is ErrMethodNotAllowed, listing what would work.
+type allowedMethods []string
+
+func (a allowedMethods) Error() string {
+	return ErrMethodNotAllowed.Error() + " (allowed: " + strings.Join(a, ", ") + ")"
+}
+
+func (a allowedMethods) Unwrap() error { return ErrMethodNotAllowed }
+
+// onlyMethods is the error for a request whose method isn't one of
+// methods.
+func onlyMethods(methods ...string) error {
+	return allowedMethods(methods)
+}
+
+// allowHeader is the Allow header for what err allows, "" if it
+// isn't a 405. A bare ErrMethodNotAllowed gets serverWideMethods.
+func allowHeader(err error) string {
+	var allowed allowedMethods
+	if !errors.As(err, &allowed) {
+		if !errors.Is(err, ErrMethodNotAllowed) {
+			return ""
+		}
+		allowed = serverWideMethods
+	}
+	methods := append([]string{}, allowed...)
+	return strings.Join(append(methods, "OPTIONS"), ", ")
+}
+
+// serverWideMethods answer "OPTIONS *".
+var serverWideMethods = allowedMethods{"GET", "HEAD"}
+
+func isServerWideOptions(req *request) bool {
+	return req.rawPath == "*"
+}
+
+func serveServerWideOptions(w ResponseWriter, req *request) error {
+	if req.method != "OPTIONS" {
+		return ErrBadRequest //"*" only means something to OPTIONS
+	}
+	answerOptions(w, serverWideMethods)
+	return nil
+}
+
+// answerOptions writes the 204 for an OPTIONS request the handler
+// turned down with err, if err says what it allows.
+func answerOptions(w ResponseWriter, err error) bool {
+	allow := allowHeader(err)
+	if allow == "" {
+		return false
+	}
+	w.Header().Set("Allow", allow)
+	w.WriteHeader(204)
+	return true
+}
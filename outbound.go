@@ -0,0 +1,155 @@
+// outbound.go
+
+package main
+
+import (
+	"bytes"    //request bodies (re-sent on retries)
+	"context"  //per-attempt timeouts
+	"fmt"      //errors
+	"io"       //reading response bodies
+	"net"      //dialer settings
+	"net/http" //the client itself
+	"time"     //timeouts + backoff
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Outbound HTTP
+//    - Every request Helix itself makes (SLO webhook, hCaptcha, ...)
+//      goes through outboundDo() and one shared, pooled client, so
+//      they all get the same timeouts, retries, metrics and request
+//      ID propagation instead of each rolling its own.
+//    - Metrics: outbound_requests{target,status_class} and
+//      outbound_duration{target}, next to the server's own metrics.
+// ─────────────────────────────────────────────────────────────────
+
+var outboundClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		MaxIdleConns:          32,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+	//Redirects on webhooks/APIs are more often a misconfiguration than
+	//intended, and following them would re-send the body elsewhere
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// maxOutboundBody bounds what we read of a response; the APIs we talk
+// to answer with small JSON documents.
+const maxOutboundBody = 1 << 20
+
+type outboundRequest struct {
+	target      string //short name for logs + metrics, e.g. "slo_webhook"
+	method      string
+	url         string
+	contentType string
+	body        []byte
+	requestID   string            //sent as X-Request-Id when set
+	headers     map[string]string //extra request headers
+	timeout     time.Duration     //per attempt, 0 = 10s
+	retries     int               //extra attempts on network errors, 429 and 5xx
+	maxBody     int64             //response body limit, 0 = maxOutboundBody
+
+	//stream, when set, gets a 2xx body as it arrives instead of it
+	//being buffered (outboundResponse.body stays empty). It is called
+	//again on a retry, so it must start over each time.
+	stream func(body io.Reader) error
+}
+
+type outboundResponse struct {
+	status int
+	body   []byte
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  outboundDo(r outboundRequest) (outboundResponse, error)
+//    - Retries back off 250ms, 500ms, 1s, ... Only ask for retries
+//      where sending twice is harmless (hCaptcha tokens are single-use,
+//      for example).
+//    - A non-2xx answer is not an error; callers decide what it means.
+//      The error is only set when we got no answer at all.
+// ─────────────────────────────────────────────────────────────────
+
+func outboundDo(r outboundRequest) (outboundResponse, error) {
+	timeout := r.timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	backoff := 250 * time.Millisecond
+
+	var resp outboundResponse
+	var err error
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		if attempt > 0 {
+			logDebugf("outbound", "%s: retrying in %s (attempt %d)", r.target, backoff, attempt+1)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		resp, err = outboundAttempt(r, timeout)
+		if err == nil && resp.status != 429 && resp.status < 500 {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+func outboundAttempt(r outboundRequest, timeout time.Duration) (outboundResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tags := []string{"target:" + r.target}
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, bytes.NewReader(r.body))
+	if err != nil {
+		return outboundResponse{}, err
+	}
+	req.Header.Set("User-Agent", "Helix")
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if r.requestID != "" {
+		req.Header.Set("X-Request-Id", r.requestID)
+	}
+	for name, value := range r.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		incCounter("outbound_requests", append(tags, "status_class:error"), 1)
+		return outboundResponse{}, fmt.Errorf("%s %s: %w", r.method, r.url, err)
+	}
+	defer resp.Body.Close()
+	limit := r.maxBody
+	if limit <= 0 {
+		limit = maxOutboundBody
+	}
+	if r.stream != nil && resp.StatusCode/100 == 2 {
+		limited := &io.LimitedReader{R: resp.Body, N: limit + 1}
+		err := r.stream(limited)
+		observeTiming("outbound_duration", tags, time.Since(start))
+		incCounter("outbound_requests", append(tags, "status_class:"+statusClass(resp.StatusCode)), 1)
+		if err == nil && limited.N == 0 {
+			err = fmt.Errorf("response larger than %d bytes", limit)
+		}
+		if err != nil {
+			return outboundResponse{}, fmt.Errorf("%s %s: streaming response: %w", r.method, r.url, err)
+		}
+		logDebugf("outbound", "%s %s → %d in %s (streamed)", r.method, r.url, resp.StatusCode, time.Since(start))
+		return outboundResponse{status: resp.StatusCode}, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	observeTiming("outbound_duration", tags, time.Since(start))
+	incCounter("outbound_requests", append(tags, "status_class:"+statusClass(resp.StatusCode)), 1)
+	if err != nil {
+		return outboundResponse{}, fmt.Errorf("%s %s: reading response: %w", r.method, r.url, err)
+	}
+	if int64(len(body)) > limit {
+		return outboundResponse{}, fmt.Errorf("%s %s: response larger than %d bytes", r.method, r.url, limit)
+	}
+	logDebugf("outbound", "%s %s → %d in %s", r.method, r.url, resp.StatusCode, time.Since(start))
+	return outboundResponse{status: resp.StatusCode, body: body}, nil
+}
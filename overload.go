@@ -0,0 +1,150 @@
+// overload.go
+
+package main
+
+import (
+	"fmt"         //config errors
+	"maps"        //copying the defaults
+	"math"        //rounding Retry-After up to whole seconds
+	"math/rand"   //jitter
+	"strconv"     //Retry-After header
+	"sync/atomic" //counting in-flight connections
+	"time"        //durations
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Overload settings
+// ─────────────────────────────────────────────────────────────────
+
+// MaxConnections caps how many connections we work on at once. Anything
+// above that gets a cheap 503 instead of piling up more file reads.
+const MaxConnections = 1024
+
+// retryPolicy decides the Retry-After we send with a limit response:
+// at least `base`, plus up to `jitter` extra so clients that were turned
+// away at the same moment don't all come back at the same moment too.
+type retryPolicy struct {
+	base   time.Duration
+	jitter time.Duration
+}
+
+// defaultRetryAfter is the policy per status code without config.
+var defaultRetryAfter = map[int]retryPolicy{
+	429: {base: 1 * time.Second, jitter: 2 * time.Second},
+	503: {base: 5 * time.Second, jitter: 5 * time.Second},
+}
+
+// RetryAfterPolicies holds the policy per status code (config
+// "retry_after"; requests use the copy in their siteSettings, so a
+// reload applies it):
+//
+//	retry_after:
+//	  429:
+//	    base: 2s
+//	    jitter: 3s
+var RetryAfterPolicies = maps.Clone(defaultRetryAfter)
+
+// activeConns is the number of connections currently being handled.
+var activeConns atomic.Int64
+
+// shedEvery is a test hook for the soak subcommand: when > 0, every
+// n-th request is shed as if we were overloaded, so the shedding path
+// gets exercised deterministically without real overload.
+var (
+	shedEvery   atomic.Int64
+	shedCounter atomic.Int64
+)
+
+// overloaded reports whether this request should be shed.
+func overloaded(inFlight int64) bool {
+	if inFlight > MaxConnections {
+		return true
+	}
+	n := shedEvery.Load()
+	return n > 0 && shedCounter.Add(1)%n == 0
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  writeLimitResponse(w, req, statusCode, hint)
+//    - The one place 429 Too Many Requests / 503 Service Unavailable
+//      responses are written.
+//    - hint is how long the caller thinks the client should wait (e.g.
+//      until its rate limit bucket has a token again); the policy base
+//      is used if the hint is shorter.
+//    - Body is the usual error page (e.g. /429.html), or JSON/plain
+//      text depending on Accept (see serveErrorPage).
+// ─────────────────────────────────────────────────────────────────
+
+func writeLimitResponse(w ResponseWriter, req *request, statusCode int, hint time.Duration) {
+	wait := retryAfter(req.site.retryAfter[statusCode], hint)
+	w.Header().Set("Retry-After", strconv.Itoa(wait))
+	w.Header().Set("Cache-Control", "no-store")
+
+	//Same custom/built-in pages (or JSON/plain bodies) as every other error
+	serveErrorPage(w, req, statusCode)
+}
+
+// retryAfter turns the policy + hint into whole seconds (Retry-After
+// doesn't do fractions), never less than 1.
+func retryAfter(policy retryPolicy, hint time.Duration) int {
+	wait := policy.base
+	if hint > wait {
+		wait = hint
+	}
+	if policy.jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(policy.jitter)))
+	}
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// setRetryAfter reads "retry_after": 429 and/or 503, each with base
+// and/or jitter. Anything left out keeps its default.
+func setRetryAfter(v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a mapping of 429/503 to base, jitter")
+	}
+	policies := maps.Clone(defaultRetryAfter)
+	for k, item := range m {
+		code, _ := strconv.Atoi(k)
+		policy, known := policies[code]
+		fields, ok := item.(map[string]any)
+		if !known || !ok {
+			return fmt.Errorf("%s: expected 429 or 503 with base, jitter", k)
+		}
+		for field, fv := range fields {
+			value, err := configString(fv)
+			if err != nil {
+				return fmt.Errorf("%d.%s: %w", code, field, err)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return fmt.Errorf("%d.%s: expected a duration like 5s, got %q", code, field, value)
+			}
+			switch field {
+			case "base":
+				policy.base = d
+			case "jitter":
+				policy.jitter = d
+			default:
+				return fmt.Errorf("%d: unknown setting %q", code, field)
+			}
+		}
+		policies[code] = policy
+	}
+	RetryAfterPolicies = policies
+	return nil
+}
+
+// retryAfterForConfig shows the policies for /config.
+func retryAfterForConfig() any {
+	out := make(map[int]any, len(RetryAfterPolicies))
+	for code, p := range RetryAfterPolicies {
+		out[code] = map[string]string{"base": p.base.String(), "jitter": p.jitter.String()}
+	}
+	return out
+}
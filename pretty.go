@@ -0,0 +1,68 @@
+// pretty.go
+
+package main
+
+import (
+	"os"            //stat
+	"path"          //URL paths
+	"path/filepath" //local paths
+	"strings"       //suffixes
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Pretty URLs (pretty_urls: true, per site)
+//    - /about serves about.html when there is no about file or
+//      directory; /about/ keeps serving about/index.html.
+//    - One URL per page: /about.html redirects (301) to /about,
+//      /docs/index.html to /docs/, and a directory asked for without
+//      the trailing slash to /docs/, so relative links in its index
+//      resolve the same way wherever the page was reached from.
+//    - .html URLs whose pretty form would land somewhere else (an
+//      "about" directory next to about.html) are served as they are.
+// ─────────────────────────────────────────────────────────────────
+
+var PrettyURLs = false
+
+// prettyTarget resolves what pretty URLs change about urlPath, or
+// returns ok=false to let resolveStatic carry on as usual.
+func prettyTarget(site *siteSettings, urlPath, cleanPath, rawQuery string) (staticTarget, bool) {
+	localPath := filepath.Join(site.root, cleanPath)
+	info, err := os.Stat(localPath)
+
+	switch {
+	case err == nil && info.IsDir() && !strings.HasSuffix(urlPath, "/"):
+		return staticTarget{redirect: withQuery(escapePath(strings.TrimSuffix(cleanPath, "/")+"/"), rawQuery)}, true
+
+	case err == nil && info.Mode().IsRegular() && strings.HasSuffix(cleanPath, ".html"):
+		if canonical := prettyCanonical(site, cleanPath); canonical != "" {
+			return staticTarget{redirect: withQuery(escapePath(canonical), rawQuery)}, true
+		}
+
+	case os.IsNotExist(err) && path.Ext(cleanPath) == "" && cleanPath != "/":
+		if info, err := os.Stat(localPath + ".html"); err == nil && info.Mode().IsRegular() {
+			return staticTarget{cleanPath: cleanPath, rawQuery: rawQuery, localPath: localPath + ".html", info: info}, true
+		}
+	}
+	return staticTarget{}, false
+}
+
+// prettyCanonical is the pretty URL of an existing .html file, "" if
+// it has none that would lead back to it.
+func prettyCanonical(site *siteSettings, cleanPath string) string {
+	dir, name := path.Split(cleanPath)
+	if name == site.index {
+		return dir
+	}
+	pretty := strings.TrimSuffix(cleanPath, ".html")
+	if _, err := os.Stat(filepath.Join(site.root, pretty)); !os.IsNotExist(err) {
+		return "" //"/about" is taken by something else
+	}
+	return pretty
+}
+
+func withQuery(path, rawQuery string) string {
+	if rawQuery == "" {
+		return path
+	}
+	return path + "?" + rawQuery
+}
@@ -0,0 +1,156 @@
+// privacy.go
+
+package main
+
+import (
+	"crypto/hmac"   //keyed IP hashes
+	"crypto/rand"   //salts
+	"crypto/sha256" //keyed IP hashes
+	"encoding/hex"  //hash output
+	"errors"        //collecting failures
+	"fmt"           //errors
+	"net"           //splitting host:port
+	"net/netip"     //truncating addresses
+	"os"            //deleting old logs
+	"path/filepath" //finding rotated logs
+	"sync"          //the current salt
+	"time"          //rotation + retention
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Privacy mode (GDPR)
+//    - PrivacyIPMode (config "privacy.ip_mode") is how client
+//      addresses appear in every log line: the request log, error,
+//      slow-request and bad-request lines, request dumps and the
+//      debug lines of the bot, challenge and accept filters.
+//        full      as they are, "203.0.113.7:51234" (the default)
+//        truncate  the network only: IPv4 to /24, IPv6 to /48,
+//                  "203.0.113.0"
+//        hash      a keyed hash, "ip-5e1f0c7a29b4", the same for one
+//                  address while the salt lasts, so one visitor's
+//                  requests can still be told apart
+//      The salt is random, lives in memory only and is replaced every
+//      PrivacySaltRotation (0 = only on restart), after which the
+//      same address hashes differently; nobody, us included, can turn
+//      a hash back into an address. Ports are dropped in both modes.
+//    - Bans, rate limits and the other defenses still see the real
+//      address; it just isn't written down. Download statistics and
+//      metrics never held addresses.
+//    - PrivacyDropUserAgent / PrivacyDropReferer leave those headers
+//      out of request dumps (the request log itself never has them).
+//    - LogRetentionDays (config "log.retention_days") > 0 deletes
+//      rotated logs (server.log.1, server.log.2.gz, and those of the
+//      vhost logs) last written more than that many days ago, checked
+//      hourly. The live log files are never touched.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	PrivacyIPMode        = "full" //"full", "truncate" or "hash"
+	PrivacySaltRotation  = 24 * time.Hour
+	PrivacyDropUserAgent = false
+	PrivacyDropReferer   = false
+	LogRetentionDays     = 0 //0 keeps rotated logs forever
+)
+
+var (
+	saltMu      sync.Mutex
+	salt        []byte
+	saltExpires time.Time
+)
+
+func init() {
+	registerJob("expire-logs", time.Hour, expireOldLogs)
+}
+
+// checkPrivacy rejects an unknown PrivacyIPMode.
+func checkPrivacy() error {
+	switch PrivacyIPMode {
+	case "full", "truncate", "hash":
+		return nil
+	}
+	return fmt.Errorf("privacy.ip_mode %q: expected full, truncate or hash", PrivacyIPMode)
+}
+
+// logAddr is a client address ("ip:port" or "ip") as logs may show it.
+func logAddr(addr string) string {
+	if PrivacyIPMode == "full" || addr == "" {
+		return addr
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return "-" //not an address we understand, so not one to write down
+	}
+	ip = ip.Unmap()
+	if PrivacyIPMode == "truncate" {
+		bits := 24
+		if ip.Is6() {
+			bits = 48
+		}
+		prefix, _ := ip.Prefix(bits)
+		return prefix.Addr().String()
+	}
+	mac := hmac.New(sha256.New, currentSalt())
+	mac.Write(ip.AsSlice())
+	return "ip-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+func currentSalt() []byte {
+	saltMu.Lock()
+	defer saltMu.Unlock()
+	now := time.Now()
+	if salt == nil || PrivacySaltRotation > 0 && now.After(saltExpires) {
+		salt = make([]byte, 32)
+		rand.Read(salt)
+		saltExpires = now.Add(PrivacySaltRotation)
+	}
+	return salt
+}
+
+// droppedHeader reports whether request dumps leave the header out.
+func droppedHeader(name string) bool {
+	return name == "User-Agent" && PrivacyDropUserAgent || name == "Referer" && PrivacyDropReferer
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  expireOldLogs()
+//    - Scheduled job: deletes rotated logs older than LogRetentionDays.
+// ─────────────────────────────────────────────────────────────────
+
+func expireOldLogs() error {
+	if LogRetentionDays <= 0 {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -LogRetentionDays)
+	current := []string{serverLogPath()}
+	reloadMu.Lock() //a reload refills Vhosts
+	for _, v := range Vhosts {
+		if v.LogFile != "" {
+			current = append(current, v.LogFile)
+		}
+	}
+	reloadMu.Unlock()
+	var errs []error
+	for _, path := range current {
+		matches, err := filepath.Glob(path + ".*")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, old := range matches {
+			info, err := os.Stat(old)
+			if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := os.Remove(old); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			logInfof("log", "Deleted %s, older than %d days", old, LogRetentionDays)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+// profiles.go
+
+package main
+
+import (
+	"fmt"     //errors
+	"maps"    //listing the names
+	"slices"  //sorted names
+	"strings" //joining them
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Config profiles (-profile dev|prod|hardened)
+//    - A profile is a bundle of config keys for a common kind of
+//      deployment: timeouts, caching, logging and security headers.
+//      It is applied on top of the defaults and below everything
+//      else, so the config file, HELIX_* variables and flags still
+//      override any key of it, one key at a time.
+//    - "headers" is one key: a headers mapping of your own replaces
+//      the profile's list rather than adding to it.
+//    - dev: debug logging, directory listings, no file cache or fast
+//      lane, "Cache-Control: no-store" so edits show up on reload, and
+//      a low slow-request threshold to spot slow pages early.
+//    - prod: a bigger file cache, shorter idle connections and the
+//      usual browser hardening headers.
+//    - hardened: prod with tighter request limits and keep-alive, a
+//      strict startup, no listings and a locked-down CSP.
+// ─────────────────────────────────────────────────────────────────
+
+var configProfile string //-profile, empty without one
+
+// securityHeaders are the headers prod and hardened both send.
+var securityHeaders = map[string]any{
+	"X-Content-Type-Options": "nosniff",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+	"X-Frame-Options":        "SAMEORIGIN",
+}
+
+// configProfiles hold config values as the config file would.
+var configProfiles = map[string]map[string]any{
+	"dev": {
+		"log.level":              "debug",
+		"autoindex":              "true",
+		"file_cache.size":        "0",
+		"fast_lane.enabled":      "false",
+		"slow_request_threshold": "200ms",
+		"headers":                map[string]any{"Cache-Control": "no-store"},
+	},
+	"prod": {
+		"log.level":              "info",
+		"file_cache.size":        "268435456", //256 MiB
+		"keepalive.idle_timeout": "30s",
+		"slow_request_threshold": "1s",
+		"headers":                securityHeaders,
+	},
+	"hardened": {
+		"log.level":              "info",
+		"autoindex":              "false",
+		"startup.strict":         "true",
+		"file_cache.size":        "268435456",
+		"keepalive.idle_timeout": "10s",
+		"keepalive.max_requests": "100",
+		"limits.request_line":    "4096",
+		"limits.header_bytes":    "16384",
+		"limits.header_count":    "50",
+		"slow_request_threshold": "1s",
+		"headers": map[string]any{
+			"X-Content-Type-Options":       "nosniff",
+			"Referrer-Policy":              "no-referrer",
+			"X-Frame-Options":              "DENY",
+			"Content-Security-Policy":      "default-src 'self'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'",
+			"Cross-Origin-Opener-Policy":   "same-origin",
+			"Cross-Origin-Resource-Policy": "same-origin",
+			"Permissions-Policy":           "camera=(), microphone=(), geolocation=()",
+		},
+	},
+}
+
+// profileValues returns the values of the -profile in use, nil
+// without one.
+func profileValues() (map[string]any, error) {
+	if configProfile == "" {
+		return nil, nil
+	}
+	values, ok := configProfiles[configProfile]
+	if !ok {
+		names := slices.Sorted(maps.Keys(configProfiles))
+		return nil, fmt.Errorf("-profile %q: expected one of %s", configProfile, strings.Join(names, ", "))
+	}
+	return values, nil
+}
+
+// loadProfile applies the -profile in use, if any.
+func loadProfile() error {
+	values, err := profileValues()
+	if err != nil || values == nil {
+		return err
+	}
+	return applyConfig(values, "profile "+configProfile)
+}
@@ -0,0 +1,271 @@
+// prometheus.go
+
+package main
+
+import (
+	"bufio"    //reading /proc files
+	"fmt"      //formatting samples
+	"math"     //+Inf bucket
+	"net/http" //admin endpoint
+	"os"       //reading /proc files
+	"runtime"  //Go runtime numbers
+	"sort"     //stable output
+	"strconv"  //parsing /proc files
+	"strings"  //labels + parsing
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  /metrics (admin listener)
+//    - The same numbers as StatsD and /debug/vars, in the Prometheus
+//      text format, so a scraper can pull them:
+//        * helix_<counter>_total for every counter, tags as labels
+//        * helix_request_duration_seconds histogram per route (slo.go)
+//        * process + host basics (RSS, CPU time, fds, load, memory,
+//          disk and network bytes) so small setups can skip a separate
+//          node exporter. Host numbers come from /proc and are left out
+//          where there is none.
+// ─────────────────────────────────────────────────────────────────
+
+func init() {
+	adminMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeCounterMetrics(bw)
+		writeRouteHistograms(bw)
+		writeHostMetrics(bw)
+		bw.Flush()
+	})
+}
+
+// promLabels turns "key:value,key:value" tags into {key="value",...}.
+func promLabels(tags string, extra ...string) string {
+	var parts []string
+	if tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			k, v, _ := strings.Cut(tag, ":")
+			parts = append(parts, promName(k)+"="+strconv.Quote(v))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// promName keeps [a-zA-Z0-9_] and replaces everything else with "_".
+func promName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func writeMetricHeader(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounterMetrics(w *bufio.Writer) {
+	byName := make(map[string][]metricKey)
+	snap := counterSnapshot()
+	for key := range snap {
+		byName[key.name] = append(byName[key.name], key)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys := byName[name]
+		sort.Slice(keys, func(i, j int) bool { return keys[i].tags < keys[j].tags })
+		metric := "helix_" + promName(name) + "_total"
+		writeMetricHeader(w, metric, "counter", "Helix counter "+name+".")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %d\n", metric, promLabels(key.tags), snap[key])
+		}
+	}
+}
+
+func writeRouteHistograms(w *bufio.Writer) {
+	sloMu.Lock()
+	defer sloMu.Unlock()
+	routes := make([]string, 0, len(routeHists))
+	for route := range routeHists {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	const metric = "helix_request_duration_seconds"
+	writeMetricHeader(w, metric, "histogram", "Request duration by route.")
+	for _, route := range routes {
+		h := routeHists[route]
+		var cumulative int64
+		for i, upper := range latencyBuckets {
+			cumulative += h.counts[i]
+			le := "+Inf"
+			if !math.IsInf(upper, 1) {
+				le = strconv.FormatFloat(upper, 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", metric, promLabels("", "route", route, "le", le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %g\n", metric, promLabels("", "route", route), h.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", metric, promLabels("", "route", route), h.count)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Host + process metrics
+// ─────────────────────────────────────────────────────────────────
+
+func writeHostMetrics(w *bufio.Writer) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	gauge := func(name, help string, value float64) {
+		writeMetricHeader(w, name, "gauge", help)
+		fmt.Fprintf(w, "%s %g\n", name, value)
+	}
+
+	gauge("go_goroutines", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	gauge("go_memstats_heap_alloc_bytes", "Live heap bytes.", float64(m.HeapAlloc))
+	gauge("go_memstats_sys_bytes", "Bytes obtained from the OS.", float64(m.Sys))
+	if rss, ok := processRSS(); ok {
+		gauge("process_resident_memory_bytes", "Resident memory size in bytes.", float64(rss))
+	}
+	if user, system, ok := processCPUTime(); ok {
+		writeMetricHeader(w, "process_cpu_seconds_total", "counter", "User and system CPU time spent in seconds.")
+		fmt.Fprintf(w, "process_cpu_seconds_total %g\n", user+system)
+	}
+	if fds := openFDs(); fds >= 0 {
+		gauge("process_open_fds", "Number of open file descriptors.", float64(fds))
+	}
+	gauge("helix_active_connections", "Requests being handled right now.", float64(activeConns.Load()))
+
+	if load, ok := readLoadAverage(); ok {
+		gauge("node_load1", "1m load average.", load)
+	}
+	if avail, total, ok := readMemInfo(); ok {
+		gauge("node_memory_MemAvailable_bytes", "Memory available for new processes.", float64(avail))
+		gauge("node_memory_MemTotal_bytes", "Total memory.", float64(total))
+	}
+
+	disks := diskStatesSnapshot()
+	volumes := make([]string, 0, len(disks))
+	for name := range disks {
+		volumes = append(volumes, name)
+	}
+	sort.Strings(volumes)
+	writeMetricHeader(w, "helix_disk_free_bytes", "gauge", "Free bytes on the volumes we write to or serve from.")
+	for _, name := range volumes {
+		fmt.Fprintf(w, "helix_disk_free_bytes%s %d\n", promLabels("", "volume", name, "path", disks[name].Path), disks[name].FreeBytes)
+	}
+	writeMetricHeader(w, "helix_disk_total_bytes", "gauge", "Size of the volumes we write to or serve from.")
+	for _, name := range volumes {
+		fmt.Fprintf(w, "helix_disk_total_bytes%s %d\n", promLabels("", "volume", name, "path", disks[name].Path), disks[name].TotalBytes)
+	}
+
+	if devs, ok := readNetDev(); ok {
+		writeMetricHeader(w, "node_network_receive_bytes_total", "counter", "Bytes received per network device.")
+		for _, d := range devs {
+			fmt.Fprintf(w, "node_network_receive_bytes_total%s %d\n", promLabels("", "device", d.name), d.rx)
+		}
+		writeMetricHeader(w, "node_network_transmit_bytes_total", "counter", "Bytes sent per network device.")
+		for _, d := range devs {
+			fmt.Fprintf(w, "node_network_transmit_bytes_total%s %d\n", promLabels("", "device", d.name), d.tx)
+		}
+	}
+}
+
+// processRSS reads our resident set size from /proc/self/statm.
+func processRSS() (int64, bool) {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * int64(os.Getpagesize()), true
+}
+
+func readLoadAverage() (float64, bool) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
+
+// readMemInfo returns MemAvailable and MemTotal in bytes.
+func readMemInfo() (avail, total int64, ok bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text()) //"MemTotal:  16318412 kB"
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			avail = kb * 1024
+		}
+	}
+	return avail, total, total > 0
+}
+
+type netDev struct {
+	name   string
+	rx, tx int64
+}
+
+// readNetDev reads per-device byte counters from /proc/net/dev.
+func readNetDev() ([]netDev, bool) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var devs []netDev
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		name, rest, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue //the two header lines
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 9 {
+			continue
+		}
+		rx, err1 := strconv.ParseInt(fields[0], 10, 64)
+		tx, err2 := strconv.ParseInt(fields[8], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		devs = append(devs, netDev{name: strings.TrimSpace(name), rx: rx, tx: tx})
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i].name < devs[j].name })
+	return devs, true
+}
@@ -0,0 +1,231 @@
+// range.go
+
+package main
+
+import (
+	"cmp"      //sorting ranges
+	"errors"   //error kinds
+	"fmt"      //Content-Range
+	"io"       //reading just the parts
+	"net/http" //If-Range dates
+	"slices"   //checking for overlaps
+	"strconv"  //offsets
+	"strings"  //parsing
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Range requests
+//    - Static files advertise "Accept-Ranges: bytes". A GET/HEAD with
+//          Range: bytes=0-499      first 500 bytes
+//          Range: bytes=500-       from 500 to the end
+//          Range: bytes=-500       last 500 bytes
+//      gets "206 Partial Content" with just those bytes and a
+//      "Content-Range: bytes 0-499/1234" header.
+//    - A range starting past the end is unsatisfiable: 416 with
+//      "Content-Range: bytes */1234". A Range header we can't parse,
+//      or one in another unit, is ignored and the whole file sent.
+//    - Several ranges (PDF viewers, download managers) get one
+//      "multipart/byteranges" body, each part with its own
+//      Content-Type and Content-Range, in the order asked for.
+//      Overlapping ranges, more than maxRanges of them, or parts
+//      adding up to more than the file are answered with the whole
+//      file instead; that's always allowed and stops a client from
+//      making us send the same bytes many times over.
+//    - Parts are read straight from the file, never the whole of it,
+//      so seeking around a multi-GB video costs what is sent.
+//    - "If-Range: <ETag or date>" honours the Range only if the file
+//      is still that version; a resumed download of a file that has
+//      changed since gets a 200 with all of the new one instead of
+//      new bytes spliced onto old ones. Weak ETags never match.
+// ─────────────────────────────────────────────────────────────────
+
+// ErrRangeNotSatisfiable is for ranges entirely outside the file.
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// maxRanges caps the parts of a multipart/byteranges answer.
+const maxRanges = 16
+
+// byteRange is the half-open interval [start, end).
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end-1, size)
+}
+
+// parseRange reads a Range header for a file of size bytes. ok is
+// false when the header should be ignored.
+func parseRange(header string, size int64) (ranges []byteRange, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, false, nil
+	}
+	unsatisfiable := false
+	for _, part := range strings.Split(spec, ",") {
+		first, last, found := strings.Cut(strings.TrimSpace(part), "-")
+		if !found {
+			return nil, false, nil
+		}
+		var r byteRange
+		switch {
+		case first == "":
+			//suffix: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, false, nil
+			}
+			if n == 0 {
+				unsatisfiable = true
+				continue
+			}
+			r = byteRange{start: max(size-n, 0), end: size}
+		default:
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, false, nil
+			}
+			r = byteRange{start: start, end: size}
+			if last != "" {
+				end, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, false, nil
+				}
+				r.end = min(end+1, size)
+			}
+			if start >= size {
+				unsatisfiable = true
+				continue
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 && unsatisfiable {
+		return nil, true, ErrRangeNotSatisfiable
+	}
+	return ranges, len(ranges) > 0, nil
+}
+
+// worthSplitting reports whether ranges are few, disjoint and smaller
+// than the file together, see above.
+func worthSplitting(ranges []byteRange, size int64) bool {
+	if len(ranges) > maxRanges {
+		return false
+	}
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b byteRange) int { return cmp.Compare(a.start, b.start) })
+	var total int64
+	for i, r := range sorted {
+		if i > 0 && r.start < sorted[i-1].end {
+			return false
+		}
+		total += r.end - r.start
+	}
+	return total < size
+}
+
+// ifRangeHolds reports whether a Range may be honoured: there is no
+// If-Range, or it names the version about to be sent, whose ETag and
+// Last-Modified are in h. Otherwise the client's partial copy is of
+// an older version and it gets the whole file (RFC 9110 13.1.5).
+func ifRangeHolds(req *request, h Header) bool {
+	ifRange := strings.TrimSpace(req.header.Get("If-Range"))
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagListMatches(ifRange, h.Get("ETag"), false) //strong only
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	modTime, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && t.Equal(modTime)
+}
+
+// serveRange answers a Range request for the size bytes of content,
+// if it is one, and says which parts it sent. handled is false when
+// the whole file should be sent instead. Only the parts asked for are
+// read from content.
+func serveRange(w ResponseWriter, req *request, content io.ReaderAt, size int64) (sent []byteRange, handled bool, err error) {
+	header := req.header.Get("Range")
+	if header == "" || !ifRangeHolds(req, w.Header()) {
+		return nil, false, nil
+	}
+	ranges, ok, err := parseRange(header, size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return nil, true, fmt.Errorf("%w: %q for %d bytes", err, header, size)
+	}
+	if !ok || (len(ranges) > 1 && !worthSplitting(ranges, size)) {
+		return nil, false, nil
+	}
+
+	var length int64
+	var writeBody func(dst io.Writer) error
+	if len(ranges) == 1 {
+		r := ranges[0]
+		length = r.end - r.start
+		writeBody = func(dst io.Writer) error {
+			_, err := io.Copy(dst, io.NewSectionReader(content, r.start, length))
+			return err
+		}
+		w.Header().Set("Content-Range", r.contentRange(size))
+	} else {
+		boundary := "helix-" + newRequestID()
+		heads, tail := multipartHeads(ranges, size, w.Header().Get("Content-Type"), boundary)
+		length = int64(len(tail))
+		for i, r := range ranges {
+			length += int64(len(heads[i])) + r.end - r.start
+		}
+		writeBody = func(dst io.Writer) error {
+			for i, r := range ranges {
+				io.WriteString(dst, heads[i])
+				if _, err := io.Copy(dst, io.NewSectionReader(content, r.start, r.end-r.start)); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(dst, tail)
+			return err
+		}
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if err := signWritten(w, req, writeBody); err != nil {
+		w.Header().Del("Content-Range")
+		return nil, true, fmt.Errorf("read %s: %w", req.path(), err) //nothing sent yet → 500
+	}
+	w.WriteHeader(206)
+	//If reading fails now there's nobody left to send an error page to;
+	//the short body closes the connection (see response.go)
+	if err := writeBody(w); err != nil {
+		logWarnf("server", "id=%s – %s cut short: %v", req.id, req.path(), err)
+	}
+	return ranges, true, nil
+}
+
+// multipartHeads returns what goes before each part of a
+// multipart/byteranges body, and after the last:
+//
+//	--boundary
+//	Content-Type: text/plain
+//	Content-Range: bytes 0-9/1234
+//
+//	<10 bytes>
+//	--boundary--
+func multipartHeads(ranges []byteRange, size int64, contentType, boundary string) (heads []string, tail string) {
+	for i, r := range ranges {
+		var b strings.Builder
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("--" + boundary + "\r\n")
+		if contentType != "" {
+			b.WriteString("Content-Type: " + contentType + "\r\n")
+		}
+		b.WriteString("Content-Range: " + r.contentRange(size) + "\r\n\r\n")
+		heads = append(heads, b.String())
+	}
+	return heads, "\r\n--" + boundary + "--\r\n"
+}
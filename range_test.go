@@ -0,0 +1,190 @@
+// range_test.go
+
+package main
+
+import (
+	"bufio"   //recorder Hijack
+	"bytes"   //recorded body
+	"errors"  //error kinds
+	"net"     //recorder Hijack
+	"slices"  //comparing ranges
+	"strconv" //Content-Length
+	"strings" //file content
+	"testing" //test runner
+)
+
+// recorder is a ResponseWriter that keeps what the handler wrote.
+type recorder struct {
+	header Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder { return &recorder{header: make(Header)} }
+
+func (r *recorder) Header() Header { return r.header }
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(200)
+	return r.body.Write(p)
+}
+func (r *recorder) Flush() error { return nil }
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("recorder can't be hijacked")
+}
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+	tests := []struct {
+		header  string
+		want    []byteRange
+		ok      bool
+		wantErr error
+	}{
+		{"bytes=0-9", []byteRange{{0, 10}}, true, nil},
+		{"bytes=10-", []byteRange{{10, 100}}, true, nil},
+		{"bytes=-10", []byteRange{{90, 100}}, true, nil},
+		{"bytes=-500", []byteRange{{0, 100}}, true, nil},
+		{"bytes=90-500", []byteRange{{90, 100}}, true, nil},
+		{"bytes=99-99", []byteRange{{99, 100}}, true, nil},
+		{"bytes=0-0, -1", []byteRange{{0, 1}, {99, 100}}, true, nil},
+		{"bytes= 0-4 , 10-14", []byteRange{{0, 5}, {10, 15}}, true, nil},
+		{"bytes=0-9,200-300", []byteRange{{0, 10}}, true, nil}, //the satisfiable part
+
+		{"bytes=100-", nil, true, ErrRangeNotSatisfiable},
+		{"bytes=200-300", nil, true, ErrRangeNotSatisfiable},
+		{"bytes=-0", nil, true, ErrRangeNotSatisfiable},
+
+		//ignored: the whole file is sent
+		{"", nil, false, nil},
+		{"items=0-9", nil, false, nil},
+		{"bytes=", nil, false, nil},
+		{"bytes=5", nil, false, nil},
+		{"bytes=9-0", nil, false, nil},
+		{"bytes=-", nil, false, nil},
+		{"bytes=a-9", nil, false, nil},
+		{"bytes=0-9,x", nil, false, nil},
+		{"bytes=--5", nil, false, nil},
+	}
+	for _, tt := range tests {
+		ranges, ok, err := parseRange(tt.header, size)
+		if !slices.Equal(ranges, tt.want) || ok != tt.ok || !errors.Is(err, tt.wantErr) {
+			t.Errorf("parseRange(%q) = %v, %v, %v; want %v, %v, %v", tt.header, ranges, ok, err, tt.want, tt.ok, tt.wantErr)
+		}
+	}
+}
+
+func TestWorthSplitting(t *testing.T) {
+	many := make([]byteRange, maxRanges+1)
+	for i := range many {
+		many[i] = byteRange{int64(i) * 2, int64(i)*2 + 1}
+	}
+	tests := []struct {
+		name   string
+		ranges []byteRange
+		want   bool
+	}{
+		{"disjoint", []byteRange{{0, 10}, {20, 30}}, true},
+		{"out of order", []byteRange{{20, 30}, {0, 10}}, true},
+		{"touching", []byteRange{{0, 10}, {10, 20}}, true},
+		{"overlapping", []byteRange{{0, 10}, {5, 15}}, false},
+		{"overlapping out of order", []byteRange{{5, 15}, {0, 10}}, false},
+		{"the whole file", []byteRange{{0, 50}, {50, 100}}, false},
+		{"too many", many, false},
+		{"as many as allowed", many[:maxRanges], true},
+	}
+	for _, tt := range tests {
+		if got := worthSplitting(tt.ranges, 100); got != tt.want {
+			t.Errorf("%s: worthSplitting(%v) = %v, want %v", tt.name, tt.ranges, got, tt.want)
+		}
+	}
+}
+
+func TestIfRangeHolds(t *testing.T) {
+	h := Header{}
+	h.Set("ETag", `"v2"`)
+	h.Set("Last-Modified", "Tue, 06 Oct 2026 10:00:00 GMT")
+	tests := []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{`"v2"`, true},
+		{`"v1"`, false},
+		{`W/"v2"`, false}, //weak tags never match
+		{"Tue, 06 Oct 2026 10:00:00 GMT", true},
+		{"Tue, 06 Oct 2026 10:00:01 GMT", false},
+		{"Mon, 05 Oct 2026 10:00:00 GMT", false},
+		{"yesterday", false},
+	}
+	for _, tt := range tests {
+		req := &request{header: Header{}}
+		if tt.ifRange != "" {
+			req.header.Set("If-Range", tt.ifRange)
+		}
+		if got := ifRangeHolds(req, h); got != tt.want {
+			t.Errorf("If-Range %q: got %v, want %v", tt.ifRange, got, tt.want)
+		}
+	}
+
+	weak := Header{}
+	weak.Set("ETag", `W/"v2"`)
+	req := &request{header: Header{}}
+	req.header.Set("If-Range", `"v2"`)
+	if ifRangeHolds(req, weak) {
+		t.Error("If-Range matched a weak ETag")
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	content := strings.NewReader("0123456789abcdefghij")
+	tests := []struct {
+		name       string
+		header     string
+		handled    bool
+		status     int
+		body       string
+		contentRng string
+		wantErr    error
+	}{
+		{"single", "bytes=2-5", true, 206, "2345", "bytes 2-5/20", nil},
+		{"suffix", "bytes=-3", true, 206, "hij", "bytes 17-19/20", nil},
+		{"unsatisfiable", "bytes=30-", true, 0, "", "bytes */20", ErrRangeNotSatisfiable},
+		{"ignored", "bytes=x", false, 0, "", "", nil},
+		{"overlapping parts", "bytes=0-5,3-8", false, 0, "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRecorder()
+			req := &request{rawPath: "/f", header: Header{}}
+			req.header.Set("Range", tt.header)
+			_, handled, err := serveRange(w, req, content, 20)
+			if handled != tt.handled || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("handled %v, err %v; want %v, %v", handled, err, tt.handled, tt.wantErr)
+			}
+			if w.status != tt.status || w.body.String() != tt.body || w.header.Get("Content-Range") != tt.contentRng {
+				t.Errorf("got %d %q Content-Range %q; want %d %q %q", w.status, w.body.String(), w.header.Get("Content-Range"), tt.status, tt.body, tt.contentRng)
+			}
+		})
+	}
+
+	w := newRecorder()
+	w.header.Set("Content-Type", "text/plain")
+	req := &request{rawPath: "/f", header: Header{}}
+	req.header.Set("Range", "bytes=0-1,10-11")
+	if _, handled, err := serveRange(w, req, content, 20); !handled || err != nil {
+		t.Fatalf("multipart: handled %v, err %v", handled, err)
+	}
+	body := w.body.String()
+	if w.status != 206 || !strings.HasPrefix(w.header.Get("Content-Type"), "multipart/byteranges; boundary=") ||
+		!strings.Contains(body, "Content-Range: bytes 0-1/20\r\n\r\n01\r\n") || !strings.Contains(body, "Content-Range: bytes 10-11/20\r\n\r\nab\r\n") {
+		t.Errorf("multipart: %d %s\n%s", w.status, w.header.Get("Content-Type"), body)
+	}
+	if w.header.Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Errorf("multipart: Content-Length %s for %d bytes", w.header.Get("Content-Length"), len(body))
+	}
+}
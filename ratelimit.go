@@ -0,0 +1,160 @@
+// ratelimit.go
+
+package main
+
+import (
+	"errors" //config errors
+	"sync"   //guarding the bucket map
+	"time"   //refilling tokens
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Rate limit settings (requests per second + burst, per client IP)
+// ─────────────────────────────────────────────────────────────────
+
+// RateLimit / RateBurst are for ordinary clients and unverified bots,
+// and only refuse requests with RateLimitEnabled (config
+// "rate_limit.enabled"; off by default, since clients behind one NAT
+// share an address). Off, their buckets are still kept for the
+// challenge to pick fast clients (see challenge.go).
+var (
+	RateLimitEnabled = false
+	RateLimit        = 20 //"rate_limit.rate", requests per second
+	RateBurst        = 40 //"rate_limit.burst"
+)
+
+// FakeCrawlerRateLimit / FakeCrawlerRateBurst apply to clients that claim
+// to be a known crawler but failed DNS verification. Scrapers love to
+// pretend to be Googlebot, so they get throttled hard.
+const (
+	FakeCrawlerRateLimit = 1.0
+	FakeCrawlerRateBurst = 2.0
+)
+
+// checkRateLimit rejects a limit no request could pass.
+func checkRateLimit() error {
+	if RateLimit < 1 || RateBurst < 1 {
+		return errors.New("rate_limit.rate and rate_limit.burst must be at least 1")
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Token buckets
+//    - Each IP gets a bucket holding up to `burst` tokens, refilled
+//      at `rate` tokens per second. Every request takes one token.
+// ─────────────────────────────────────────────────────────────────
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+var (
+	bucketsMu sync.Mutex
+	buckets   = make(map[string]*tokenBucket)
+)
+
+// bucketIdleTimeout is how long a bucket may sit unused before we forget
+// it. By then it would have refilled completely anyway.
+const bucketIdleTimeout = 10 * time.Minute
+
+func init() {
+	registerJob("prune-buckets", time.Minute, pruneIdleBuckets)
+}
+
+// pruneIdleBuckets drops buckets nobody has used for a while so the map
+// doesn't grow forever.
+func pruneIdleBuckets() error {
+	now := time.Now()
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	for k, b := range buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTimeout {
+			delete(buckets, k)
+		}
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  allowRequest(ip string, tag clientTag) (bool, time.Duration)
+//    - Verified crawlers are never limited, fake ones always; everyone
+//      else only with RateLimitEnabled.
+//    - When the request is refused, also returns how long until the
+//      bucket has a token again (used for Retry-After).
+//    - Fake crawlers get their own (much smaller) bucket so they can't
+//      drain the bucket of a real user behind the same IP and vice versa.
+// ─────────────────────────────────────────────────────────────────
+
+func allowRequest(ip string, tag clientTag) (bool, time.Duration) {
+	if tag.kind == clientVerifiedCrawler || soakRunning.Load() {
+		return true, 0
+	}
+	key, rate, burst := bucketParams(ip, tag)
+	ok, wait := takeToken(key, rate, burst)
+	if !ok && tag.kind != clientFakeCrawler && !RateLimitEnabled {
+		return true, 0 //only counted, see RateLimitEnabled
+	}
+	return ok, wait
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  takeToken(key, rate, burst) (bool, time.Duration)
+//    - Takes one token from the bucket called key, creating it full
+//      if it doesn't exist yet. Shared by the request limiter above and
+//      the connection limiter in acceptfilter.go.
+// ─────────────────────────────────────────────────────────────────
+
+func takeToken(key string, rate, burst float64) (bool, time.Duration) {
+	now := time.Now()
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+
+	b, ok := buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, lastSeen: now}
+		buckets[key] = b
+	}
+	//Refill for the time that passed since the last request
+	b.tokens += now.Sub(b.lastSeen).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		logDebugf("ratelimit", "limiting %s, next token in %s", key, wait)
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  nearRateLimit(ip, tag, fraction) bool
+//    - True once a client has used more than `fraction` of its bucket,
+//      i.e. it is sending requests much faster than it is allowed to
+//      sustain. Used to pick clients for the anti-bot challenge.
+// ─────────────────────────────────────────────────────────────────
+
+func nearRateLimit(ip string, tag clientTag, fraction float64) bool {
+	if tag.kind == clientVerifiedCrawler {
+		return false
+	}
+	key, _, burst := bucketParams(ip, tag)
+
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	b, ok := buckets[key]
+	return ok && b.tokens < burst*(1-fraction)
+}
+
+// bucketParams picks the bucket key and limits for a client.
+func bucketParams(ip string, tag clientTag) (key string, rate, burst float64) {
+	if tag.kind == clientFakeCrawler {
+		return "fake|" + ip, FakeCrawlerRateLimit, FakeCrawlerRateBurst
+	}
+	return ip, float64(RateLimit), float64(RateBurst)
+}
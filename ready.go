@@ -0,0 +1,124 @@
+// ready.go
+
+package main
+
+import (
+	"encoding/json" //readiness report
+	"fmt"           //reasons
+	"net/http"      //admin endpoint
+	"sort"          //stable reasons
+	"strings"       //path matching
+	"sync"          //transition logging
+	"sync/atomic"   //drain flag
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Readiness
+//    - ReadinessPath on the public listener answers 200 while this
+//      instance is fit to take traffic and 503 (with the reasons) when
+//      it isn't, so a load balancer health check takes a degraded node
+//      out of rotation by itself and puts it back once it recovers.
+//    - Not ready when:
+//        * a watched volume is critically low on space (diskwatch.go)
+//        * more than ReadyMaxErrorRate of the last ReadyErrorWindow
+//          minutes' requests (at least ReadyMinRequests) were 5xx
+//        * an operator drained it: POST /ready?drain=1 on the admin
+//          listener (drain=0 undoes it)
+//    - GET /ready on the admin listener shows the same report.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	ReadinessPath     = "/__helix/ready"
+	ReadyMaxErrorRate = 0.5
+	ReadyErrorWindow  = int64(5) //minutes
+	ReadyMinRequests  = int64(20)
+)
+
+var (
+	draining  atomic.Bool
+	readyMu   sync.Mutex
+	lastReady = true
+)
+
+type readiness struct {
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func checkReadiness() readiness {
+	var reasons []string
+	if draining.Load() {
+		reasons = append(reasons, "draining")
+	}
+	for name, s := range diskStatesSnapshot() {
+		if s.State == diskCritical {
+			reasons = append(reasons, fmt.Sprintf("disk %s critical (%s free)", name, humanBytes(int64(s.FreeBytes))))
+		}
+	}
+	if total, errors, _ := sloWindow(ReadyErrorWindow); total >= ReadyMinRequests {
+		if rate := float64(errors) / float64(total); rate > ReadyMaxErrorRate {
+			reasons = append(reasons, fmt.Sprintf("error rate %.0f%% over %dm", rate*100, ReadyErrorWindow))
+		}
+	}
+	sort.Strings(reasons)
+	r := readiness{Ready: len(reasons) == 0, Reasons: reasons}
+
+	//Log changes, not every health check
+	readyMu.Lock()
+	if r.Ready != lastReady {
+		lastReady = r.Ready
+		if r.Ready {
+			logInfof("ready", "Ready for traffic again")
+		} else {
+			logWarnf("ready", "Not ready for traffic: %s", strings.Join(reasons, ", "))
+		}
+	}
+	readyMu.Unlock()
+	return r
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveReadiness(w, req) error (public listener)
+// ─────────────────────────────────────────────────────────────────
+
+// isReadinessPath takes a request's cleanPath().
+func isReadinessPath(path string) bool {
+	return path == ReadinessPath
+}
+
+func serveReadiness(w ResponseWriter, req *request) error {
+	if !readMethod(req.method) {
+		return onlyMethods("GET", "HEAD")
+	}
+	r := checkReadiness()
+	status := 200
+	if !r.Ready {
+		status = 503
+	}
+	body, _ := json.Marshal(r)
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, status, "application/json", body)
+	return nil
+}
+
+func init() {
+	adminMux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			drain := r.Form.Get("drain") == "1"
+			draining.Store(drain)
+			logInfof("ready", "Draining set to %v through the admin API", drain)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(checkReadiness())
+	})
+}
@@ -0,0 +1,221 @@
+// redirects.go
+
+package main
+
+import (
+	"bufio"         //reading the rules line by line
+	"fmt"           //parse errors
+	"os"            //stat + open
+	"path/filepath" //rules file under the root
+	"sort"          //longest placeholder first
+	"strconv"       //status codes
+	"strings"       //matching
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Redirect files (Netlify style)
+//    - A "_redirects" (or "redirects.conf") file in a site's root holds
+//      one rule per line, first match wins:
+//          /old-blog/*     /blog/:splat     301
+//          /docs/:v/latest /docs/:v/index   302
+//          /app/*          /app/index.html  200
+//          /legacy/*       /gone.html       410!
+//          # comments and blank lines are ignored
+//    - ":name" matches one path segment, a trailing "*" the rest (as
+//      ":splat"); both can be used in the target. The request's query
+//      string is carried over unless the target has its own.
+//    - The status defaults to 301. 3xx answers with Location, 200
+//      serves the target in place (an internal rewrite), 4xx serves
+//      the target as the error page with that status.
+//    - Rules only apply when no file exists at the path, unless the
+//      status ends in "!" (forced).
+//    - The file is re-read when it changes and is never served itself.
+//      Conditions (Country=, Role=, query matching) and proxying to
+//      absolute URLs with 200 are not supported; such lines are
+//      skipped with a warning.
+// ─────────────────────────────────────────────────────────────────
+
+// RedirectsEnabled turns _redirects handling on (it is, by default).
+var RedirectsEnabled = true
+
+type redirectRule struct {
+	from   pathPattern
+	to     string
+	status int
+	force  bool
+	line   int
+}
+
+func (r redirectRule) String() string {
+	force := ""
+	if r.force {
+		force = "!"
+	}
+	return fmt.Sprintf("%s → %s (%d%s, line %d)", r.from, r.to, r.status, force, r.line)
+}
+
+// redirectFiles caches each site's parsed rules (see sitefiles.go).
+var redirectFiles = newSiteFile(parseRedirects, "_redirects", "redirects.conf")
+
+func parseRedirects(path string) ([]redirectRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []redirectRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		rule, err := parseRedirectLine(scanner.Text())
+		if err != nil {
+			logWarnf("redirects", "%s:%d: %v, rule skipped", path, n, err)
+			continue
+		}
+		if rule.to != "" {
+			rule.line = n
+			rules = append(rules, rule)
+		}
+	}
+	logInfof("redirects", "Loaded %d rules from %s", len(rules), path)
+	return rules, scanner.Err()
+}
+
+// parseRedirectLine parses "from to [status[!]]"; comments and blank
+// lines give a zero rule.
+func parseRedirectLine(line string) (redirectRule, error) {
+	line, _, _ = strings.Cut(line, "#")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return redirectRule{}, nil
+	}
+	if len(fields) < 2 {
+		return redirectRule{}, fmt.Errorf("no target for %s", fields[0])
+	}
+	if len(fields) > 3 {
+		return redirectRule{}, fmt.Errorf("conditions (%s) are not supported", strings.Join(fields[3:], " "))
+	}
+	if !strings.HasPrefix(fields[0], "/") {
+		return redirectRule{}, fmt.Errorf("source %q must be a path", fields[0])
+	}
+
+	rule := redirectRule{from: parsePathPattern(fields[0]), to: fields[1], status: 301}
+	if len(fields) == 3 {
+		code, force := strings.CutSuffix(fields[2], "!")
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 200 || status > 599 || (status > 200 && status < 301) {
+			return redirectRule{}, fmt.Errorf("bad status %q", fields[2])
+		}
+		rule.status, rule.force = status, force
+	}
+	local := strings.HasPrefix(rule.to, "/")
+	if !local && !strings.HasPrefix(rule.to, "http://") && !strings.HasPrefix(rule.to, "https://") {
+		return redirectRule{}, fmt.Errorf("target %q must be a path or URL", rule.to)
+	}
+	if !local && rule.status < 300 {
+		return redirectRule{}, fmt.Errorf("proxying to %s is not supported", rule.to)
+	}
+	if !local && rule.status >= 400 {
+		return redirectRule{}, fmt.Errorf("%d target %s must be a path", rule.status, rule.to)
+	}
+	return rule, nil
+}
+
+// match returns the target for path with placeholders filled in.
+func (r redirectRule) match(path string) (string, bool) {
+	params, ok := r.from.match(path)
+	if !ok {
+		return "", false
+	}
+	return expandRedirect(r.to, params), true
+}
+
+func expandRedirect(to string, params map[string]string) string {
+	names := sortedKeys(params)
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) }) //":id" mustn't eat ":identifier"
+	for _, name := range names {
+		to = strings.ReplaceAll(to, ":"+name, params[name])
+	}
+	return to
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  matchRedirect(req) (redirectMatch, bool)
+//    - The first rule matching req's path, skipping unforced rules
+//      when a file is there. Called from matchRoute() and explain.
+// ─────────────────────────────────────────────────────────────────
+
+type redirectMatch struct {
+	rule   redirectRule
+	target string //placeholders filled in, query carried over
+	hidden bool   //the request is for the rules file itself
+}
+
+func (m redirectMatch) String() string {
+	if m.hidden {
+		return "redirects file (not served)"
+	}
+	return "redirect " + m.rule.String()
+}
+
+func (m redirectMatch) serve(w ResponseWriter, req *request) error {
+	if m.hidden {
+		return ErrNotFound
+	}
+	return serveRedirectRule(w, req, m.rule, m.target)
+}
+
+func matchRedirect(req *request) (redirectMatch, bool) {
+	if !RedirectsEnabled {
+		return redirectMatch{}, false
+	}
+	rules, file, ok := redirectFiles.get(req.site.root)
+	if !ok {
+		return redirectMatch{}, false
+	}
+	//Rules match the path as sent, their splats go out as they came in
+	path, rawQuery, _ := strings.Cut(req.rawPath, "?")
+	if filepath.Join(req.site.root, filepath.FromSlash(req.path())) == file {
+		return redirectMatch{hidden: true}, true
+	}
+
+	exists := -1 //unknown until an unforced rule matches
+	for _, rule := range rules {
+		target, ok := rule.match(path)
+		if !ok {
+			continue
+		}
+		if !rule.force {
+			if exists < 0 {
+				exists = 0
+				if _, err := resolveStatic(req.site, req.rawPath); err == nil {
+					exists = 1
+				}
+			}
+			if exists == 1 {
+				continue //the file shadows the rule
+			}
+		}
+		if rawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + rawQuery
+		}
+		return redirectMatch{rule: rule, target: target}, true
+	}
+	return redirectMatch{}, false
+}
+
+func serveRedirectRule(w ResponseWriter, req *request, rule redirectRule, target string) error {
+	incCounter("redirects", []string{"status:" + strconv.Itoa(rule.status)}, 1)
+	switch {
+	case rule.status == 200:
+		return internalRedirect(w, req, target)
+	case rule.status < 400:
+		w.Header().Set("Location", target)
+		writeBody(w, rule.status, "text/html", nil)
+		return nil
+	}
+	if serveErrorDocument(w, req, rule.status, target) != nil {
+		serveErrorPage(w, req, rule.status)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// reload.go
+
+package main
+
+import (
+	"fmt"           //errors
+	"html/template" //listing template in the snapshot
+	"log"           //vhost request logs
+	"maps"          //copying maps into a snapshot
+	"net/http"      //admin endpoint
+	"sync"          //one reload at a time
+	"sync/atomic"   //swapping the snapshot
+	"time"          //reload timestamp
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Hot reload (SIGHUP or POST /reload on the admin listener)
+//    - Request handling reads the site settings (root, index, SPA
+//      fallback, autoindex, pretty URLs, MIME map, error pages,
+//      Retry-After policies) from a siteSettings snapshot
+//      taken when the request is parsed. A reload builds a new snapshot
+//      and swaps it in; requests in flight keep the one they started
+//      with, so nothing is dropped or served half old, half new.
+//    - The snapshot is built the same way as at startup: defaults, then
+//      the config file, then HELIX_* variables, then -root/-index.
+//    - A bad file keeps the current settings and logs why.
+//    - Everything else in the file (listen address, log dir, tokens,
+//      …) is only read at startup and needs a restart.
+// ─────────────────────────────────────────────────────────────────
+
+// siteSettings is swapped as a whole, never modified in place.
+type siteSettings struct {
+	root        string
+	index       string
+	spaFallback string
+	autoindex   bool
+	prettyURLs  bool                     //see pretty.go
+	caseFold    bool                     //see casefold.go
+	listing     *template.Template       //autoindex page, see autoindex.go
+	readme      bool                     //README.md above the listing
+	host        string                   //vhost name, "" for the default site
+	requestLog  *log.Logger              //vhost request log, nil = server.log
+	vhosts      map[string]*siteSettings //by host name, see vhost.go
+	mime        map[string]string        //extension → Content-Type
+	errorDocs   map[int]string           //status → path under root
+	retryAfter  map[int]retryPolicy      //status → policy, see overload.go
+	loaded      time.Time
+}
+
+// reloadableKeys are the config keys a reload applies.
+var reloadableKeys = map[string]bool{
+	"root":               true,
+	"index":              true,
+	"spa_fallback":       true,
+	"autoindex":          true,
+	"pretty_urls":        true,
+	"case_insensitive":   true,
+	"autoindex_template": true,
+	"autoindex_readme":   true,
+	"vhosts":             true,
+	"mime":               true,
+	"error_pages":        true,
+	"retry_after":        true,
+	"jobs":               true,
+}
+
+var (
+	currentSite atomic.Pointer[siteSettings]
+	reloadMu    sync.Mutex
+	configFile  string            //-config, empty without one
+	setByFlags  map[string]string //flags given on the command line
+)
+
+func init() {
+	adminMux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reloadConfig(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "reloaded, serving %s\n", siteNow().root)
+	})
+}
+
+// siteFromSettings snapshots the package-level settings.
+func siteFromSettings() *siteSettings {
+	site := &siteSettings{
+		root:        DocRoot,
+		index:       IndexFile,
+		spaFallback: SPAFallback,
+		autoindex:   Autoindex,
+		prettyURLs:  PrettyURLs,
+		caseFold:    CaseInsensitivePaths,
+		listing:     parsedListingTemplate,
+		readme:      AutoindexReadme,
+		mime:        maps.Clone(MIMETypes),
+		errorDocs:   maps.Clone(ErrorDocuments),
+		retryAfter:  maps.Clone(RetryAfterPolicies),
+		loaded:      time.Now(),
+	}
+	site.vhosts = vhostSites(site)
+	return site
+}
+
+func siteNow() *siteSettings {
+	if s := currentSite.Load(); s != nil {
+		return s
+	}
+	return siteFromSettings()
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  reloadConfig()
+//    - Re-reads the config file and environment and swaps in the new
+//      site settings, or returns an error and changes nothing.
+// ─────────────────────────────────────────────────────────────────
+
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	fileValues := map[string]any{}
+	if configFile != "" {
+		var err error
+		if fileValues, err = readConfigFile(configFile); err != nil {
+			logErrorf("reload", "Reload failed, keeping the current settings: %v", err)
+			return err
+		}
+	}
+	profile, err := profileValues()
+	if err != nil {
+		return err
+	}
+	envValues, _, err := envConfigValues()
+	if err != nil {
+		logErrorf("reload", "Reload failed, keeping the current settings: %v", err)
+		return err
+	}
+
+	prev := siteNow()
+	prevTemplate, prevVhosts, prevJobs := AutoindexTemplate, Vhosts, JobSchedule
+	DocRoot, IndexFile, SPAFallback, Autoindex, PrettyURLs = DefaultRoot, DefaultIndex, "", false, false
+	CaseInsensitivePaths = false
+	AutoindexTemplate, AutoindexReadme, Vhosts = "", true, map[string]vhostConfig{}
+	MIMETypes, ErrorDocuments = map[string]string{}, map[int]string{}
+	RetryAfterPolicies = maps.Clone(defaultRetryAfter)
+	JobSchedule = map[string]jobSetting{}
+	err = applyConfig(reloadable(profile), "profile "+configProfile)
+	if err == nil {
+		err = applyConfig(reloadable(fileValues), configFile)
+	}
+	if err == nil {
+		err = applyConfig(reloadable(envValues), "environment")
+	}
+	if err == nil {
+		if root, ok := setByFlags["root"]; ok {
+			DocRoot = root
+		}
+		if index, ok := setByFlags["index"]; ok {
+			IndexFile = index
+		}
+		err = checkFlags()
+	}
+	if err == nil {
+		err = loadListingTemplate()
+	}
+	if err == nil {
+		err = openVhostLogs()
+	}
+	if err != nil {
+		DocRoot, IndexFile, SPAFallback, Autoindex, PrettyURLs = prev.root, prev.index, prev.spaFallback, prev.autoindex, prev.prettyURLs
+		CaseInsensitivePaths = prev.caseFold
+		AutoindexTemplate, AutoindexReadme, parsedListingTemplate = prevTemplate, prev.readme, prev.listing
+		Vhosts, JobSchedule = prevVhosts, prevJobs
+		MIMETypes, ErrorDocuments = prev.mime, prev.errorDocs
+		RetryAfterPolicies = prev.retryAfter
+		logErrorf("reload", "Reload failed, keeping the current settings: %v", err)
+		return err
+	}
+
+	next := siteFromSettings()
+	currentSite.Store(next)
+	applyJobSchedule() //see scheduler.go
+	logInfof("reload", "Configuration reloaded, serving %s (index %s, %d MIME overrides, %d error pages, %d vhosts)",
+		next.root, next.index, len(next.mime), len(next.errorDocs), len(Vhosts))
+	return nil
+}
+
+// reloadable keeps the values of keys a reload applies.
+func reloadable(values map[string]any) map[string]any {
+	out := make(map[string]any)
+	for key, v := range values {
+		if s := findConfigSetting(key); s != nil && reloadableKeys[s.key] {
+			out[key] = v
+		}
+	}
+	return out
+}
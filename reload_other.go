@@ -0,0 +1,9 @@
+// reload_other.go
+
+//go:build !unix
+
+package main
+
+// watchReload does nothing without SIGHUP; POST /reload on the admin
+// listener still works.
+func watchReload() {}
@@ -0,0 +1,23 @@
+// reload_unix.go
+
+//go:build unix
+
+package main
+
+import (
+	"os"        //signal channel type
+	"os/signal" //subscribing to SIGHUP
+	"syscall"   //SIGHUP itself
+)
+
+// watchReload reloads the configuration every time we get SIGHUP
+// (e.g. systemctl reload helix, or kill -HUP $(pidof helix)).
+func watchReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			reloadConfig() //logs its own errors
+		}
+	}()
+}
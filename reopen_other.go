@@ -0,0 +1,8 @@
+// reopen_other.go
+
+//go:build !unix
+
+package main
+
+// watchLogReopen does nothing where there is no SIGUSR1 (Windows).
+func watchLogReopen(lf *logFile) {}
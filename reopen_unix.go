@@ -0,0 +1,24 @@
+// reopen_unix.go
+
+//go:build unix
+
+package main
+
+import (
+	"os"        //signal channel type
+	"os/signal" //subscribing to SIGUSR1
+	"syscall"   //SIGUSR1 itself
+)
+
+// watchLogReopen reopens the log file every time we get SIGUSR1
+// (e.g. from logrotate's postrotate: kill -USR1 $(pidof helix)).
+func watchLogReopen(lf *logFile) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			reopenLogs(lf)
+			reopenVhostLogs() //see vhost.go
+		}
+	}()
+}
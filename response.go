@@ -0,0 +1,313 @@
+// response.go
+
+package main
+
+import (
+	"bufio"         //buffered writes to the connection
+	"errors"        //hijack errors
+	"fmt"           //chunk sizes
+	"net"           //the underlying connection
+	"net/textproto" //canonical header names
+	"slices"        //header order
+	"sort"          //stable header order
+	"strconv"       //Content-Length, status line
+	"time"          //Date header
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Header
+//    - Request and response headers, keyed by canonical name
+//      ("Content-Type"), repeated headers in order.
+// ─────────────────────────────────────────────────────────────────
+
+type Header map[string][]string
+
+func (h Header) Get(name string) string {
+	if v := h[textproto.CanonicalMIMEHeaderKey(name)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values returns every value of a repeated header, in order.
+func (h Header) Values(name string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+func (h Header) Set(name, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(name)] = []string{value}
+}
+
+func (h Header) Add(name, value string) {
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	h[key] = append(h[key], value)
+}
+
+func (h Header) Del(name string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(name))
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  ResponseWriter
+//    - What every handler writes its response through.
+//    - Set headers with Header(), then WriteHeader(status), then Write
+//      the body. Write without WriteHeader means 200 OK.
+//    - Flush pushes buffered bytes to the client right away.
+//    - A body whose length isn't known up front (no Content-Length
+//      set before WriteHeader: dynamic handlers, event streams) goes
+//      out with "Transfer-Encoding: chunked" to HTTP/1.1 clients, one
+//      chunk per Write, so it needn't be in memory first and the
+//      connection stays usable. HTTP/1.0 clients get the body as is
+//      and the connection closed to mark its end.
+//    - The status line and headers wait in memory until the body
+//      comes: small responses then leave in one write from the buffer,
+//      and a body too big for it goes out together with the headers
+//      in one writev (net.Buffers) instead of two writes.
+//    - Hijack hands over the raw connection (e.g. for WebSockets);
+//      after that the server won't touch or log the connection.
+//    - For HEAD requests the body is dropped on the way out, so
+//      handlers just serve HEAD like GET, headers and all.
+// ─────────────────────────────────────────────────────────────────
+
+type ResponseWriter interface {
+	Header() Header
+	WriteHeader(statusCode int)
+	Write(p []byte) (int, error)
+	Flush() error
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// ErrHijacked is returned by Write/Flush once the connection was hijacked.
+var ErrHijacked = errors.New("connection has been hijacked")
+
+// responseWriter is our ResponseWriter on top of a net.Conn. It keeps
+// track of the status and body bytes so they can be logged afterwards.
+type responseWriter struct {
+	conn    net.Conn
+	reader  *bufio.Reader //handed out on Hijack (may hold buffered request bytes)
+	writer  *bufio.Writer
+	version string //the client's, e.g. "HTTP/1.0"; decides framing, see http11()
+
+	header      Header
+	head        []byte //status line + headers not yet handed to writer
+	status      int
+	wroteHeader bool
+	written     int64 //body bytes written
+	hijacked    bool
+	noBody      bool //HEAD: status + headers only
+	chunked     bool //body framed as chunks, see Write
+	keepAlive   bool //another request may follow, see keepalive.go
+}
+
+func newResponseWriter(conn net.Conn, reader *bufio.Reader, method, version string) *responseWriter {
+	return &responseWriter{
+		conn:    conn,
+		reader:  reader,
+		writer:  bufio.NewWriter(conn),
+		version: version,
+		header:  make(Header),
+		noBody:  method == "HEAD",
+	}
+}
+
+// readMethod reports whether method is GET or HEAD, which every
+// handler serving content answers alike (see noBody above).
+func readMethod(method string) bool {
+	return method == "GET" || method == "HEAD"
+}
+
+func (w *responseWriter) Header() Header {
+	return w.header
+}
+
+// headerOrder is the order we always wrote these in before; everything
+// else follows alphabetically.
+var headerOrder = []string{"Date", "Content-Type", "Content-Length", "Connection"}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader || w.hijacked {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+
+	if w.header.Get("Date") == "" {
+		w.header.Set("Date", httpDate(time.Now())) //see conditional.go
+	}
+	//Without a length the client can only find the end of the body
+	//from chunked framing, or by us closing the connection
+	if w.header.Get("Content-Length") == "" && !w.noBody && bodyAllowed(statusCode) {
+		if http11(w.version) {
+			w.chunked = true
+			w.header.Set("Transfer-Encoding", "chunked")
+		} else {
+			w.keepAlive = false
+		}
+	}
+	if w.keepAlive {
+		w.header.Set("Connection", "keep-alive")
+	} else {
+		w.header.Set("Connection", "close")
+	}
+
+	w.head = append([]byte(statusLine(statusCode)), appendHeaderLines(nil, w.header)...)
+	w.head = append(w.head, "\r\n"...)
+}
+
+// writeHeaderBlock is WriteHeader for a response whose headers were
+// serialized ahead of time with appendHeaderLines (see fastlane.go).
+// Only Date, Connection and what is on w.Header() (X-Request-Id) are
+// added per request.
+func (w *responseWriter) writeHeaderBlock(statusCode int, block []byte) {
+	if w.wroteHeader || w.hijacked {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+	connection := "close"
+	if w.keepAlive {
+		connection = "keep-alive"
+	}
+	w.head = append([]byte(statusLine(statusCode)+
+		"Date: "+httpDate(time.Now())+"\r\nConnection: "+connection+"\r\n"), block...)
+	w.head = append(appendHeaderLines(w.head, w.header), "\r\n"...)
+}
+
+// statusLine is the first line of a response. It carries the version
+// we speak, whatever the client's, which is how it learns what it may
+// use on the next request.
+func statusLine(statusCode int) string {
+	return "HTTP/1.1 " + strconv.Itoa(statusCode) + " " + statusTextFor(statusCode) + "\r\n"
+}
+
+// flushHead hands the pending status line and headers to the writer.
+func (w *responseWriter) flushHead() {
+	if w.head != nil {
+		w.writer.Write(w.head)
+		w.head = nil
+	}
+}
+
+// appendHeaderLines appends h to b as "Name: value\r\n" lines, those
+// in headerOrder first.
+func appendHeaderLines(b []byte, h Header) []byte {
+	appendLines := func(name string) {
+		for _, v := range h[name] {
+			b = append(append(append(append(b, name...), ": "...), v...), "\r\n"...)
+		}
+	}
+	for _, name := range headerOrder {
+		appendLines(name)
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		if !slices.Contains(headerOrder, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		appendLines(name)
+	}
+	return b
+}
+
+// bodyAllowed is false for the statuses that never carry a body.
+func bodyAllowed(statusCode int) bool {
+	return statusCode >= 200 && statusCode != 204 && statusCode != 304
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if w.noBody {
+		return len(p), nil
+	}
+	if w.head != nil && !w.chunked && w.writer.Buffered() == 0 && len(w.head)+len(p) > w.writer.Available() {
+		//Headers and body in one syscall rather than buffer-full + rest
+		bufs := net.Buffers{w.head, p}
+		sent, err := bufs.WriteTo(w.conn)
+		n := int(max(sent-int64(len(w.head)), 0))
+		w.head = nil
+		w.written += int64(n)
+		return n, err
+	}
+	w.flushHead()
+	if w.chunked {
+		if len(p) == 0 {
+			return 0, nil //an empty chunk would end the body
+		}
+		fmt.Fprintf(w.writer, "%x\r\n", len(p))
+	}
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	if w.chunked && err == nil {
+		_, err = w.writer.WriteString("\r\n")
+	}
+	return n, err
+}
+
+func (w *responseWriter) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	w.flushHead()
+	return w.writer.Flush()
+}
+
+// finish ends the response once the handler is done: the last chunk of
+// a chunked body, then everything still buffered goes out.
+func (w *responseWriter) finish() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	w.flushHead()
+	//A body cut short (a file failing to read halfway) leaves the client
+	//waiting for the rest; closing tells it there is none
+	if size, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil && !w.noBody && bodyAllowed(w.status) && w.written < size {
+		w.keepAlive = false
+	}
+	if w.chunked {
+		w.chunked = false
+		w.writer.WriteString("0\r\n\r\n")
+	}
+	return w.writer.Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	//Anything already buffered belongs to the client, send it first
+	w.flushHead()
+	if err := w.writer.Flush(); err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.conn.SetReadDeadline(time.Time{}) //the new owner sets its own
+	return w.conn, bufio.NewReadWriter(w.reader, bufio.NewWriter(w.conn)), nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  writeBody(w, statusCode, contentType, body)
+//    - Small helper for fixed responses (error pages, redirects...)
+//      that are entirely in memory. Extra headers go on w.Header()
+//      before calling this.
+// ─────────────────────────────────────────────────────────────────
+
+func writeBody(w ResponseWriter, statusCode int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
@@ -0,0 +1,272 @@
+// routelimit.go
+
+package main
+
+import (
+	"expvar"  //occupancy in /debug/vars
+	"fmt"     //config errors
+	"slices"  //waiter lists
+	"sort"    //stable order
+	"strconv" //config numbers
+	"strings" //prefix matching
+	"sync"    //per-route gates
+	"time"    //queue timeout
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Per-route concurrency limits
+//    - RouteLimits caps how many requests under a path prefix are
+//      worked on at once, so a handful of slow archive downloads or
+//      exec routes can't take every worker from cheap static files.
+//    - A request over the cap waits in the route's queue (at most
+//      MaxQueue of them, for at most QueueTimeout) for a slot to free
+//      up; without a queue, or when it is full or the wait runs out,
+//      the client gets a 503 with Retry-After.
+//    - The queue is fair between clients: a freed slot goes to the
+//      next client IP in turn, not to whoever has the most requests
+//      waiting, so one client with many parallel connections can't
+//      push everybody else to the back. MaxQueuePerIP also caps how
+//      much of the queue one IP may fill.
+//    - The longest matching prefix applies; paths under no prefix are
+//      not limited.
+// ─────────────────────────────────────────────────────────────────
+
+type routeLimit struct {
+	Prefix        string        //e.g. "/downloads/"
+	MaxConcurrent int           //requests worked on at once
+	MaxQueue      int           //requests waiting for a slot, 0 = none
+	MaxQueuePerIP int           //of those from one client IP, 0 = no cap
+	QueueTimeout  time.Duration //longest wait for a slot, 0 = DefaultQueueTimeout
+}
+
+// RouteLimits, e.g.
+// {Prefix: "/downloads/", MaxConcurrent: 2, MaxQueue: 20, QueueTimeout: 10 * time.Second}.
+var RouteLimits = []routeLimit{}
+
+var DefaultQueueTimeout = 5 * time.Second
+
+type routeGate struct {
+	limit routeLimit
+
+	mu       sync.Mutex
+	inFlight int
+	queued   int
+	waiting  map[string][]chan struct{} //by client IP, oldest first
+	turns    []string                   //IPs with waiters, next in line first
+}
+
+var (
+	routeGatesMu sync.Mutex
+	routeGates   = make(map[string]*routeGate) //by prefix
+)
+
+func init() {
+	expvar.Publish("helix_route_limits", expvar.Func(func() any { return routeLimitsSnapshot() }))
+}
+
+// routeLimitFor returns the limit with the longest prefix of path.
+func routeLimitFor(path string) (routeLimit, bool) {
+	var best routeLimit
+	found := false
+	for _, l := range RouteLimits {
+		if l.MaxConcurrent > 0 && strings.HasPrefix(path, l.Prefix) && (!found || len(l.Prefix) > len(best.Prefix)) {
+			best, found = l, true
+		}
+	}
+	return best, found
+}
+
+func routeGateFor(limit routeLimit) *routeGate {
+	routeGatesMu.Lock()
+	defer routeGatesMu.Unlock()
+	g, ok := routeGates[limit.Prefix]
+	if !ok {
+		g = &routeGate{limit: limit, waiting: make(map[string][]chan struct{})}
+		routeGates[limit.Prefix] = g
+	}
+	return g
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  acquireRouteSlot(req) (release func(), ok bool)
+//    - ok is false if the request has to be turned away; otherwise
+//      release must be called once the request is done.
+// ─────────────────────────────────────────────────────────────────
+
+func acquireRouteSlot(req *request) (func(), bool) {
+	limit, ok := routeLimitFor(req.cleanPath())
+	if !ok {
+		return func() {}, true
+	}
+	g := routeGateFor(limit)
+	ip := clientIP(req.clientAddr)
+
+	g.mu.Lock()
+	if g.inFlight < limit.MaxConcurrent && g.queued == 0 {
+		g.inFlight++
+		g.mu.Unlock()
+		return g.release, true
+	}
+
+	//Full: wait in the queue if there's room in it, for us and for this IP
+	reason := ""
+	switch {
+	case g.queued >= limit.MaxQueue:
+		reason = "full"
+	case limit.MaxQueuePerIP > 0 && len(g.waiting[ip]) >= limit.MaxQueuePerIP:
+		reason = "per_ip"
+	}
+	if reason != "" {
+		g.mu.Unlock()
+		incCounter("route_limited", []string{"prefix:" + limit.Prefix, "reason:" + reason}, 1)
+		return nil, false
+	}
+	turn := make(chan struct{})
+	if len(g.waiting[ip]) == 0 {
+		g.turns = append(g.turns, ip)
+	}
+	g.waiting[ip] = append(g.waiting[ip], turn)
+	g.queued++
+	g.mu.Unlock()
+
+	timeout := limit.QueueTimeout
+	if timeout <= 0 {
+		timeout = DefaultQueueTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	start := time.Now()
+	select {
+	case <-turn:
+		observeTiming("route_queue_wait", []string{"prefix:" + limit.Prefix}, time.Since(start))
+		return g.release, true
+	case <-timer.C:
+	}
+
+	g.mu.Lock()
+	if !g.dequeue(ip, turn) {
+		//Handed a slot just as we gave up: take it after all
+		g.mu.Unlock()
+		return g.release, true
+	}
+	g.mu.Unlock()
+	incCounter("route_limited", []string{"prefix:" + limit.Prefix, "reason:timeout"}, 1)
+	return nil, false
+}
+
+// release frees a slot, or hands it straight to the next client in
+// turn.
+func (g *routeGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.turns) == 0 {
+		g.inFlight--
+		return
+	}
+	ip := g.turns[0]
+	turn := g.waiting[ip][0]
+	g.dequeue(ip, turn)
+	//This IP goes to the back of the line if it has more waiting
+	if len(g.waiting[ip]) > 0 {
+		g.turns = append(g.turns[1:], ip)
+	}
+	close(turn)
+}
+
+// dequeue removes a waiter, reporting false if it was already handed a
+// slot. The IP keeps its place in turns while it has others waiting.
+// Callers hold g.mu.
+func (g *routeGate) dequeue(ip string, turn chan struct{}) bool {
+	waiters := g.waiting[ip]
+	i := slices.Index(waiters, turn)
+	if i < 0 {
+		return false
+	}
+	g.queued--
+	if len(waiters) > 1 {
+		g.waiting[ip] = slices.Delete(waiters, i, i+1)
+		return true
+	}
+	delete(g.waiting, ip)
+	if j := slices.Index(g.turns, ip); j >= 0 {
+		g.turns = slices.Delete(g.turns, j, j+1)
+	}
+	return true
+}
+
+// routeLimitsSnapshot is the current occupancy, for /debug/vars.
+func routeLimitsSnapshot() map[string]map[string]int {
+	routeGatesMu.Lock()
+	defer routeGatesMu.Unlock()
+	out := make(map[string]map[string]int, len(routeGates))
+	for prefix, g := range routeGates {
+		g.mu.Lock()
+		out[prefix] = map[string]int{
+			"in_flight":  g.inFlight,
+			"queued":     g.queued,
+			"queued_ips": len(g.waiting),
+			"max":        g.limit.MaxConcurrent,
+		}
+		g.mu.Unlock()
+	}
+	return out
+}
+
+// setRouteLimits reads route_limits from the config file:
+//
+//	route_limits:
+//	  /downloads/:
+//	    max_concurrent: 2
+//	    max_queue: 20
+//	    max_queue_per_ip: 5
+//	    queue_timeout: 10s
+func setRouteLimits(v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a mapping of path prefix to limits")
+	}
+	limits := make([]routeLimit, 0, len(m))
+	for prefix, item := range m {
+		fields, ok := item.(map[string]any)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("%s: expected a path prefix with max_concurrent, max_queue, max_queue_per_ip, queue_timeout", prefix)
+		}
+		l := routeLimit{Prefix: prefix}
+		for name, fv := range fields {
+			s, err := configString(fv)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", prefix, name, err)
+			}
+			switch name {
+			case "max_concurrent", "max_queue", "max_queue_per_ip":
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					return fmt.Errorf("%s.%s: expected a number, got %q", prefix, name, s)
+				}
+				switch name {
+				case "max_concurrent":
+					l.MaxConcurrent = n
+				case "max_queue":
+					l.MaxQueue = n
+				default:
+					l.MaxQueuePerIP = n
+				}
+			case "queue_timeout":
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return fmt.Errorf("%s.%s: expected a duration like 5s, got %q", prefix, name, s)
+				}
+				l.QueueTimeout = d
+			default:
+				return fmt.Errorf("%s: unknown setting %q", prefix, name)
+			}
+		}
+		if l.MaxConcurrent <= 0 {
+			return fmt.Errorf("%s: max_concurrent must be at least 1", prefix)
+		}
+		limits = append(limits, l)
+	}
+	sort.Slice(limits, func(i, j int) bool { return limits[i].Prefix < limits[j].Prefix })
+	RouteLimits = limits
+	return nil
+}
@@ -0,0 +1,126 @@
+// routelimit_test.go
+
+package main
+
+import (
+	"testing" //test runner
+	"time"    //queue timeouts
+)
+
+// withRouteLimit installs limit as the only route limit, with fresh
+// gates, for the duration of the test.
+func withRouteLimit(t *testing.T, limit routeLimit) {
+	prevLimits, prevGates := RouteLimits, routeGates
+	RouteLimits, routeGates = []routeLimit{limit}, make(map[string]*routeGate)
+	t.Cleanup(func() { RouteLimits, routeGates = prevLimits, prevGates })
+}
+
+func limitedRequest(ip string) *request {
+	return &request{rawPath: "/q/file", clientAddr: ip + ":40000"}
+}
+
+// acquireAsync runs acquireRouteSlot in the background.
+func acquireAsync(req *request) <-chan func() {
+	done := make(chan func(), 1)
+	go func() {
+		release, ok := acquireRouteSlot(req)
+		if !ok {
+			release = nil
+		}
+		done <- release
+	}()
+	return done
+}
+
+func TestRouteQueueTimeoutKeepsOtherWaitersOfTheIP(t *testing.T) {
+	withRouteLimit(t, routeLimit{Prefix: "/q/", MaxConcurrent: 1, MaxQueue: 5, QueueTimeout: 300 * time.Millisecond})
+
+	holder, ok := acquireRouteSlot(limitedRequest("10.0.0.9"))
+	if !ok {
+		t.Fatal("first request refused")
+	}
+	first := acquireAsync(limitedRequest("10.0.0.1"))
+	time.Sleep(150 * time.Millisecond)
+	second := acquireAsync(limitedRequest("10.0.0.1"))
+
+	//The first waiter gives up, the second is still in line
+	if release := <-first; release != nil {
+		t.Fatal("first waiter got a slot nobody released")
+	}
+	g := routeGates["/q/"]
+	g.mu.Lock()
+	turns, queued := len(g.turns), g.queued
+	g.mu.Unlock()
+	if turns != 1 || queued != 1 {
+		t.Fatalf("after the timeout: %d IPs in turn, %d queued; want 1, 1", turns, queued)
+	}
+
+	holder()
+	select {
+	case release := <-second:
+		if release == nil {
+			t.Fatal("second waiter refused although the slot was released")
+		}
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("second waiter never got the released slot")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.inFlight != 0 || g.queued != 0 || len(g.turns) != 0 || len(g.waiting) != 0 {
+		t.Fatalf("gate not empty: in flight %d, queued %d, turns %v, waiting %d", g.inFlight, g.queued, g.turns, len(g.waiting))
+	}
+}
+
+func TestRouteQueueTakesTurnsBetweenIPs(t *testing.T) {
+	withRouteLimit(t, routeLimit{Prefix: "/q/", MaxConcurrent: 1, MaxQueue: 5, QueueTimeout: 5 * time.Second})
+
+	holder, _ := acquireRouteSlot(limitedRequest("10.0.0.9"))
+	var order []string
+	results := make(chan string, 3)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		done := acquireAsync(limitedRequest(ip))
+		go func(ip string) {
+			release := <-done
+			results <- ip
+			time.Sleep(10 * time.Millisecond)
+			release()
+		}(ip)
+		time.Sleep(20 * time.Millisecond) //queue in this order
+	}
+	holder()
+	for range 3 {
+		order = append(order, <-results)
+	}
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("slots went to %v, want %v", order, want)
+		}
+	}
+}
+
+func TestRouteQueueLimits(t *testing.T) {
+	withRouteLimit(t, routeLimit{Prefix: "/q/", MaxConcurrent: 1, MaxQueue: 2, MaxQueuePerIP: 1, QueueTimeout: 200 * time.Millisecond})
+
+	holder, _ := acquireRouteSlot(limitedRequest("10.0.0.9"))
+	defer holder()
+	waiting := acquireAsync(limitedRequest("10.0.0.1"))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := acquireRouteSlot(limitedRequest("10.0.0.1")); ok {
+		t.Error("second request from one IP queued past max_queue_per_ip")
+	}
+	other := acquireAsync(limitedRequest("10.0.0.2"))
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := acquireRouteSlot(limitedRequest("10.0.0.3")); ok {
+		t.Error("request queued past max_queue")
+	}
+	<-waiting
+	<-other
+	if release, ok := acquireRouteSlot(&request{rawPath: "/elsewhere", clientAddr: "10.0.0.1:1"}); !ok {
+		t.Error("unlimited path refused")
+	} else {
+		release()
+	}
+}
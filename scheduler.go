@@ -0,0 +1,342 @@
+// scheduler.go
+
+package main
+
+import (
+	"encoding/json" //admin API
+	"fmt"           //panics → errors
+	"net/http"      //admin API
+	"sort"          //stable job listing
+	"strconv"       //config values
+	"sync"          //guarding job state
+	"time"          //intervals
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Scheduled jobs
+//    - Housekeeping that used to be done "once in a while" on the hot
+//      path runs here instead, each job on its own interval.
+//    - Jobs register themselves from an init() next to the code they
+//      clean up, with a default interval. The config can change that
+//      interval and switch jobs off, by name (see /jobs for the names):
+//          jobs:
+//            compress-logs:
+//              every: 6h
+//            docroot-sync:
+//              enabled: false
+//      A reload applies it to the running jobs: a changed interval
+//      starts counting from the reload, a job switched back on from
+//      then too.
+//    - The last jobHistorySize runs of every job are kept for /jobs.
+//    - Singleton jobs only run on the leader (see leader.go).
+// ─────────────────────────────────────────────────────────────────
+
+// jobSetting is a job's entry under "jobs" in the config.
+type jobSetting struct {
+	Every   time.Duration //0 = the job's default
+	Enabled bool
+}
+
+// JobSchedule holds the config's settings by job name, e.g.
+//
+//	"compress-logs": {Every: 6 * time.Hour, Enabled: true}
+var JobSchedule = map[string]jobSetting{}
+
+const jobHistorySize = 20
+
+type jobRun struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type job struct {
+	name         string
+	defaultEvery time.Duration
+	run          func() error
+
+	singleton bool          //only runs on the leader
+	wake      chan struct{} //the schedule changed, see applyJobSchedule
+
+	mu      sync.Mutex
+	every   time.Duration
+	enabled bool
+	running bool
+	next    time.Time
+	history []jobRun //oldest first
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*job)
+)
+
+// registerJob adds a job; meant to be called from init().
+func registerJob(name string, every time.Duration, run func() error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobs[name] = newJob(name, every, run)
+}
+
+func newJob(name string, every time.Duration, run func() error) *job {
+	return &job{name: name, defaultEvery: every, run: run, wake: make(chan struct{}, 1), every: every, enabled: true}
+}
+
+// registerSingletonJob adds a job that should run on one instance of
+// the fleet only.
+func registerSingletonJob(name string, every time.Duration, run func() error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j := newJob(name, every, run)
+	j.singleton = true
+	jobs[name] = j
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  startScheduler()
+//    - One goroutine per job, waiting out its interval and running it;
+//      a disabled job's goroutine just waits for a reload to switch it
+//      back on. Runs don't overlap: the next interval starts when a
+//      run is over.
+// ─────────────────────────────────────────────────────────────────
+
+func startScheduler() {
+	applyJobSchedule()
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		go j.loop()
+	}
+}
+
+func (j *job) loop() {
+	for {
+		j.mu.Lock()
+		every, enabled := j.every, j.enabled && j.every > 0
+		j.next = time.Time{}
+		if enabled {
+			j.next = time.Now().Add(every)
+		}
+		j.mu.Unlock()
+
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if enabled {
+			timer = time.NewTimer(every)
+			tick = timer.C
+		}
+		select {
+		case <-tick:
+			if j.singleton && !isLeader() {
+				logDebugf("jobs", "job %s skipped, not the leader", j.name)
+				continue
+			}
+			runJob(j)
+		case <-j.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// applyJobSchedule gives every job its interval and on/off switch from
+// JobSchedule, waking those that changed. Called at startup and after
+// a reload.
+func applyJobSchedule() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for name, j := range jobs {
+		every, enabled := j.defaultEvery, true
+		if s, ok := JobSchedule[name]; ok {
+			enabled = s.Enabled
+			if s.Every > 0 {
+				every = s.Every
+			}
+		}
+		j.mu.Lock()
+		changed := j.every != every || j.enabled != enabled
+		j.every, j.enabled = every, enabled
+		j.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if enabled {
+			logInfof("jobs", "Job %s runs every %s", name, every)
+		} else {
+			logInfof("jobs", "Job %s disabled", name)
+		}
+		select {
+		case j.wake <- struct{}{}:
+		default: //already woken
+		}
+	}
+}
+
+// setJobSchedule reads "jobs": a mapping of job name to every and
+// enabled.
+func setJobSchedule(v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a mapping of job name to every, enabled")
+	}
+	schedule := make(map[string]jobSetting, len(m))
+	for name, item := range m {
+		jobsMu.Lock()
+		_, known := jobs[name]
+		jobsMu.Unlock()
+		fields, ok := item.(map[string]any)
+		if !known || !ok {
+			return fmt.Errorf("%s: expected a job name (see /jobs) with every, enabled", name)
+		}
+		s := jobSetting{Enabled: true}
+		for field, fv := range fields {
+			value, err := configString(fv)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", name, field, err)
+			}
+			switch field {
+			case "every":
+				d, err := time.ParseDuration(value)
+				if err != nil || d <= 0 {
+					return fmt.Errorf("%s.every: expected a duration like 1h, got %q", name, value)
+				}
+				s.Every = d
+			case "enabled":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("%s.enabled: expected true or false, got %q", name, value)
+				}
+				s.Enabled = b
+			default:
+				return fmt.Errorf("%s: unknown setting %q", name, field)
+			}
+		}
+		schedule[name] = s
+	}
+	JobSchedule = schedule
+	return nil
+}
+
+// jobsForConfig is every job's effective schedule, for /config.
+func jobsForConfig() any {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	out := make(map[string]any, len(jobs))
+	for name, j := range jobs {
+		j.mu.Lock()
+		out[name] = map[string]any{"every": j.every.String(), "enabled": j.enabled}
+		j.mu.Unlock()
+	}
+	return out
+}
+
+// runJob runs j once and records the result. Returns false if it was
+// already running.
+func runJob(j *job) bool {
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		logWarnf("jobs", "Job %s still running, skipping this run", j.name)
+		return false
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	started := time.Now()
+	err := runJobSafely(j)
+	r := jobRun{Started: started.UTC(), Duration: time.Since(started)}
+	if err != nil {
+		r.Error = err.Error()
+		logErrorf("jobs", "Job %s failed: %v", j.name, err)
+	} else {
+		logDebugf("jobs", "job %s done in %s", j.name, r.Duration)
+	}
+	incCounter("jobs_run", []string{"job:" + j.name, fmt.Sprintf("ok:%v", err == nil)}, 1)
+
+	j.mu.Lock()
+	j.running = false
+	j.history = append(j.history, r)
+	if len(j.history) > jobHistorySize {
+		j.history = j.history[len(j.history)-jobHistorySize:]
+	}
+	j.mu.Unlock()
+	return true
+}
+
+// runJobSafely keeps a panicking job from taking the server down.
+func runJobSafely(j *job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return j.run()
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Admin API: /jobs
+//    GET              → every job with its interval, next run and
+//                       recent history (newest first)
+//    POST run=<name>  → run a job now (409 if it is already running)
+// ─────────────────────────────────────────────────────────────────
+
+func init() {
+	adminMux.HandleFunc("/jobs", handleJobsAdmin)
+}
+
+func handleJobsAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jobsMu.Lock()
+		j, ok := jobs[r.Form.Get("run")]
+		jobsMu.Unlock()
+		if !ok {
+			http.Error(w, "no such job", http.StatusNotFound)
+			return
+		}
+		logInfof("jobs", "Job %s started through the admin API", j.name)
+		if !runJob(j) {
+			http.Error(w, "job is already running", http.StatusConflict)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type jobJSON struct {
+		Name      string   `json:"name"`
+		Every     string   `json:"every"`
+		Enabled   bool     `json:"enabled"`
+		Running   bool     `json:"running"`
+		Singleton bool     `json:"singleton,omitempty"`
+		Next      string   `json:"next,omitempty"`
+		History   []jobRun `json:"history"`
+	}
+	jobsMu.Lock()
+	out := make([]jobJSON, 0, len(jobs))
+	for _, j := range jobs {
+		j.mu.Lock()
+		jj := jobJSON{Name: j.name, Every: j.every.String(), Enabled: j.enabled, Running: j.running, Singleton: j.singleton, History: make([]jobRun, 0, len(j.history))}
+		if !j.next.IsZero() {
+			jj.Next = j.next.UTC().Format(time.RFC3339)
+		}
+		for i := len(j.history) - 1; i >= 0; i-- {
+			jj.History = append(jj.History, j.history[i])
+		}
+		j.mu.Unlock()
+		out = append(out, jj)
+	}
+	jobsMu.Unlock()
+	sort.Slice(out, func(i, k int) bool { return out[i].Name < out[k].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
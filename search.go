@@ -0,0 +1,404 @@
+// search.go
+
+package main
+
+import (
+	"bytes"         //rendering results
+	"encoding/gob"  //the on-disk index
+	"encoding/json" //JSON results
+	"html"          //unescaping page text
+	"html/template" //results page
+	"io/fs"         //walking the docroot
+	"os"            //index file
+	"path/filepath" //docroot paths
+	"sort"          //ranking
+	"strconv"       //?limit=
+	"strings"       //tokenizing
+	"sync"          //guarding the index
+	"time"          //modification times
+	"unicode"       //word boundaries
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Search (off unless SearchEnabled)
+//    - GET SearchPath?q=words finds the pages under the default
+//      document root that contain all the words, best matches first
+//      (title and file name hits count extra). JSON for
+//      ?format=json or "Accept: application/json", an HTML page
+//      otherwise.
+//    - The index covers .html/.htm/.md/.txt files up to
+//      SearchMaxFileSize, is kept in memory and saved to
+//      SearchIndexFile, so a restart starts from the saved one instead
+//      of reading every page again.
+//    - The search-index job rescans the root (by modification time and
+//      size) every SearchRescanInterval and only reads changed files.
+//      There is no inotify in the standard library, so this polls
+//      instead of watching; for a documentation tree that's cheap.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	SearchEnabled     = false
+	SearchPath        = "/search"
+	SearchIndexFile   = "" //"" = <log-dir>/search-index.gob
+	SearchMaxFileSize = int64(1 << 20)
+	SearchMaxResults  = 20
+)
+
+const searchRescanInterval = 30 * time.Second
+
+var searchExtensions = map[string]bool{".html": true, ".htm": true, ".md": true, ".txt": true}
+
+type searchDoc struct {
+	Path    string //URL path, e.g. "/guide/install.html"
+	Title   string
+	ModTime time.Time
+	Size    int64
+	Terms   map[string]int //word → count
+}
+
+type searchIndex struct {
+	Root string
+	Docs map[string]*searchDoc //by URL path
+}
+
+var (
+	searchMu       sync.RWMutex
+	searchDocs     = map[string]*searchDoc{}
+	searchPostings = map[string][]string{} //word → URL paths, rebuilt after changes
+	searchRoot     string
+	searchUpdateMu sync.Mutex //one update at a time (startup vs. the job)
+)
+
+func init() {
+	registerJob("search-index", searchRescanInterval, updateSearchIndex)
+}
+
+// startSearch loads the saved index and brings it up to date in the
+// background, so a big tree doesn't delay startup.
+func startSearch() {
+	if !SearchEnabled {
+		return
+	}
+	if idx, err := loadSearchIndex(); err == nil && idx.Root == siteNow().root {
+		searchMu.Lock()
+		searchDocs, searchRoot = idx.Docs, idx.Root
+		searchPostings = buildPostings(searchDocs)
+		searchMu.Unlock()
+		logInfof("search", "Loaded search index: %d documents", len(idx.Docs))
+	}
+	go func() {
+		if err := updateSearchIndex(); err != nil {
+			logErrorf("search", "Building the search index failed: %v", err)
+		}
+	}()
+}
+
+func searchIndexPath() string {
+	if SearchIndexFile != "" {
+		return SearchIndexFile
+	}
+	return filepath.Join(LogDir, "search-index.gob")
+}
+
+func loadSearchIndex() (*searchIndex, error) {
+	f, err := os.Open(searchIndexPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var idx searchIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// saveSearchIndex writes the index next to its final name and renames
+// it, so a crash never leaves half an index behind.
+func saveSearchIndex(idx *searchIndex) error {
+	path := searchIndexPath()
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(idx); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  updateSearchIndex() error
+//    - Walks the root, reads new and changed files, forgets removed
+//      ones, and swaps + saves the index if anything changed.
+// ─────────────────────────────────────────────────────────────────
+
+func updateSearchIndex() error {
+	if !SearchEnabled {
+		return nil
+	}
+	searchUpdateMu.Lock()
+	defer searchUpdateMu.Unlock()
+	root := siteNow().root
+	searchMu.RLock()
+	old := searchDocs
+	if searchRoot != root {
+		old = map[string]*searchDoc{}
+	}
+	searchMu.RUnlock()
+
+	docs := make(map[string]*searchDoc, len(old))
+	changed := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil //unreadable parts are just not searchable
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !searchExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > SearchMaxFileSize {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		urlPath := "/" + filepath.ToSlash(rel)
+		if gated(path) {
+			return nil //behind sidecar auth, see sidecar.go
+		}
+		if doc, ok := old[urlPath]; ok && doc.Size == info.Size() && doc.ModTime.Equal(info.ModTime()) {
+			docs[urlPath] = doc
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		docs[urlPath] = indexDocument(urlPath, info, data)
+		changed++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for p := range old {
+		if docs[p] == nil {
+			removed++
+		}
+	}
+	if changed == 0 && removed == 0 && searchRoot == root {
+		return nil
+	}
+
+	postings := buildPostings(docs)
+	searchMu.Lock()
+	searchDocs, searchPostings, searchRoot = docs, postings, root
+	searchMu.Unlock()
+	logInfof("search", "Search index updated: %d documents (%d changed, %d removed)", len(docs), changed, removed)
+	return saveSearchIndex(&searchIndex{Root: root, Docs: docs})
+}
+
+func buildPostings(docs map[string]*searchDoc) map[string][]string {
+	postings := make(map[string][]string)
+	for p, doc := range docs {
+		for term := range doc.Terms {
+			postings[term] = append(postings[term], p)
+		}
+	}
+	return postings
+}
+
+// indexDocument extracts the title and words of one file.
+func indexDocument(urlPath string, info os.FileInfo, data []byte) *searchDoc {
+	text := string(data)
+	title := ""
+	ext := strings.ToLower(filepath.Ext(urlPath))
+	if ext == ".html" || ext == ".htm" {
+		title = htmlTitle(text)
+		text = htmlText(text)
+	} else if ext == ".md" {
+		for _, line := range strings.Split(text, "\n") {
+			if strings.HasPrefix(line, "# ") {
+				title = strings.TrimSpace(line[2:])
+				break
+			}
+		}
+	}
+	if title == "" {
+		title = filepath.Base(urlPath)
+	}
+	terms := make(map[string]int)
+	for _, word := range searchWords(text) {
+		terms[word]++
+	}
+	return &searchDoc{Path: urlPath, Title: title, ModTime: info.ModTime(), Size: info.Size(), Terms: terms}
+}
+
+func htmlTitle(page string) string {
+	lower := strings.ToLower(page)
+	start := strings.Index(lower, "<title>")
+	end := strings.Index(lower, "</title>")
+	if start < 0 || end < start {
+		return ""
+	}
+	return strings.TrimSpace(html.UnescapeString(page[start+len("<title>") : end]))
+}
+
+// htmlText drops tags, scripts and styles and unescapes entities.
+func htmlText(page string) string {
+	var b strings.Builder
+	lower := strings.ToLower(page)
+	for i := 0; i < len(page); {
+		if page[i] != '<' {
+			next := strings.IndexByte(page[i:], '<')
+			if next < 0 {
+				next = len(page) - i
+			}
+			b.WriteString(page[i : i+next])
+			b.WriteByte(' ')
+			i += next
+			continue
+		}
+		for _, skip := range []string{"script", "style"} {
+			if strings.HasPrefix(lower[i+1:], skip) {
+				if end := strings.Index(lower[i:], "</"+skip); end >= 0 {
+					i += end
+				}
+			}
+		}
+		end := strings.IndexByte(page[i:], '>')
+		if end < 0 {
+			break
+		}
+		i += end + 1
+	}
+	return html.UnescapeString(b.String())
+}
+
+// searchWords splits text into lowercase words of 2+ letters/digits.
+func searchWords(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) >= 2 && len(word) <= 64 {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveSearch(w, req) error
+// ─────────────────────────────────────────────────────────────────
+
+type searchResult struct {
+	Path  string  `json:"path"`
+	Title string  `json:"title"`
+	Score float64 `json:"score"`
+}
+
+// isSearchPath takes a request's cleanPath().
+func isSearchPath(path string) bool {
+	return SearchEnabled && path == SearchPath
+}
+
+func serveSearch(w ResponseWriter, req *request) error {
+	if !readMethod(req.method) {
+		return onlyMethods("GET", "HEAD")
+	}
+	query := req.query()
+	q := query.Get("q")
+	limit := SearchMaxResults
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil && n > 0 && n < limit {
+		limit = n
+	}
+	results := runSearch(q, limit)
+	incCounter("searches", nil, 1)
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if query.Get("format") == "json" || negotiateErrorFormat(req.header.Get("Accept")) == formatJSON {
+		body, _ := json.Marshal(map[string]any{"query": q, "results": results})
+		writeBody(w, 200, "application/json", body)
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := searchTemplate.Execute(&buf, map[string]any{"Query": q, "Results": results, "Path": SearchPath}); err != nil {
+		return err
+	}
+	writeBody(w, 200, "text/html; charset=utf-8", buf.Bytes())
+	return nil
+}
+
+// runSearch returns documents containing every word of q, ranked.
+func runSearch(q string, limit int) []searchResult {
+	words := searchWords(q)
+	if len(words) == 0 {
+		return []searchResult{}
+	}
+	searchMu.RLock()
+	defer searchMu.RUnlock()
+
+	//Start from the rarest word's documents, keep those with all words
+	sort.Slice(words, func(i, j int) bool { return len(searchPostings[words[i]]) < len(searchPostings[words[j]]) })
+	results := []searchResult{}
+	for _, p := range searchPostings[words[0]] {
+		doc := searchDocs[p]
+		if gated(filepath.Join(searchRoot, filepath.FromSlash(doc.Path))) {
+			continue //got auth since it was indexed
+		}
+		score := 0.0
+		for _, word := range words {
+			n := doc.Terms[word]
+			if n == 0 {
+				score = -1
+				break
+			}
+			score += 1 + float64(min(n, 20))/4
+			if strings.Contains(strings.ToLower(doc.Title), word) {
+				score += 5
+			}
+			if strings.Contains(strings.ToLower(doc.Path), word) {
+				score += 3
+			}
+		}
+		if score > 0 {
+			results = append(results, searchResult{Path: doc.Path, Title: doc.Title, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Search{{if .Query}}: {{.Query}}{{end}}</title>
+<style>body{font-family:system-ui,sans-serif;margin:2em;max-width:50em}li{margin:.5em 0}small{color:#666}</style></head>
+<body><form action="{{.Path}}"><input name="q" value="{{.Query}}" autofocus> <button>Search</button></form>
+{{if .Query}}{{if .Results}}<ol>{{range .Results}}<li><a href="{{.Path}}">{{.Title}}</a><br><small>{{.Path}}</small></li>
+{{end}}</ol>{{else}}<p>Nothing found for “{{.Query}}”.</p>{{end}}{{end}}
+</body></html>
+`))
@@ -0,0 +1,188 @@
+// secrets.go
+
+package main
+
+import (
+	"encoding/json" //Vault responses
+	"errors"        //collecting failures
+	"fmt"           //errors
+	"os"            //files + environment
+	"strings"       //reference parsing
+	"sync"          //the resolved cache
+	"time"          //refresh interval
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Secrets
+//    - Settings holding secrets (HCaptchaSecret, SyncToken,
+//      ClusterToken, WatermarkSecret) can be given literally or as a reference:
+//          file:/run/secrets/sync-token     contents, trailing newline cut
+//          env:HELIX_SYNC_TOKEN             an environment variable
+//          vault:secret/data/helix#sync     field of a Vault KV secret
+//                                           (VAULT_ADDR + VAULT_TOKEN)
+//          enc:Xq3…                         encrypted in the file itself
+//                                           (see configcrypt.go)
+//    - References are resolved at startup (a failure stops us) and
+//      again by the refresh-secrets job, so rotating a secret in its
+//      source takes effect without a restart. If a refresh fails we
+//      keep the last good value.
+//    - Code reads secrets through secret(setting), never the setting.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	secretsMu sync.RWMutex
+	secrets   = make(map[string]string) //reference → resolved value
+)
+
+// secretSettings are the settings that may hold references.
+func secretSettings() []string {
+	return []string{HCaptchaSecret, SyncToken, ClusterToken, WatermarkSecret}
+}
+
+func init() {
+	registerJob("refresh-secrets", 5*time.Minute, refreshSecrets)
+}
+
+func isSecretRef(s string) bool {
+	for _, scheme := range []string{"file:", "env:", "vault:", "enc:"} {
+		if strings.HasPrefix(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// secret returns the value of a secret setting: the setting itself if
+// it is a literal, otherwise what its reference resolved to.
+func secret(setting string) string {
+	if !isSecretRef(setting) {
+		return setting
+	}
+	secretsMu.RLock()
+	value, ok := secrets[setting]
+	secretsMu.RUnlock()
+	if ok {
+		return value
+	}
+	//Not resolved at startup (set later?), do it now
+	value, err := resolveSecret(setting)
+	if err != nil {
+		logErrorf("secrets", "Could not resolve %s: %v", setting, err)
+		return ""
+	}
+	secretsMu.Lock()
+	secrets[setting] = value
+	secretsMu.Unlock()
+	return value
+}
+
+// loadSecrets resolves every reference, called once at startup.
+func loadSecrets() error {
+	for _, ref := range secretSettings() {
+		if !isSecretRef(ref) {
+			continue
+		}
+		value, err := resolveSecret(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		secretsMu.Lock()
+		secrets[ref] = value
+		secretsMu.Unlock()
+	}
+	return nil
+}
+
+// refreshSecrets resolves all references again (the refresh-secrets job).
+func refreshSecrets() error {
+	var errs []error
+	for _, ref := range secretSettings() {
+		if !isSecretRef(ref) {
+			continue
+		}
+		value, err := resolveSecret(ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ref, err))
+			continue
+		}
+		secretsMu.Lock()
+		if old, ok := secrets[ref]; ok && old != value {
+			logInfof("secrets", "Secret %s rotated", ref)
+		}
+		secrets[ref] = value
+		secretsMu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func resolveSecret(ref string) (string, error) {
+	scheme, rest, _ := strings.Cut(ref, ":")
+	switch scheme {
+	case "file":
+		data, err := os.ReadFile(rest)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "env":
+		value, ok := os.LookupEnv(rest)
+		if !ok {
+			return "", errors.New("not set")
+		}
+		return value, nil
+	case "vault":
+		return vaultSecret(rest)
+	case "enc":
+		return decryptConfigValue(rest)
+	}
+	return "", fmt.Errorf("unknown secret scheme %q", scheme)
+}
+
+// vaultSecret reads "<path>#<field>" from Vault's HTTP API. Works with
+// KV v2 (data nested under data.data) and KV v1 (directly under data).
+func vaultSecret(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", errors.New("vault reference must look like path#field")
+	}
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	resp, err := outboundDo(outboundRequest{
+		target:  "vault",
+		method:  "GET",
+		url:     strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/"),
+		headers: map[string]string{"X-Vault-Token": token},
+		timeout: 5 * time.Second,
+		retries: 2,
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.status != 200 {
+		return "", fmt.Errorf("vault answered %d", resp.status)
+	}
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(resp.body, &body); err != nil {
+		return "", err
+	}
+	fields := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		var v2 map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &v2); err == nil {
+			fields = v2
+		}
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("no field %q", field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("field %q is not a string", field)
+	}
+	return value, nil
+}
@@ -0,0 +1,215 @@
+// selfcheck.go
+
+package main
+
+import (
+	"fmt"     //report lines
+	"io"      //empty docroot
+	"net"     //bind + dial checks
+	"net/url" //upstream host:port
+	"os"      //docroot checks
+	"path"    //error page paths
+	"strings" //joining failures
+	"sync"    //dialing upstreams in parallel
+	"time"    //clock + dial timeout
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Startup self-check
+//    - Runs once before we start listening and logs one line per
+//      check: docroot readable, index present, error pages found,
+//      ports bindable, upstreams reachable, clock sane.
+//    - Critical checks (docroot, ports, clock) make us refuse to start
+//      with StrictStartup (-strict); otherwise everything is only
+//      reported and we carry on as before.
+//    - Helix doesn't terminate TLS, so there are no certificates to
+//      check; the signing key is validated by loadSigningKey already.
+// ─────────────────────────────────────────────────────────────────
+
+// StrictStartup refuses to start when a critical self-check fails.
+var StrictStartup = false
+
+// selfCheckDialTimeout bounds each upstream reachability check.
+var selfCheckDialTimeout = 2 * time.Second
+
+// clockFloor is a date the clock can't sensibly be before.
+var clockFloor = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+type checkResult struct {
+	name     string
+	status   string
+	detail   string
+	critical bool
+}
+
+// runSelfCheck runs every check, logs the results and returns an
+// error if a critical one failed and StrictStartup is set.
+func runSelfCheck() error {
+	var results []checkResult
+	results = append(results, checkDocroot()...)
+	results = append(results, checkErrorPages()...)
+	results = append(results, checkPorts()...)
+	results = append(results, checkUpstreams()...)
+	results = append(results, checkClock())
+
+	var failed []string
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.status]++
+		switch r.status {
+		case checkOK:
+			logInfof("selfcheck", "Self-check %s: %s", r.name, r.detail)
+		case checkWarn:
+			logWarnf("selfcheck", "Self-check %s: %s", r.name, r.detail)
+		case checkFail:
+			logErrorf("selfcheck", "Self-check %s: %s", r.name, r.detail)
+			if r.critical {
+				failed = append(failed, r.name)
+			}
+		}
+	}
+	fmt.Printf("Self-check: %d ok, %d warnings, %d failed\n", counts[checkOK], counts[checkWarn], counts[checkFail])
+	if len(failed) > 0 && StrictStartup {
+		return fmt.Errorf("critical self-checks failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func checkDocroot() []checkResult {
+	dir, err := os.Open(DocRoot)
+	if DocRoot == DefaultRoot && os.IsNotExist(err) {
+		return []checkResult{{"docroot", checkWarn, DocRoot + " missing, serving the built-in welcome page", false}}
+	}
+	if err != nil {
+		return []checkResult{{"docroot", checkFail, err.Error(), true}}
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return []checkResult{{"docroot", checkFail, fmt.Sprintf("%s not readable: %v", DocRoot, err), true}}
+	}
+	results := []checkResult{{"docroot", checkOK, DocRoot + " readable", true}}
+
+	index := path.Join(DocRoot, IndexFile)
+	if f, err := os.Open(index); err != nil {
+		results = append(results, checkResult{"index", checkWarn, fmt.Sprintf("%s missing, / shows the built-in welcome page", index), false})
+	} else {
+		f.Close()
+		results = append(results, checkResult{"index", checkOK, index, false})
+	}
+	return results
+}
+
+// checkErrorPages reports ErrorDocuments that point at nothing; the
+// /<status>.html defaults are optional and not reported.
+func checkErrorPages() []checkResult {
+	if len(ErrorDocuments) == 0 {
+		return nil
+	}
+	var missing []string
+	for status, doc := range ErrorDocuments {
+		if _, err := os.Stat(path.Join(DocRoot, doc)); err != nil {
+			missing = append(missing, fmt.Sprintf("%d → %s", status, doc))
+		}
+	}
+	if len(missing) > 0 {
+		return []checkResult{{"error pages", checkWarn, "not found: " + strings.Join(missing, ", "), false}}
+	}
+	return []checkResult{{"error pages", checkOK, fmt.Sprintf("%d custom pages found", len(ErrorDocuments)), false}}
+}
+
+// checkPorts binds (and releases) the addresses we are about to use.
+func checkPorts() []checkResult {
+	var results []checkResult
+	for _, addr := range []struct{ name, addr string }{{"listen", ListenAddr}, {"admin", AdminListenAddr}} {
+		if addr.addr == "" {
+			continue
+		}
+		l, err := net.Listen("tcp", addr.addr)
+		if err != nil {
+			results = append(results, checkResult{addr.name + " port", checkFail, err.Error(), addr.name == "listen"})
+			continue
+		}
+		l.Close()
+		results = append(results, checkResult{addr.name + " port", checkOK, addr.addr + " bindable", addr.name == "listen"})
+	}
+	return results
+}
+
+// checkUpstreams dials every service we talk to. Unreachable ones are
+// only a warning, they may well come up after us.
+func checkUpstreams() []checkResult {
+	targets := map[string]string{}
+	if SyncFrom != "" {
+		targets["sync origin"] = SyncFrom
+	}
+	for _, peer := range ClusterPeers {
+		targets["cluster peer "+peer] = peer
+	}
+	if SLOWebhookURL != "" {
+		targets["slo webhook"] = SLOWebhookURL
+	}
+	if ChallengeEnabled && HCaptchaSiteKey != "" {
+		targets["hcaptcha"] = "https://api.hcaptcha.com"
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []checkResult
+	)
+	for name, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := checkResult{name: name, status: checkOK, detail: target + " reachable"}
+			if err := dialURL(target); err != nil {
+				r.status, r.detail = checkWarn, fmt.Sprintf("%s unreachable: %v", target, err)
+			}
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// dialURL opens (and closes) a TCP connection to rawURL's host.
+func dialURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("not a URL")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, selfCheckDialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkClock catches a clock that was never set (1970, an RTC reset),
+// which breaks Last-Modified, log timestamps and token expiry.
+func checkClock() checkResult {
+	now := time.Now()
+	if now.Before(clockFloor) {
+		return checkResult{"clock", checkFail, fmt.Sprintf("%s is before %s, clock not set?", now.UTC().Format(time.RFC3339), clockFloor.Format("2006-01-02")), true}
+	}
+	if info, err := os.Stat(DocRoot); err == nil && info.ModTime().After(now.Add(time.Hour)) {
+		return checkResult{"clock", checkWarn, fmt.Sprintf("%s was modified in the future (%s), clock behind?", DocRoot, info.ModTime().UTC().Format(time.RFC3339)), true}
+	}
+	return checkResult{"clock", checkOK, now.UTC().Format(time.RFC3339), true}
+}
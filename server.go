@@ -6,6 +6,7 @@ import (
 	"bufio"			//buffered I/O - easily read lines for a conn
 	"bytes"			//to read or write files we must create a byte buffer
 	"errors"		//to build small reusable error values
+	"flag"			//command line flags
 	"fmt"			//formatting I/O
 	"io"			//to I/O
 	"log"			//to set up our logwriter
@@ -13,6 +14,8 @@ import (
 	"net"			//for creating listener and accepting connections
 	"os"			//creating dir and stuff like that
 	"path/filepath"	//combining requested path with the default path
+	"runtime/debug"	//stack traces of handler panics
+	"strconv"		//Content-Length
 	"strings"		//for splitting request lines and trimming CRLF
 	"time"			//for timestamps
 )
@@ -28,47 +31,196 @@ const DefaultRoot = "./public"
 //Since we didn't specify any host, our server would listen on all interfaces
 const DefaultListenAddr = ":8080"
 
+//DefaultLogDir is where server.log goes
+const DefaultLogDir = "logs"
+
+//DefaultIndex is the file served for a directory
+const DefaultIndex = "index.html"
+
+//MIMETypes overrides the Content-Type for a file extension, e.g.
+//{".webmanifest": "application/manifest+json"}
+var MIMETypes = map[string]string{}
+
+//ListenAddr, DocRoot, LogDir and IndexFile are the settings actually in
+//use: the defaults above unless changed with -addr, -root, -log-dir and
+//-index (see main), so several instances can run side by side
+var (
+	ListenAddr = DefaultListenAddr
+	DocRoot    = DefaultRoot
+	LogDir     = DefaultLogDir
+	IndexFile  = DefaultIndex
+)
+
 //logWriter is the global pointer to log.Logger that writes into a file. (in the log folder)
 var logWriter *log.Logger
 
+//request holds everything we parsed off the wire for a single request;
+//handlers get it as is
+type request struct {
+	id          string        // random, sent back as X-Request-Id
+	clientAddr  string        // e.g. "127.0.0.1:51748"
+	requestLine string        // e.g. "GET /index.html HTTP/1.1"
+	method      string        // "GET"
+	rawPath     string        // the request target, "/index.html?v=2"
+	version     string        // "HTTP/1.1"
+	header      Header        // every header line, req.header.Get("User-Agent")
+	host        string        // the Host header normalized, "example.com" (see host.go)
+	body        io.Reader     // the request body (Content-Length bytes), empty if none
+	clientTag   clientTag     // human, bot or (verified/fake) crawler, see bot.go
+	redirects   int           // internal redirects so far, see internal.go
+	errorPage   bool          // serving an error document, see internal.go
+	timing      requestTiming // when each phase finished, see slowlog.go
+	site        *siteSettings // root, index, MIME, error pages; see reload.go
+}
+
 // ─────────────────────────────────────────────────────────────────
 //  main()
-//    - Parses flags (hardcoded for now, but you could use `flag` package).
-//    - Sets up logging (writes to ./logs/server.log).
-//    - Listens on TCP, accepts connections, spawns handleConnection().
+//    - Hands the command line to the subcommand table in cli.go.
 // ─────────────────────────────────────────────────────────────────
 
 func main() {
-	//Prepare the logs directory (./logs/server.log)
-	err := os.MkdirAll("logs", 0755)
+	//"helix serve", "helix check", ... (see cli.go)
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  runServe(args) int
+//    - "helix serve [flags]", also what plain "helix [flags]" does.
+//    - Parses flags (-addr, -root, -log-dir, -index), the -profile,
+//      the -config file and HELIX_* variables; flags win, then the
+//      environment, then the file, then the profile.
+//    - Sets up logging (writes to <log-dir>/server.log, reopened on SIGUSR1).
+//    - Listens on TCP, accepts connections, spawns handleConnection().
+//    - Returns the exit status; only returns at all if startup fails.
+// ─────────────────────────────────────────────────────────────────
+
+func runServe(args []string) int {
+	//Defaults, then the profile, the config file, HELIX_* variables, flags
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := loadSettings(fs); err != nil {
+		fmt.Printf("Invalid config: %v\n", err)
+		return 2
+	}
+	if err := checkFlags(); err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if err := loadListingTemplate(); err != nil {
+		fmt.Printf("Invalid autoindex template: %v\n", err)
+		return 2
+	}
+	if err := openVhostLogs(); err != nil {
+		fmt.Printf("Could not open log file: %v\n", err)
+		return 1
+	}
+	currentSite.Store(siteFromSettings())
+
+	//Prepare the logs directory (<log-dir>/server.log)
+	err := os.MkdirAll(LogDir, 0755)
 	if err != nil {
 		fmt.Printf("Could not create logs directory: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
-	//Open (or create) ./logs/server.log for appending
-	logFile, err := os.OpenFile("logs/server.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) //flags to append and create the file if not existing
+	//Open (or create) <log-dir>/server.log for appending
+	logFile, err := openLogFile(serverLogPath()) //see logfile.go
 	if err != nil {
 		fmt.Printf("Could not open log file: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 	defer logFile.Close() //close the logger after the main function returns
 
+	//SIGUSR1 → close and reopen the log file (for logrotate)
+	watchLogReopen(logFile)
+
+	//SIGHUP → re-read the config file (see reload.go)
+	watchReload()
+
 	//Create a new logger that writes to logFile, with no default prefix
 	//We’ll add our own prefixes manually (like. "[INFO]")
 	logWriter = log.New(logFile, "", 0)
 
+	//GC soft limit from the container's memory limit (see memlimit.go)
+	applyMemoryLimit()
+
+	//Open files limit and the connections it leaves room for (see fdbudget.go)
+	setupFDBudget()
+
+	//Startup ban list (see acceptfilter.go)
+	if err := loadBannedIPs(); err != nil {
+		fmt.Printf("Invalid ban list: %v\n", err)
+		return 1
+	}
+
+	//Client regions for mirror redirects (see mirrors.go)
+	if err := loadGeoIP(); err != nil {
+		fmt.Printf("Could not load the GeoIP file: %v\n", err)
+		return 1
+	}
+
+	//file:/env:/vault: references in secret settings (see secrets.go)
+	if err := loadSecrets(); err != nil {
+		fmt.Printf("Could not load secrets: %v\n", err)
+		return 1
+	}
+
+	//Optional Ed25519 response signatures (see signing.go)
+	if err := loadSigningKey(); err != nil {
+		fmt.Printf("Invalid signing key: %v\n", err)
+		return 1
+	}
+
+	//Docroot, ports, upstreams, clock; fatal only with -strict (see selfcheck.go)
+	if err := runSelfCheck(); err != nil {
+		fmt.Printf("Refusing to start: %v\n", err)
+		return 1
+	}
+
+	//Push metrics to StatsD/DogStatsD if configured (see statsd.go)
+	startStatsD()
+
+	//Operator-only endpoints (pprof, expvar) on their own address (see admin.go)
+	startAdmin()
+
+	//Latency/error SLO alerts, if a webhook is configured (see slo.go)
+	startSLOMonitor()
+
+	//Warn before the log/docroot volumes fill up (see diskwatch.go)
+	startDiskWatchdog()
+
+	//Fault injection for client testing, off unless asked for (see chaos.go)
+	startChaos()
+
+	//Read hot files into the cache before taking traffic (see filecache.go)
+	warmUp()
+
+	//Search index: load the saved one, refresh in the background (see search.go)
+	startSearch()
+
+	//Per-file download counts from the last run (see downloads.go)
+	loadDownloadStats()
+
+	//Fleet-wide singleton jobs run on the leader only (see leader.go)
+	startLeaderElection()
+
+	//Housekeeping jobs: pruning, log compression (see scheduler.go)
+	startScheduler()
+
 	//Log server startup - message to both log and stdout
-	startupMsg := fmt.Sprintf("[INFO] %s – Server starting on %s\n", time.Now().UTC().Format(time.RFC3339), DefaultListenAddr)
+	startupMsg := fmt.Sprintf("[INFO] %s – Server starting on %s, serving %s\n", time.Now().UTC().Format(time.RFC3339), ListenAddr, DocRoot)
 	logWriter.Print(startupMsg)
 	fmt.Print(startupMsg)
 
 	//Create a TCP listener
-	listener, err := net.Listen("tcp", DefaultListenAddr)
+	listener, err := net.Listen("tcp", ListenAddr)
 	if err != nil {
-		logWriter.Printf("[ERROR] %s – Could not listen on %s: %v\n", time.Now().UTC().Format(time.RFC3339), DefaultListenAddr, err)
-		fmt.Printf("Could not listen on %s: %v\n", DefaultListenAddr, err)
-		os.Exit(1)
+		logErrorf("server", "Could not listen on %s: %v", ListenAddr, err)
+		fmt.Printf("Could not listen on %s: %v\n", ListenAddr, err)
+		return 1
 	}
 	//Logging when the server closes the connection
 	defer func() {
@@ -78,13 +230,110 @@ func main() {
 		fmt.Print(shutdownMsg)
 	}()
 
+	acceptLoop(listener)
+	return 0
+}
+
+// defineFlags registers the server flags on fs ("helix serve" and "helix check").
+func defineFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ListenAddr, "addr", DefaultListenAddr, "address to listen on (host:port)")
+	fs.StringVar(&DocRoot, "root", DefaultRoot, "directory to serve files from")
+	fs.StringVar(&LogDir, "log-dir", DefaultLogDir, "directory for server.log")
+	fs.StringVar(&IndexFile, "index", DefaultIndex, "file served for a directory")
+	fs.BoolVar(&StrictStartup, "strict", false, "refuse to start if a critical self-check fails")
+	fs.StringVar(&configFile, "config", "", "YAML config file (see config.go)")
+	fs.StringVar(&configProfile, "profile", "", "defaults for a kind of deployment: dev, prod or hardened (see profiles.go)")
+}
+
+// loadSettings applies the -profile, the config file and HELIX_*
+// variables on top of the defaults, once fs is parsed. Flags given on the command line
+// still win, so remember them and set them again afterwards.
+func loadSettings(fs *flag.FlagSet) error {
+	setByFlags = map[string]string{}
+	fs.Visit(func(f *flag.Flag) { setByFlags[f.Name] = f.Value.String() })
+	if err := loadProfile(); err != nil {
+		return err
+	}
+	if configFile != "" {
+		if err := loadConfigFile(configFile); err != nil {
+			return err
+		}
+	}
+	if err := loadEnvConfig(); err != nil {
+		return err
+	}
+	for name, value := range setByFlags {
+		fs.Set(name, value)
+	}
+	return nil
+}
+
+// checkFlags rejects settings we can't start with.
+func checkFlags() error {
+	//A missing ./public is fine, the built-in welcome page fills in (see assets.go)
+	if _, err := os.Stat(DocRoot); DocRoot == DefaultRoot && os.IsNotExist(err) {
+		return checkVhosts()
+	}
+	if err := checkRoot(DocRoot); err != nil {
+		return fmt.Errorf("-root %w", err)
+	}
+	if !plainFileName(IndexFile) {
+		return fmt.Errorf("-index %q: must be a plain file name", IndexFile)
+	}
+	if err := checkPrivacy(); err != nil {
+		return err
+	}
+	if err := checkWatermark(); err != nil {
+		return err
+	}
+	if err := checkRateLimit(); err != nil {
+		return err
+	}
+	return checkVhosts()
+}
+
+func checkRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", root)
+	}
+	return nil
+}
+
+func plainFileName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/\\")
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  acceptLoop(listener)
+//    - Accepts connections until the listener is closed, one
+//      goroutine per connection.
+//    - Backs off while Accept fails (see acceptbackoff.go).
+// ─────────────────────────────────────────────────────────────────
+
+func acceptLoop(listener net.Listener) {
+	var backoff acceptBackoff
 	//infinte loop for multiple clients
 	//for each connecttion, start a goroutine
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			//accept failure gets logged
-			logWriter.Printf("[ERROR] %s – Accept error: %v\n", time.Now().UTC().Format(time.RFC3339), err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			//Out of file descriptors & co.: wait instead of spinning
+			backoff.failed(err)
+			continue
+		}
+		backoff.succeeded()
+		//Cheap checks (ban list, connection rate) before we spend a
+		//goroutine on it, see acceptfilter.go
+		if !allowConnection(conn) {
+			conn.Close()
+			continue
+		}
+		//Near the open files limit: a 503 now beats failing mid-response (see fdbudget.go)
+		if !admitConnection(conn) {
 			continue
 		}
 		//new connection (custom function) 
@@ -94,17 +343,22 @@ func main() {
 
 // ─────────────────────────────────────────────────────────────────
 //  handleConnection()
-//    - Reads the request line (e.g. "GET /foo/bar.html HTTP/1.1").
-//    - Reads & discards the rest of the request headers.
-//    - Figures out which file on disk to serve.
-//    - Checks existence/permissions.
-//    - Determines content‐type (MIME).
-//    - Writes either: 200 + file contents, OR 403/404 + custom error page.
-//    - Logs each request in the desired format.
+//    - Serves requests on the connection until it should close
+//      (see keepalive.go), one serveNext() each.
+//    - A panic in a handler is logged with its stack and closes just
+//      this connection.
 // ─────────────────────────────────────────────────────────────────
 
 func handleConnection(conn net.Conn) {
+	defer connClosed()
 	defer conn.Close() //close connection when the function returns
+	//A bug in a handler costs this connection, not the server
+	defer func() {
+		if r := recover(); r != nil {
+			logErrorf("server", "Panic serving %s: %v\n%s", logAddr(conn.RemoteAddr().String()), r, debug.Stack())
+			incCounter("panics", nil, 1)
+		}
+	}()
 
 	//stores client address in string format
 	clientAddr := conn.RemoteAddr().String() // e.g. "127.0.0.1:51748" 
@@ -112,166 +366,485 @@ func handleConnection(conn net.Conn) {
 	//thise create a buffered reader object which when called to read
 	//first reads from the buffer and when it's expty only then makes 
 	//a call to conn. This way the number of calls are minimized thus
-	//offering much greater efficiency than calling conn everytime
+	//offering much greater efficiency than calling conn everytime.
+	//It lives as long as the connection, so pipelined requests that
+	//arrived together aren't lost between two requests.
 	reader := bufio.NewReader(conn)
 
+	for served := 1; serveNext(conn, reader, clientAddr, served); served++ {
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveNext() bool
+//    - Reads the request line (e.g. "GET /foo/bar.html HTTP/1.1").
+//    - Reads the rest of the request headers.
+//    - Runs handleRequest() with a ResponseWriter on the connection.
+//    - Logs each request in the desired format.
+//    - Returns whether the connection can take another request.
+// ─────────────────────────────────────────────────────────────────
+
+func serveNext(conn net.Conn, reader *bufio.Reader, clientAddr string, served int) bool {
+	//Waiting for (and reading) the next request may only take so long
+	conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+
 	//Read the request line (method, path, version)
 	requestLine, err := readRequestLine(reader) // custom function
+	start := time.Now() //for request duration metrics and slow request logs
 	if err != nil {
-		// If we couldn’t read a valid request line, close silently.
-		return
+		// Too long → 400. If the client is done with the connection
+		// (or timed out), close silently.
+		if isBadRequest(err) {
+			serveBadRequest(conn, reader, clientAddr, "", nil, err, start)
+		}
+		return false
 	}
+
+	//Handler lifetimes feed the leak watch (see leakwatch.go)
+	defer trackHandler(clientAddr, start)()
+
 	//example of a requestLine: "GET /index.html HTTP/1.1"
-	parts := strings.Split(requestLine, " ")
-	if len(parts) != 3 {
-		// Malformed request line → ignore or could write a 400 Bad Request. We’ll just close.
-		return
+	method, target, version, err := parseRequestLine(requestLine)
+	if err != nil {
+		// Malformed request line → 400 Bad Request and close (see badrequest.go)
+		serveBadRequest(conn, reader, clientAddr, requestLine, nil, err, start)
+		return false
 	}
-	method, rawPath, version := parts[0], parts[1], parts[2]
 
-	//Discard all remaining request headers until a blank line
-	err = discardHeaders(reader)
+	//Read the remaining request headers until a blank line
+	header, err := readHeaders(reader)
+	if err != nil {
+		// Broken headers → 400 as well; a vanished client is just closed.
+		if isBadRequest(err) {
+			serveBadRequest(conn, reader, clientAddr, requestLine, header, err, start)
+		}
+		return false
+	}
+	//HTTP/1.1 needs exactly one well formed Host (see host.go)
+	host, err := checkHost(version, header)
+	if err != nil {
+		serveBadRequest(conn, reader, clientAddr, requestLine, header, err, start)
+		return false
+	}
+	//Content-Length or chunked, anything else we can't read (see chunked.go)
+	body, err := requestBody(reader, version, header)
 	if err != nil {
-		// If something goes wrong reading headers, just close.
+		serveBadRequest(conn, reader, clientAddr, requestLine, header, err, start)
+		return false
+	}
+	body = &bodyReader{conn: conn, r: body} //stalled uploads time out (see keepalive.go)
+
+	req := &request{
+		id:          newRequestID(),
+		clientAddr:  clientAddr,
+		requestLine: requestLine,
+		method:      method,
+		rawPath:     target,
+		version:     version,
+		header:      header,
+		host:        host,
+		site:        siteNow().siteFor(host),
+	}
+	req.body = body
+	req.timing.start = start
+	req.timing.parsed = time.Now()
+
+	//Every handler writes through w, which remembers the status so
+	//we can log the request once it's done
+	dumpRequest(req) //only if switched on through the admin API
+	w := newResponseWriter(conn, reader, req.method, req.version)
+	w.keepAlive = wantsKeepAlive(req, served)
+	w.Header().Set("X-Request-Id", req.id)
+	//Tiny files served moments ago skip the middleware (see fastlane.go)
+	if !serveFast(w, req) {
+		handleRequest(w, req)
+	}
+	if w.hijacked {
+		//Someone else owns the connection now
+		return false
+	}
+	req.timing.handled = time.Now()
+	flushErr := w.finish()
+	req.timing.done = time.Now()
+
+	logRequest(req, w.status)
+	recordRequestMetrics(req, w.status, w.written, req.timing.done.Sub(start))
+	checkRequestBudgets(req, w.status, w.written) //slow/large WARN lines, see slowlog.go
+
+	//The response may have turned keep-alive off (no Content-Length)
+	return flushErr == nil && w.keepAlive && discardBody(req)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  handleRequest()
+//    - Sheds load with a 503 above MaxConnections.
+//    - Tags bots/crawlers and applies the per-IP rate limit.
+//    - Optionally challenges suspicious clients (challenge.go).
+//    - Hands the request to dispatch(); errors it returns are turned
+//      into the matching status + error page by handleError().
+// ─────────────────────────────────────────────────────────────────
+
+func handleRequest(w ResponseWriter, req *request) {
+	//Too busy? Shed this request cheaply (see overload.go)
+	if overloaded(activeConns.Add(1)) {
+		activeConns.Add(-1)
+		writeLimitResponse(w, req, 503, 0)
+		return
+	}
+	defer activeConns.Add(-1)
+
+	//Work out whether this is a person, a generic bot, or a crawler
+	//claiming to be Googlebot & co. (and whether that claim holds up)
+	ip := clientIP(req.clientAddr)
+	req.clientTag = classifyClient(ip, req.header.Get("User-Agent"))
+
+	//Our own peers (replicas pulling the docroot, ban list updates) skip
+	//rate limits + challenge (see docsync.go, cluster.go)
+	peer := syncAuthorized(req) || clusterAuthorized(req)
+
+	//Verified crawlers skip rate limiting, everyone else gets a bucket.
+	//Fake crawlers get a much smaller one (see ratelimit.go)
+	if ok, wait := allowRequest(ip, req.clientTag); !ok && !peer {
+		writeLimitResponse(w, req, 429, wait)
+		return
+	}
+	//Only a request the limit let in may cost a DNS lookup (see bot.go)
+	req.clientTag = verifyClaim(ip, req.clientTag)
+
+	//Suspicious clients have to pass a challenge first (see challenge.go)
+	if ChallengeEnabled && !peer && handleChallenge(w, req) {
+		return
+	}
+
+	//Deliberate misbehaviour for client testing, when on (see chaos.go)
+	if injectChaos(w, req) {
 		return
 	}
 
-	// We only support GET. If anything else, respond 405 Method Not Allowed.
-	if method != "GET" {
-		statusLine := fmt.Sprintf("%s 405 Method Not Allowed\r\n", version)
-		body := "<html><body><h1>405 Method Not Allowed</h1></body></html>"
-		writeMinimalResponse(conn, statusLine, "text/html", []byte(body)) //custom function
-		logRequest(clientAddr, requestLine, 405) //custom function for logging each request
+	//Expensive routes only get so many workers (see routelimit.go)
+	release, ok := acquireRouteSlot(req)
+	if !ok {
+		writeLimitResponse(w, req, 503, 0)
 		return
 	}
+	defer release()
+
+	//Headers from the config and the site's _headers file (see headers.go)
+	w = withSiteHeaders(w, req)
+
+	//Serve the request; anything that goes wrong comes back as an error
+	//which handleError turns into the right status + error page
+	if err := dispatch(w, req); err != nil {
+		//OPTIONS a handler turned down: say what it takes (see options.go)
+		if req.method == "OPTIONS" && answerOptions(w, err) {
+			return
+		}
+		handleError(w, req, err)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  serveStatic()
+//    - Figures out which file on disk to serve.
+//    - Checks existence/permissions.
+//    - Determines content‐type (MIME).
+//    - Writes 200 + file contents, or returns one of the errors from
+//      errors.go (ErrNotFound, ErrForbidden, ...) without writing anything.
+// ─────────────────────────────────────────────────────────────────
+
+func serveStatic(w ResponseWriter, req *request) error {
+	// We only support GET and HEAD (GET without the body, see response.go).
+	// If anything else, respond 405 Method Not Allowed.
+	if !readMethod(req.method) {
+		return onlyMethods("GET", "HEAD")
+	}
+
+	target, err := resolveStatic(req.site, req.rawPath)
+	if err != nil {
+		//Nothing at / yet: say hello instead of a 404 (see assets.go)
+		if showsWelcome(req, err) {
+			writeBody(w, 200, "text/html; charset=utf-8", welcomePage(req.site))
+			return nil
+		}
+		return err
+	}
+	if target.redirect != "" {
+		// Not the canonical URL of the page (see pretty.go)
+		w.Header().Set("Location", target.redirect)
+		writeBody(w, 301, "text/html", nil)
+		return nil
+	}
+	if target.listing {
+		// No index file, but listings are on (see autoindex.go)
+		return serveAutoindex(w, req, target.cleanPath, target.localPath, target.info)
+	}
+	localPath, info := target.localPath, target.info
+
+	//Per-file headers and access rules (see sidecar.go)
+	meta, err := sidecarFor(localPath)
+	if err != nil {
+		return err
+	}
+	if meta != nil {
+		if err := meta.authorize(w, req); err != nil {
+			return err
+		}
+	}
+
+	//Unchanged since the client's copy → 304, no need to read the file (see conditional.go)
+	etag, err := fileETag(localPath, info)
+	if err != nil {
+		return err
+	}
+	//Gated files name who fetched them (see watermark.go)
+	wm := watermarkFor(req, detectContentType(req.site, localPath), meta)
+	if wm != nil {
+		etag = wm.etag(etag)
+	}
+	if err := checkPreconditions(req, etag, info.ModTime()); err != nil {
+		return err //412
+	}
+	if notModified(req, etag, info.ModTime()) {
+		setFileHeaders(w.Header(), req, localPath, info, meta, etag)
+		setWatermarkHeaders(w.Header(), wm)
+		w.Header().Del("Content-Type")
+		w.WriteHeader(304)
+		return nil
+	}
+
+	//At this point, localPath points to a regular file we intend to serve.
+	//Small, unchanged files come from memory (see filecache.go)
+	body, cached := fileCacheGet(localPath, info)
+	var content io.ReaderAt //what a Range is cut from
+	size := info.Size()
+	var file *os.File
+	if cached {
+		content = bytes.NewReader(body)
+	} else {
+		//Open the file
+		file, err = os.Open(localPath)
+		if err != nil {
+			// Permission denied or other error → 403
+			return fmt.Errorf("%w: open %s: %v", ErrForbidden, localPath, err)
+		}
+		defer file.Close()
+		content = file
+	}
+	if wm != nil {
+		//The mark changes the bytes, so this copy is built whole in memory
+		if !cached {
+			if body, err = readFileBody(file, localPath, info); err != nil {
+				return err
+			}
+			cached = true
+		}
+		body = wm.apply(body, localPath) //a copy, the cached body stays clean
+		content, size = bytes.NewReader(body), int64(len(body))
+	}
+
+	//Write the 200 OK response (status line + headers, then the body)
+	setFileHeaders(w.Header(), req, localPath, info, meta, etag)
+	setWatermarkHeaders(w.Header(), wm)
+	//Range: bytes=… → 206 with just that part, read from the file
+	//without the rest, so seeking in a big video stays cheap (see range.go)
+	if sent, handled, err := serveRange(w, req, content, size); handled {
+		if err == nil {
+			recordDownload(req, localPath, sent) //see downloads.go
+		}
+		return err
+	}
+	if !cached {
+		if body, err = readFileBody(file, localPath, info); err != nil {
+			return err
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	signResponse(w, req, body)
+	w.WriteHeader(200)
+	//If body writing fails there's nobody left to send an error page to
+	w.Write(body)
+	if file == nil && meta == nil { //from the file cache; sidecars may answer per user
+		rememberFast(req, localPath, info, etag, w.Header(), body) //see fastlane.go
+	}
+	recordDownload(req, localPath, []byteRange{{0, int64(len(body))}})
+	return nil
+}
+
+// readFileBody reads the whole of file, the one at localPath, and
+// offers it to the file cache.
+func readFileBody(file *os.File, localPath string, info os.FileInfo) ([]byte, error) {
+	//Read file content into memory (for small files) or stream
+	//For simplicity, we’ll read the entire file before writing headers.
+	buf := bytes.Buffer{}
+	if _, err := io.Copy(&buf, file); err != nil {
+		// Not one of our error kinds → handleError answers 500
+		return nil, fmt.Errorf("read %s: %w", localPath, err)
+	}
+	fileCachePut(localPath, info, buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// setFileHeaders sets the headers describing the file at localPath,
+// the same for a 200, 206 or 304.
+func setFileHeaders(h Header, req *request, localPath string, info os.FileInfo, meta *sidecar, etag string) {
+	//Determine Content‐Type (MIME) by extension
+	h.Set("Content-Type", detectContentType(req.site, localPath))
+	applyFontPreset(h, req, localPath) //CORS + caching for web fonts, see fonts.go
+	if meta != nil {
+		meta.apply(h) //see sidecar.go
+	}
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Last-Modified", httpDate(info.ModTime()))
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+	if link := describedByLink(req.path()); link != "" {
+		h.Set("Link", link) //Metalink for big downloads, see torrent.go
+	}
+}
 
+// ─────────────────────────────────────────────────────────────────
+//  resolveStatic(site, rawPath) (staticTarget, error)
+//    - Maps a request path to the file (or directory listing) it
+//      serves under site's root, without reading anything. Used by
+//      serveStatic() and "helix explain".
+// ─────────────────────────────────────────────────────────────────
+
+type staticTarget struct {
+	cleanPath string      // "/docs/"
+	rawQuery  string      // "sort=size", still encoded
+	localPath string      // "/srv/www/docs/index.html"
+	info      os.FileInfo // of localPath
+	listing   bool        // directory without an index file, autoindex on
+	redirect  string      // canonical URL to send the client to instead, see pretty.go
+}
+
+func resolveStatic(site *siteSettings, rawPath string) (staticTarget, error) {
 	//Sanitize the requested path to prevent directory‐traversal
 	//For example, if rawPath = "/../etc/passwd" we want to reject it.
-	cleanPath, securityErr := sanitizePath(rawPath)
+	urlPath, rawQuery := splitTarget(rawPath) //"/my%20file.html" is "/my file.html" (see target.go)
+	cleanPath, securityErr := sanitizePath(urlPath)
 	if securityErr != nil {
-		// Send 403 Forbidden if the path contained ".." or null bytes
-		serveErrorPage(conn, clientAddr, requestLine, 403)
-		return
+		// 403 Forbidden if the path contained ".." or null bytes
+		return staticTarget{}, fmt.Errorf("%w: %v", ErrForbidden, securityErr)
+	}
+
+	//Pretty URLs: /about → about.html, canonical redirects (see pretty.go)
+	if site.prettyURLs {
+		if target, ok := prettyTarget(site, urlPath, cleanPath, rawQuery); ok {
+			return target, nil
+		}
 	}
 
 	// At this point, cleanPath is something like "/index.html" or "/css/style.css".
-	// We want to map it to a file under DefaultRoot.
-	localPath := filepath.Join(DefaultRoot, cleanPath)
+	// We want to map it to a file under the document root.
+	localPath := filepath.Join(site.root, cleanPath)
 
 	//Stat the file (or directory)
 	info, err := os.Stat(localPath)
 	if err != nil {
+		if os.IsNotExist(err) && site.caseFold {
+			// Maybe just spelled differently (see casefold.go)
+			if redirect, ok := caseFoldRedirect(site, urlPath, cleanPath, rawQuery); ok {
+				return staticTarget{redirect: redirect}, nil
+			}
+		}
 		if os.IsNotExist(err) {
 			// 404 Not Found
-			serveErrorPage(conn, clientAddr, requestLine, 404)
-		} else {
-			// Some other error (e.g. 403)
-			logWriter.Printf("[ERROR] %s – Stat error on %s: %v\n", time.Now().UTC().Format(time.RFC3339), localPath, err)
-			serveErrorPage(conn, clientAddr, requestLine, 403)
+			return staticTarget{}, ErrNotFound
 		}
-		return
+		// Some other error (e.g. 403)
+		return staticTarget{}, fmt.Errorf("%w: stat %s: %v", ErrForbidden, localPath, err)
 	}
 
-	//If it’s a directory, try to serve index.html inside
+	//If it’s a directory, try to serve the index file (index.html) inside
 	if info.IsDir() {
 		// Ensure the path ends in "/". If not, a browser might get confused, but
 		// for simplicity we assume the client already asked for "/some/dir/".
 		if !strings.HasSuffix(localPath, string(os.PathSeparator)) {
 			localPath += string(os.PathSeparator)
 		}
-		indexPath := filepath.Join(localPath, "index.html")
+		indexPath := filepath.Join(localPath, site.index)
 		indexInfo, err := os.Stat(indexPath)
+		if (err != nil || indexInfo.IsDir()) && site.autoindex {
+			// No index file, but listings are on (see autoindex.go)
+			return staticTarget{cleanPath: cleanPath, rawQuery: rawQuery, localPath: localPath, info: info, listing: true}, nil
+		}
 		if err != nil || indexInfo.IsDir() {
-			// No index.html or cannot read → 403 Forbidden
-			serveErrorPage(conn, clientAddr, requestLine, 403)
-			return
+			// No index file or cannot read → 403 Forbidden
+			return staticTarget{}, fmt.Errorf("%w: no %s in %s", ErrForbidden, site.index, localPath)
 		}
 		// If we found a valid index.html, serve that file instead:
 		localPath = indexPath
+		info = indexInfo
 	}
 
-	//At this point, localPath points to a regular file we intend to serve.
-	//Open the file
-	file, err := os.Open(localPath)
-	if err != nil {
-		// Permission denied or other error → 403
-		logWriter.Printf("[ERROR] %s – Open error on %s: %v\n", time.Now().UTC().Format(time.RFC3339), localPath, err)
-		serveErrorPage(conn, clientAddr, requestLine, 403)
-		return
-	}
-	defer file.Close()
-
-	//Determine Content‐Type (MIME) by extension
-	ctype := detectContentType(localPath)
-
-	//Read file content into memory (for small files) or stream
-	//For simplicity, we’ll read the entire file before writing headers.
-	buf := bytes.Buffer{}
-	n, err := io.Copy(&buf, file)
-	if err != nil {
-		logWriter.Printf("[ERROR] %s – Read error on %s: %v\n", time.Now().UTC().Format(time.RFC3339), localPath, err)
-		serveErrorPage(conn, clientAddr, requestLine, 500)
-		return
-	}
-
-	//Write the HTTP/1.1 200 OK response
-	statusLine := fmt.Sprintf("%s 200 OK\r\n", version)
-	headers := fmt.Sprintf(
-		"Date: %s\r\nContent-Type: %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n",
-		time.Now().UTC().Format(time.RFC1123),
-		ctype,
-		n,
-	)
-	_, err = conn.Write([]byte(statusLine + headers))
-	if err != nil {
-		//If we can’t even write, return
-		return
-	}
-
-	//Write the body (file contents)
-	_, err = conn.Write(buf.Bytes())
-	if err != nil {
-		//If body writing fails, retunr
-		return
-	}
-
-	//Log the successful request
-	logRequest(clientAddr, requestLine, 200)
+	return staticTarget{cleanPath: cleanPath, rawQuery: rawQuery, localPath: localPath, info: info}, nil
 }
 
 // ─────────────────────────────────────────────────────────────────
 //  readRequestLine()
-//    - Reads a single line from bufio.Reader (up to CRLF).
+//    - Reads a single line from bufio.Reader (up to CRLF), at most
+//      MaxRequestLine bytes (see badrequest.go).
 //    - Returns the line without the trailing CRLF.
+//    - Empty lines before it are skipped, some clients send a stray
+//      CRLF after a request body.
 // ─────────────────────────────────────────────────────────────────
 
 func readRequestLine(r *bufio.Reader) (string, error) {
-	line, err := r.ReadString('\n')
-	if err != nil {
-		return "", err
+	for {
+		line, err := readLine(r, MaxRequestLine)
+		if err != nil || line != "" {
+			return line, err
+		}
 	}
-	// HTTP request lines end with "\r\n", so TrimRight ensures we remove both.
-	return strings.TrimRight(line, "\r\n"), nil
 }
 
 // ─────────────────────────────────────────────────────────────────
-//  discardHeaders()
+//  readHeaders()
 //    - After reading the request line, an HTTP client will send
 //      zero or more header lines, each ending in CRLF, then a blank line.
 //    - We loop until we hit a blank line (\r\n) to know headers are done.
+//    - Header names are canonicalized so lookups don't care about case
+//      ("User-Agent" vs "user-agent"). Repeated headers keep every value,
+//      in order.
+//    - The whole block is capped at MaxHeaderBytes and MaxHeaderCount;
+//      lines without a colon, folded lines and names with spaces are
+//      rejected with ErrBadRequest (see badrequest.go).
 // ─────────────────────────────────────────────────────────────────
 
-func discardHeaders(r *bufio.Reader) error {
-	for {
-		line, err := r.ReadString('\n')
+func readHeaders(r *bufio.Reader) (Header, error) {
+	headers := make(Header)
+	budget := MaxHeaderBytes
+	for count := 0; ; count++ {
+		line, err := readLine(r, budget)
 		if err != nil {
-			return err
+			return headers, err
 		}
-		// A blank line is just "\r\n"
-		if line == "\r\n" {
-			return nil
+		budget -= len(line) + 2
+		// A blank line ends the headers
+		if line == "" {
+			return headers, nil
+		}
+		if count >= MaxHeaderCount {
+			return headers, fmt.Errorf("%w: more than %d headers", ErrBadRequest, MaxHeaderCount)
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return headers, fmt.Errorf("%w: folded header line", ErrBadRequest)
 		}
-		// Otherwise, keep looping (we’re ignoring header contents).
+		//"Name: value" → headers["Name"] = [..., "value"]
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return headers, fmt.Errorf("%w: header line without a colon", ErrBadRequest)
+		}
+		if name == "" || !isToken(name) {
+			return headers, fmt.Errorf("%w: invalid header name %q", ErrBadRequest, name)
+		}
+		//A CR or NUL inside a value reads differently to other parsers
+		if strings.ContainsAny(value, "\r\x00") {
+			return headers, fmt.Errorf("%w: control character in header %s", ErrBadRequest, name)
+		}
+		headers.Add(name, strings.TrimSpace(value))
 	}
 }
 
@@ -296,7 +869,7 @@ func sanitizePath(rawPath string) (string, error) {
 	}
 	//Prevent any “..” after cleaning (filepath.Clean can collapse, but if
 	//someone tried “/../../etc/passwd”, Clean would return “/etc/passwd”).
-	//As long as we take “/etc/passwd”, our Join(DefaultRoot, "/etc/passwd")
+	//As long as we take “/etc/passwd”, our Join(DocRoot, "/etc/passwd")
 	//would actually escape the root. So a safer check is to see if cleaned
 	//has “….” after splitting.
 	for _, segment := range strings.Split(cleaned, "/") {
@@ -308,12 +881,12 @@ func sanitizePath(rawPath string) (string, error) {
 }
 
 // ─────────────────────────────────────────────────────────────────
-//  detectContentType(filePath string) string
+//  detectContentType(site, filePath string) string
 //    - Uses mime.TypeByExtension to guess a Content‐Type from extension.
 //    - Falls back to "application/octet-stream" if unknown.
 // ─────────────────────────────────────────────────────────────────
 
-func detectContentType(filePath string) string {
+func detectContentType(site *siteSettings, filePath string) string {
 
 	//octet stream is used for unknown file types
 
@@ -321,6 +894,9 @@ func detectContentType(filePath string) string {
 	if ext == "" {
 		return "application/octet-stream"
 	}
+	if ctype, ok := site.mime[ext]; ok {
+		return ctype
+	}
 	ctype := mime.TypeByExtension(ext)
 	if ctype == "" {
 		return "application/octet-stream"
@@ -330,83 +906,53 @@ func detectContentType(filePath string) string {
 
 // ─────────────────────────────────────────────────────────────────
 //  serveErrorPage()
-//    - Depending on the status code (403 or 404), we try to serve
-//      public/403.html or public/404.html. If that file is missing,
-//      we write a minimal default HTML body.
-//    - We then log the request with the status code.
+//    - Clients preferring JSON or plain text (Accept header) get that
+//      instead of HTML, see errorpages.go.
+//    - Tries the custom error pages for the status code (see
+//      errorpages.go for the lookup order), each as an internal
+//      subrequest (see internal.go) answered with the error status.
+//    - If none can be served, we write the built-in page instead.
 // ─────────────────────────────────────────────────────────────────
 
-func serveErrorPage(conn net.Conn, clientAddr, requestLine string, statusCode int) {
-	version := "HTTP/1.1"
-	var statusText string
-	var errorFile string
-
-	switch statusCode {
-	case 403:
-		statusText = "403 Forbidden"
-		errorFile = filepath.Join(DefaultRoot, "403.html")
-	case 404:
-		statusText = "404 Not Found"
-		errorFile = filepath.Join(DefaultRoot, "404.html")
-	default:
-		statusText = fmt.Sprintf("%d Error", statusCode)
-		errorFile = "" // no custom page
-	}
-
-	// Attempt to read the custom error HTML from disk
-	var bodyBytes []byte
-	if errorFile != "" {
-		data, err := os.ReadFile(errorFile)
-		if err == nil {
-			bodyBytes = data
-		}
-	}
+func serveErrorPage(w ResponseWriter, req *request, statusCode int) {
+	//The body depends on Accept, so caches must not mix them up
+	w.Header().Add("Vary", "Accept")
 
-	// If we couldn’t read the custom page, fall back to a minimal built‐in body
-	if bodyBytes == nil {
-		fallback := fmt.Sprintf("<html><body><h1>%s</h1></body></html>", statusText)
-		bodyBytes = []byte(fallback)
+	// API clients get JSON or plain text instead of a page
+	if format := negotiateErrorFormat(req.header.Get("Accept")); format != formatHTML {
+		writeErrorBody(w, statusCode, format)
+		return
 	}
 
-	// Write response headers + body
-	statusLine := fmt.Sprintf("%s %s\r\n", version, statusText)
-	headers := fmt.Sprintf(
-		"Date: %s\r\nContent-Type: text/html\r\nContent-Length: %d\r\nConnection: close\r\n\r\n",
-		time.Now().UTC().Format(time.RFC1123),
-		len(bodyBytes),
-	)
-	_, _ = conn.Write([]byte(statusLine + headers))
-	_, _ = conn.Write(bodyBytes)
-
-	// Log the request with status code
-	logRequest(clientAddr, requestLine, statusCode)
-}
-
-// ─────────────────────────────────────────────────────────────────
-//  writeMinimalResponse()
-//    - For tiny/manual responses (like 405 Method Not Allowed), we
-//      can write a minimal status line + headers + body.
-// ─────────────────────────────────────────────────────────────────
+	// Attempt to serve a custom error page
+	for _, errorDoc := range errorDocCandidates(req.site, statusCode) {
+		if serveErrorDocument(w, req, statusCode, errorDoc) == nil {
+			return
+		}
+	}
 
-func writeMinimalResponse(conn net.Conn, statusLine, contentType string, body []byte) {
-	headers := fmt.Sprintf(
-		"Date: %s\r\nContent-Type: %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n",
-		time.Now().UTC().Format(time.RFC1123),
-		contentType,
-		len(body),
-	)
-	conn.Write([]byte(statusLine + headers))
-	conn.Write(body)
+	// If we couldn’t serve a custom page, fall back to the built‐in one
+	writeBody(w, statusCode, "text/html; charset=utf-8", builtinErrorPage(statusCode))
 }
 
 // ─────────────────────────────────────────────────────────────────
 //  logRequest()
 //    - Writes a single line to our log file in this format:
 //      [INFO] <timestamp> – <client_ip>:<port> – "<METHOD PATH HTTP/VERSION>" – <STATUS_CODE>
+//    - Bots and crawlers get an extra " – <tag>" at the end
+//      (e.g. "crawler=Googlebot verified") so they can be filtered out.
 // ─────────────────────────────────────────────────────────────────
 
-func logRequest(clientAddr, requestLine string, statusCode int) {
+func logRequest(req *request, statusCode int) {
 	ts := time.Now().UTC().Format(time.RFC3339)
-	logEntry := fmt.Sprintf("[INFO] %s – %s – %q – %d\n", ts, clientAddr, requestLine, statusCode)
-	logWriter.Print(logEntry)
+	logEntry := fmt.Sprintf("[INFO] %s – %s – %q – %d", ts, logAddr(req.clientAddr), req.requestLine, statusCode)
+	if tag := req.clientTag.String(); tag != "" {
+		logEntry += " – " + tag
+	}
+	if req.site.requestLog != nil {
+		//Vhosts with their own log (see vhost.go)
+		req.site.requestLog.Print(logEntry + "\n")
+		return
+	}
+	logWriter.Print(logEntry + "\n")
 }
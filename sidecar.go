@@ -0,0 +1,181 @@
+// sidecar.go
+
+package main
+
+import (
+	"crypto/hmac"     //constant-time password check
+	"encoding/base64" //Basic credentials
+	"encoding/json"   //sidecar format
+	"fmt"             //errors
+	"net/textproto"   //canonical header names
+	"os"              //reading sidecars
+	"path/filepath"   //hiding sidecars
+	"strings"         //suffix checks
+	"sync"            //cache
+	"time"            //change detection
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Per-file metadata sidecars
+//    - With SidecarsEnabled (config "sidecars"), a file may have a
+//      "<name>.meta.json" next to it, e.g. report.pdf.meta.json:
+//          {
+//            "content_type": "application/pdf",
+//            "content_disposition": "attachment; filename=\"Q3.pdf\"",
+//            "cache_control": "private, max-age=60",
+//            "headers": {"X-Robots-Tag": "noindex"},
+//            "auth": {"realm": "Reports", "users": {"alice": "s3cret"}}
+//          }
+//      Every field is optional. "auth" asks for HTTP Basic credentials
+//      from the users listed and answers 401 otherwise.
+//    - Sidecar headers are set by the file handler, so the site's
+//      headers (config "headers", _headers) still go on top.
+//    - Sidecars are cached until their size or modification time
+//      changes and are never served themselves.
+//    - A file behind auth (or a broken sidecar) is kept out of
+//      everything that shows content without asking: search results,
+//      the feed and autoindex READMEs, see gated().
+// ─────────────────────────────────────────────────────────────────
+
+var SidecarsEnabled = false
+
+const sidecarSuffix = ".meta.json"
+
+type sidecar struct {
+	ContentType        string            `json:"content_type"`
+	ContentDisposition string            `json:"content_disposition"`
+	CacheControl       string            `json:"cache_control"`
+	Headers            map[string]string `json:"headers"`
+	Auth               *sidecarAuth      `json:"auth"`
+}
+
+type sidecarAuth struct {
+	Realm string            `json:"realm"`
+	Users map[string]string `json:"users"` //name → password
+}
+
+type cachedSidecar struct {
+	modTime time.Time
+	size    int64
+	meta    *sidecar //nil if it didn't parse
+}
+
+var (
+	sidecarsMu sync.Mutex
+	sidecars   = make(map[string]*cachedSidecar) //by sidecar path
+)
+
+// sidecarFor returns the metadata for the file at localPath, or nil.
+// A sidecar that doesn't parse is logged and denies access, rather
+// than serving a protected file without its protection.
+func sidecarFor(localPath string) (*sidecar, error) {
+	if !SidecarsEnabled {
+		return nil, nil
+	}
+	path := localPath + sidecarSuffix
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	sidecarsMu.Lock()
+	defer sidecarsMu.Unlock()
+	c, ok := sidecars[path]
+	if !ok || !c.modTime.Equal(info.ModTime()) || c.size != info.Size() {
+		c = &cachedSidecar{modTime: info.ModTime(), size: info.Size()}
+		if meta, err := parseSidecar(path); err != nil {
+			logWarnf("sidecar", "Reading %s: %v", path, err)
+		} else {
+			c.meta = meta
+		}
+		sidecars[path] = c
+	}
+	if c.meta == nil {
+		return nil, fmt.Errorf("%w: broken sidecar %s", ErrForbidden, path)
+	}
+	return c.meta, nil
+}
+
+// gated reports whether the file at localPath needs credentials: its
+// sidecar has auth, or is broken and so denies everyone.
+func gated(localPath string) bool {
+	meta, err := sidecarFor(localPath)
+	return err != nil || (meta != nil && meta.Auth != nil)
+}
+
+func parseSidecar(path string) (*sidecar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var meta sidecar
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	for name := range meta.Headers {
+		if protectedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			return nil, fmt.Errorf("header %s can't be set", name)
+		}
+	}
+	return &meta, nil
+}
+
+// authorize checks req's Basic credentials against the sidecar's
+// users; on failure it asks for them and returns ErrUnauthorized.
+func (s *sidecar) authorize(w ResponseWriter, req *request) error {
+	if s.Auth == nil {
+		return nil
+	}
+	user, pass, ok := basicAuth(req.header.Get("Authorization"))
+	if ok {
+		want, known := s.Auth.Users[user]
+		if known && hmac.Equal([]byte(pass), []byte(want)) {
+			return nil
+		}
+	}
+	realm := s.Auth.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+	w.Header().Set("Cache-Control", "no-store")
+	return fmt.Errorf("%w: %s", ErrUnauthorized, req.rawPath)
+}
+
+// apply sets the sidecar's headers, over the handler's defaults.
+func (s *sidecar) apply(h Header) {
+	if s.ContentType != "" {
+		h.Set("Content-Type", s.ContentType)
+	}
+	if s.ContentDisposition != "" {
+		h.Set("Content-Disposition", s.ContentDisposition)
+	}
+	if s.CacheControl != "" {
+		h.Set("Cache-Control", s.CacheControl)
+	}
+	for name, value := range s.Headers {
+		h.Set(name, value)
+	}
+}
+
+// basicAuth decodes "Basic <base64 user:pass>".
+func basicAuth(authorization string) (user, pass string, ok bool) {
+	encoded, ok := strings.CutPrefix(authorization, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// isSidecarFile reports whether req asks for a sidecar itself.
+func isSidecarFile(req *request) bool {
+	if !SidecarsEnabled {
+		return false
+	}
+	path := req.path()
+	return strings.HasSuffix(strings.ToLower(filepath.Base(path)), sidecarSuffix)
+}
@@ -0,0 +1,134 @@
+// sidecar_test.go
+
+package main
+
+import (
+	"encoding/base64" //Basic credentials
+	"errors"          //error kinds
+	"io"              //discarding logs
+	"log"             //discarding logs
+	"os"              //sidecar files
+	"path/filepath"   //sidecar files
+	"testing"         //test runner
+)
+
+// withSidecars turns sidecars on for the duration of the test.
+func withSidecars(t *testing.T) {
+	prev := SidecarsEnabled
+	SidecarsEnabled = true
+	t.Cleanup(func() { SidecarsEnabled = prev })
+}
+
+func basic(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+func TestBasicAuth(t *testing.T) {
+	tests := []struct {
+		authorization string
+		user, pass    string
+		ok            bool
+	}{
+		{basic("ann", "s3cret"), "ann", "s3cret", true},
+		{basic("ann", "a:b"), "ann", "a:b", true}, //only the first colon splits
+		{basic("", ""), "", "", true},
+		{"", "", "", false},
+		{"Bearer abc", "", "", false},
+		{"basic " + base64.StdEncoding.EncodeToString([]byte("ann:x")), "", "", false},
+		{"Basic !!!", "", "", false},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("nocolon")), "nocolon", "", false},
+	}
+	for _, tt := range tests {
+		user, pass, ok := basicAuth(tt.authorization)
+		if user != tt.user || pass != tt.pass || ok != tt.ok {
+			t.Errorf("basicAuth(%q) = %q, %q, %v; want %q, %q, %v", tt.authorization, user, pass, ok, tt.user, tt.pass, tt.ok)
+		}
+	}
+}
+
+func TestSidecarAuthorize(t *testing.T) {
+	s := &sidecar{Auth: &sidecarAuth{Realm: "Team", Users: map[string]string{"ann": "s3cret"}}}
+	tests := []struct {
+		name          string
+		authorization string
+		allowed       bool
+	}{
+		{"right password", basic("ann", "s3cret"), true},
+		{"wrong password", basic("ann", "s3cre"), false},
+		{"longer password", basic("ann", "s3cret2"), false},
+		{"unknown user", basic("bob", "s3cret"), false},
+		{"no credentials", "", false},
+		{"not Basic", "Bearer s3cret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRecorder()
+			req := &request{rawPath: "/private/report.pdf", header: Header{}}
+			if tt.authorization != "" {
+				req.header.Set("Authorization", tt.authorization)
+			}
+			err := s.authorize(w, req)
+			if tt.allowed {
+				if err != nil || w.header.Get("WWW-Authenticate") != "" {
+					t.Fatalf("refused: %v, WWW-Authenticate %q", err, w.header.Get("WWW-Authenticate"))
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("got %v, want ErrUnauthorized", err)
+			}
+			if got := w.header.Get("WWW-Authenticate"); got != `Basic realm="Team"` {
+				t.Errorf("WWW-Authenticate %q", got)
+			}
+			if got := w.header.Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control %q", got)
+			}
+		})
+	}
+
+	w := newRecorder()
+	open := &sidecar{ContentType: "text/plain"}
+	if err := open.authorize(w, &request{header: Header{}}); err != nil {
+		t.Errorf("sidecar without auth refused: %v", err)
+	}
+	noRealm := &sidecar{Auth: &sidecarAuth{}}
+	noRealm.authorize(w, &request{header: Header{}})
+	if got := w.header.Get("WWW-Authenticate"); got != `Basic realm="Restricted"` {
+		t.Errorf("default realm: WWW-Authenticate %q", got)
+	}
+}
+
+func TestGated(t *testing.T) {
+	prevLog := logWriter
+	logWriter = log.New(io.Discard, "", 0) //the broken sidecar is logged
+	t.Cleanup(func() { logWriter = prevLog })
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	plain := write("plain.txt", "x")
+	headersOnly := write("headers.txt", "x")
+	write("headers.txt"+sidecarSuffix, `{"cache_control": "no-cache"}`)
+	private := write("private.txt", "x")
+	write("private.txt"+sidecarSuffix, `{"auth": {"users": {"ann": "s3cret"}}}`)
+	broken := write("broken.txt", "x")
+	write("broken.txt"+sidecarSuffix, `{"auth": `)
+
+	if gated(private) {
+		t.Error("sidecars off, but the file with auth is gated")
+	}
+	withSidecars(t)
+	for path, want := range map[string]bool{plain: false, headersOnly: false, private: true, broken: true} {
+		if got := gated(path); got != want {
+			t.Errorf("gated(%s) = %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+	if _, err := sidecarFor(broken); !errors.Is(err, ErrForbidden) {
+		t.Errorf("broken sidecar: got %v, want ErrForbidden", err)
+	}
+}
@@ -0,0 +1,110 @@
+// sitefiles.go
+
+package main
+
+import (
+	"os"            //stat
+	"path/filepath" //file under the root
+	"strings"       //pattern matching
+	"sync"          //cache
+	"time"          //change detection
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Docroot config files
+//    - _redirects, _headers & co. live in a site's root next to the
+//      content they describe. siteFile[T] finds the first of its
+//      names there, parses it and keeps the result until the file's
+//      size or modification time changes.
+//    - pathPattern is the path syntax those files share.
+// ─────────────────────────────────────────────────────────────────
+
+type siteFile[T any] struct {
+	names []string //tried in order
+	parse func(path string) (T, error)
+
+	mu     sync.Mutex
+	parsed map[string]*parsedSiteFile[T] //by site root
+}
+
+type parsedSiteFile[T any] struct {
+	path    string
+	modTime time.Time
+	size    int64
+	value   T
+}
+
+func newSiteFile[T any](parse func(string) (T, error), names ...string) *siteFile[T] {
+	return &siteFile[T]{names: names, parse: parse, parsed: make(map[string]*parsedSiteFile[T])}
+}
+
+// get returns the parsed file under root and its path, or ok=false if
+// there is none. Parse errors are logged; whatever parsed is kept.
+func (f *siteFile[T]) get(root string) (value T, path string, ok bool) {
+	for _, name := range f.names {
+		path := filepath.Join(root, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		p, ok := f.parsed[root]
+		if ok && p.path == path && p.modTime.Equal(info.ModTime()) && p.size == info.Size() {
+			return p.value, path, true
+		}
+		v, err := f.parse(path)
+		if err != nil {
+			logWarnf("sitefile", "Reading %s: %v", path, err)
+		}
+		f.parsed[root] = &parsedSiteFile[T]{path: path, modTime: info.ModTime(), size: info.Size(), value: v}
+		return v, path, true
+	}
+	return value, "", false
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  pathPattern
+//    - "/blog/:year/*": ":name" matches one segment, a trailing "*"
+//      the rest of the path (as "splat", possibly empty). Trailing
+//      slashes don't matter.
+// ─────────────────────────────────────────────────────────────────
+
+type pathPattern []string
+
+func parsePathPattern(s string) pathPattern {
+	return pathPattern(pathSegments(s))
+}
+
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func (p pathPattern) String() string {
+	return "/" + strings.Join(p, "/")
+}
+
+// match returns the placeholder values if path matches.
+func (p pathPattern) match(path string) (map[string]string, bool) {
+	segments := pathSegments(path)
+	params := make(map[string]string)
+	for i, s := range p {
+		if s == "*" && i == len(p)-1 {
+			params["splat"] = strings.Join(segments[min(i, len(segments)):], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if name, ok := strings.CutPrefix(s, ":"); ok && name != "" {
+			params[name] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(p)
+}
@@ -0,0 +1,305 @@
+// slo.go
+
+package main
+
+import (
+	"encoding/json" //admin endpoint + webhook
+	"expvar"        //burn rates next to the other stats
+	"math"          //infinite last bucket
+	"net/http"      //admin endpoint + webhook
+	"path"          //route from path
+	"sort"          //stable route listing
+	"strings"       //query stripping
+	"sync"          //guarding the histograms
+	"time"          //windows
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  SLO settings
+//    - Latency SLO: SLOLatencyObjective of requests finish within
+//      SLOLatencyTarget.
+//    - Availability SLO: SLOAvailabilityObjective of requests don't
+//      end in a 5xx.
+//    - Burn rate = share of bad requests / error budget. 1 means we
+//      use up the budget exactly over the SLO period; we alert when
+//      both the 5 minute and 1 hour burn rate exceed SLOBurnRateAlert
+//      (14.4 = 2% of a 30 day budget gone in one hour).
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	SLOLatencyTarget         = 300 * time.Millisecond
+	SLOLatencyObjective      = 0.99
+	SLOAvailabilityObjective = 0.999
+	SLOBurnRateAlert         = 14.4
+)
+
+// SLOWebhookURL receives a JSON POST when an SLO is burning too fast.
+// Empty disables alerts.
+var SLOWebhookURL = ""
+
+// SLOAlertCooldown stops us from re-sending the same alert every minute.
+var SLOAlertCooldown = 1 * time.Hour
+
+// maxSLORoutes bounds the number of routes we keep histograms for, so
+// scanners hitting random paths can't grow memory without limit.
+const maxSLORoutes = 100
+
+// latencyBuckets are the histogram upper bounds in seconds.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, math.Inf(1)}
+
+// ─────────────────────────────────────────────────────────────────
+//  Per-route histograms
+//    - A route is the first path segment: "/css/style.css" → "/css/",
+//      "/index.html" → "/".
+// ─────────────────────────────────────────────────────────────────
+
+type histogram struct {
+	counts []int64 //one per latencyBuckets entry (not cumulative)
+	count  int64
+	sum    float64 //seconds
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, upper := range latencyBuckets {
+		if seconds <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// quantile estimates the q-th quantile (0..1) by linear interpolation
+// inside the bucket it falls in, like Prometheus' histogram_quantile.
+func (h *histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var seen float64
+	lower := 0.0
+	for i, upper := range latencyBuckets {
+		c := float64(h.counts[i])
+		if seen+c >= rank && c > 0 {
+			if math.IsInf(upper, 1) {
+				return lower //can't interpolate into infinity
+			}
+			return lower + (upper-lower)*(rank-seen)/c
+		}
+		seen += c
+		lower = upper
+	}
+	return lower
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Burn rate windows
+//    - One slot per minute for the last hour, each counting total,
+//      5xx and too-slow requests.
+// ─────────────────────────────────────────────────────────────────
+
+type sloSlot struct {
+	minute int64 //unix minute this slot belongs to
+	total  int64
+	errors int64
+	slow   int64
+}
+
+var (
+	sloMu        sync.Mutex
+	routeHists   = make(map[string]*histogram)
+	sloSlots     [60]sloSlot
+	lastSLOAlert = make(map[string]time.Time)
+)
+
+func routeFor(rawPath string) string {
+	p, _, _ := strings.Cut(rawPath, "?")
+	p = path.Clean("/" + p)
+	if i := strings.Index(p[1:], "/"); i >= 0 {
+		return p[:i+2]
+	}
+	return "/"
+}
+
+// observeRoute records one finished request for the SLOs.
+func observeRoute(rawPath string, statusCode int, duration time.Duration) {
+	route := routeFor(rawPath)
+	minute := time.Now().Unix() / 60
+
+	sloMu.Lock()
+	defer sloMu.Unlock()
+
+	h, ok := routeHists[route]
+	if !ok {
+		if len(routeHists) >= maxSLORoutes {
+			route = "other"
+			h = routeHists[route]
+		}
+		if h == nil {
+			h = newHistogram()
+			routeHists[route] = h
+		}
+	}
+	h.observe(duration.Seconds())
+
+	slot := &sloSlots[minute%60]
+	if slot.minute != minute {
+		*slot = sloSlot{minute: minute}
+	}
+	slot.total++
+	if statusCode >= 500 {
+		slot.errors++
+	}
+	if duration > SLOLatencyTarget {
+		slot.slow++
+	}
+}
+
+// sloWindow sums the slots of the last `minutes` minutes.
+func sloWindow(minutes int64) (total, errors, slow int64) {
+	now := time.Now().Unix() / 60
+	sloMu.Lock()
+	defer sloMu.Unlock()
+	for _, slot := range sloSlots {
+		if slot.total > 0 && now-slot.minute < minutes {
+			total += slot.total
+			errors += slot.errors
+			slow += slot.slow
+		}
+	}
+	return total, errors, slow
+}
+
+// burnRates returns the availability and latency burn rates over the
+// last `minutes` minutes.
+func burnRates(minutes int64) (availability, latency float64) {
+	total, errors, slow := sloWindow(minutes)
+	if total == 0 {
+		return 0, 0
+	}
+	availability = (float64(errors) / float64(total)) / (1 - SLOAvailabilityObjective)
+	latency = (float64(slow) / float64(total)) / (1 - SLOLatencyObjective)
+	return availability, latency
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  sloReport()
+//    - What /slo on the admin listener and expvar's helix_slo show.
+// ─────────────────────────────────────────────────────────────────
+
+type routeLatency struct {
+	Route string  `json:"route"`
+	Count int64   `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+func sloReport() map[string]any {
+	sloMu.Lock()
+	routes := make([]routeLatency, 0, len(routeHists))
+	for route, h := range routeHists {
+		r := routeLatency{Route: route, Count: h.count}
+		if h.count > 0 {
+			r.Mean = h.sum / float64(h.count) * 1000
+		}
+		r.P50, r.P90, r.P99 = h.quantile(0.5)*1000, h.quantile(0.9)*1000, h.quantile(0.99)*1000
+		routes = append(routes, r)
+	}
+	sloMu.Unlock()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+
+	avail5, lat5 := burnRates(5)
+	avail60, lat60 := burnRates(60)
+	return map[string]any{
+		"objectives": map[string]any{
+			"latency_target_ms":      SLOLatencyTarget.Milliseconds(),
+			"latency_objective":      SLOLatencyObjective,
+			"availability_objective": SLOAvailabilityObjective,
+			"burn_rate_alert":        SLOBurnRateAlert,
+		},
+		"burn_rate": map[string]any{
+			"availability_5m": avail5,
+			"availability_1h": avail60,
+			"latency_5m":      lat5,
+			"latency_1h":      lat60,
+		},
+		"routes": routes,
+	}
+}
+
+func init() {
+	adminMux.HandleFunc("/slo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sloReport())
+	})
+	expvar.Publish("helix_slo", expvar.Func(func() any { return sloReport() }))
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  startSLOMonitor()
+//    - Once a minute, checks both burn rates and POSTs to the webhook
+//      when one is above SLOBurnRateAlert in both windows.
+// ─────────────────────────────────────────────────────────────────
+
+func startSLOMonitor() {
+	if SLOWebhookURL == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			checkSLOs()
+		}
+	}()
+}
+
+func checkSLOs() {
+	avail5, lat5 := burnRates(5)
+	avail60, lat60 := burnRates(60)
+	if avail5 > SLOBurnRateAlert && avail60 > SLOBurnRateAlert {
+		fireSLOAlert("availability", avail5, avail60)
+	}
+	if lat5 > SLOBurnRateAlert && lat60 > SLOBurnRateAlert {
+		fireSLOAlert("latency", lat5, lat60)
+	}
+}
+
+func fireSLOAlert(slo string, burn5m, burn1h float64) {
+	sloMu.Lock()
+	if time.Since(lastSLOAlert[slo]) < SLOAlertCooldown {
+		sloMu.Unlock()
+		return
+	}
+	lastSLOAlert[slo] = time.Now()
+	sloMu.Unlock()
+
+	logWarnf("slo", "%s SLO burning too fast: %.1fx over 5m, %.1fx over 1h", slo, burn5m, burn1h)
+	body, _ := json.Marshal(map[string]any{
+		"slo":          slo,
+		"burn_rate_5m": burn5m,
+		"burn_rate_1h": burn1h,
+		"threshold":    SLOBurnRateAlert,
+		"time":         time.Now().UTC().Format(time.RFC3339),
+	})
+	resp, err := outboundDo(outboundRequest{
+		target:      "slo_webhook",
+		method:      "POST",
+		url:         SLOWebhookURL,
+		contentType: "application/json",
+		body:        body,
+		retries:     2,
+	})
+	if err != nil {
+		logErrorf("slo", "SLO webhook failed: %v", err)
+		return
+	}
+	if resp.status >= 300 {
+		logErrorf("slo", "SLO webhook answered %d", resp.status)
+	}
+}
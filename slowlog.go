@@ -0,0 +1,80 @@
+// slowlog.go
+
+package main
+
+import (
+	"crypto/rand"  //request IDs
+	"encoding/hex" //request IDs
+	"fmt"          //human sizes
+	"time"         //budgets
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Latency and size budgets
+//    - Requests slower than SlowRequestThreshold or responses bigger
+//      than LargeResponseThreshold get a WARN line with the request ID
+//      and where the time went, so problems show up before users
+//      complain. 0 switches a check off.
+// ─────────────────────────────────────────────────────────────────
+
+var SlowRequestThreshold = 1 * time.Second
+
+var LargeResponseThreshold int64 = 50 << 20 //50 MiB
+
+// requestTiming marks when each phase of a request finished.
+type requestTiming struct {
+	start   time.Time //connection picked up
+	parsed  time.Time //request line + headers read
+	handled time.Time //handler returned
+	done    time.Time //response flushed to the client
+}
+
+// newRequestID returns 16 random hex characters. It is sent back as
+// X-Request-Id and shows up in the WARN lines below.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  checkRequestBudgets(req, statusCode, bytes)
+//    - Called once the response has been flushed.
+// ─────────────────────────────────────────────────────────────────
+
+func checkRequestBudgets(req *request, statusCode int, bytes int64) {
+	t := req.timing
+	total := t.done.Sub(t.start)
+
+	if SlowRequestThreshold > 0 && total > SlowRequestThreshold {
+		logWarnf("slowlog", "Slow request id=%s – %s – %q – %d took %s (read %s, handle %s, write %s)",
+			req.id, logAddr(req.clientAddr), req.requestLine, statusCode,
+			roundDuration(total),
+			roundDuration(t.parsed.Sub(t.start)),
+			roundDuration(t.handled.Sub(t.parsed)),
+			roundDuration(t.done.Sub(t.handled)),
+		)
+	}
+	if LargeResponseThreshold > 0 && bytes > LargeResponseThreshold {
+		logWarnf("slowlog", "Large response id=%s – %s – %q – %d sent %s in %s",
+			req.id, logAddr(req.clientAddr), req.requestLine, statusCode, humanBytes(bytes), roundDuration(total))
+	}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+// humanBytes: 52428800 → "50.0 MiB"
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
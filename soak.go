@@ -0,0 +1,213 @@
+// soak.go
+
+package main
+
+import (
+	"bufio"       //reading responses
+	"flag"        //subcommand flags
+	"fmt"         //the report
+	"io"          //discarding logs
+	"log"         //logger for the soak server
+	"net"         //listener + load clients
+	"runtime"     //goroutines + heap
+	"sort"        //stable report
+	"strings"     //status line parsing
+	"sync"        //workers
+	"sync/atomic" //counters
+	"time"        //duration + deadlines
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  helix soak [-duration 30s] [-concurrency 16] [-shed-every 10] ...
+//    - Runs the real accept loop and handlers on a loopback port and
+//      hammers them with a fixed mix of requests (pages, assets, 404s,
+//      traversal attempts, garbage request lines) for -duration.
+//    - Per-client rate limits are off for the run (all load comes from
+//      127.0.0.1) and every -shed-every'th request is shed with a 503,
+//      so the overload path is covered deterministically.
+//    - Afterwards, once things had -settle time to wind down, compares
+//      goroutines, open fds and live heap with the baseline and fails
+//      if any grew by more than allowed. Exit status 0 = pass.
+// ─────────────────────────────────────────────────────────────────
+
+// soakRunning switches per-client limits off while a soak runs.
+var soakRunning atomic.Bool
+
+// soakRequests is the request mix, used round robin.
+var soakRequests = []string{
+	"GET / HTTP/1.1\r\nHost: soak\r\nConnection: close\r\n\r\n",
+	"GET /index.html HTTP/1.1\r\nHost: soak\r\nConnection: close\r\nAccept: text/html\r\n\r\n",
+	"GET /css/style.css HTTP/1.1\r\nHost: soak\r\nConnection: close\r\n\r\n",
+	"GET /js/app.js HTTP/1.1\r\nHost: soak\r\nConnection: close\r\n\r\n",
+	"GET /missing.html HTTP/1.1\r\nHost: soak\r\nConnection: close\r\nAccept: application/json\r\n\r\n",
+	"GET /../../etc/passwd HTTP/1.1\r\nHost: soak\r\nConnection: close\r\n\r\n",
+	"POST /index.html HTTP/1.1\r\nHost: soak\r\nConnection: close\r\n\r\n",
+	"garbage\r\n\r\n",
+}
+
+type soakSample struct {
+	goroutines int
+	fds        int //-1 where we can't count them
+	heap       uint64
+}
+
+func takeSoakSample() soakSample {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return soakSample{goroutines: runtime.NumGoroutine(), fds: openFDs(), heap: m.HeapAlloc}
+}
+
+func runSoak(args []string) int {
+	fs := flag.NewFlagSet("soak", flag.ContinueOnError)
+	duration := fs.Duration("duration", 30*time.Second, "how long to generate load")
+	concurrency := fs.Int("concurrency", 16, "parallel clients")
+	shed := fs.Int64("shed-every", 10, "shed every n-th request with a 503 (0 = never)")
+	settle := fs.Duration("settle", 5*time.Second, "how long to wait for things to wind down")
+	maxGoroutines := fs.Int("max-goroutine-growth", 10, "allowed goroutine growth")
+	maxFDs := fs.Int("max-fd-growth", 10, "allowed open file growth")
+	maxHeapMB := fs.Int("max-heap-growth-mb", 32, "allowed live heap growth in MiB")
+	logPath := fs.String("log", "", "write the server log here (default: discard)")
+	fs.StringVar(&DocRoot, "root", DefaultRoot, "directory to serve files from")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	logWriter = log.New(io.Discard, "", 0)
+	if *logPath != "" {
+		lf, err := openLogFile(*logPath)
+		if err != nil {
+			fmt.Printf("Could not open %s: %v\n", *logPath, err)
+			return 2
+		}
+		defer lf.Close()
+		logWriter = log.New(lf, "", 0)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Printf("Could not listen: %v\n", err)
+		return 2
+	}
+	defer listener.Close()
+	go acceptLoop(listener)
+	addr := listener.Addr().String()
+
+	soakRunning.Store(true)
+	shedEvery.Store(*shed)
+	defer func() {
+		soakRunning.Store(false)
+		shedEvery.Store(0)
+	}()
+
+	before := takeSoakSample()
+	fmt.Printf("Soaking %s for %s with %d clients (docroot %s)\n", addr, *duration, *concurrency, DocRoot)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = make(map[string]int64)
+		failures atomic.Int64
+		next     atomic.Int64
+	)
+	deadline := time.Now().Add(*duration)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int64)
+			for time.Now().Before(deadline) {
+				raw := soakRequests[next.Add(1)%int64(len(soakRequests))]
+				status, err := soakRequest(addr, raw)
+				if err != nil {
+					failures.Add(1)
+					continue
+				}
+				local[status]++
+			}
+			mu.Lock()
+			for status, n := range local {
+				statuses[status] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	//Give handlers, timers and the GC a moment, then measure again
+	//(retrying a few times so slow wind-down isn't reported as a leak)
+	var after soakSample
+	for wait := time.Now().Add(*settle); ; {
+		after = takeSoakSample()
+		if after.goroutines-before.goroutines <= *maxGoroutines || time.Now().After(wait) {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	var total int64
+	codes := make([]string, 0, len(statuses))
+	for status, n := range statuses {
+		codes = append(codes, status)
+		total += n
+	}
+	sort.Strings(codes)
+	fmt.Printf("Requests: %d (%.0f/s), client errors: %d\n", total, float64(total)/duration.Seconds(), failures.Load())
+	for _, status := range codes {
+		fmt.Printf("  %s: %d\n", status, statuses[status])
+	}
+
+	pass := true
+	check := func(name string, before, after, allowed int64, unit string) {
+		verdict := "ok"
+		if after-before > allowed {
+			verdict = "LEAK?"
+			pass = false
+		}
+		fmt.Printf("%-11s %d → %d%s (allowed growth %d) %s\n", name+":", before, after, unit, allowed, verdict)
+	}
+	check("goroutines", int64(before.goroutines), int64(after.goroutines), int64(*maxGoroutines), "")
+	if before.fds >= 0 {
+		check("open fds", int64(before.fds), int64(after.fds), int64(*maxFDs), "")
+	}
+	check("live heap", int64(before.heap>>20), int64(after.heap>>20), int64(*maxHeapMB), " MiB")
+	if total == 0 {
+		fmt.Println("No request got an answer")
+		pass = false
+	}
+
+	if !pass {
+		fmt.Println("FAIL")
+		return 1
+	}
+	fmt.Println("PASS")
+	return 0
+}
+
+// soakRequest sends one raw request on a fresh connection and returns
+// the status code of the answer ("dropped" if the server just closed).
+func soakRequest(addr, raw string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		return "", err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "dropped", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	io.Copy(io.Discard, r)
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return "", fmt.Errorf("bad status line %q", line)
+	}
+	return parts[1], nil
+}
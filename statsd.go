@@ -0,0 +1,132 @@
+// statsd.go
+
+package main
+
+import (
+	"fmt"     //metric lines
+	"net"     //UDP socket
+	"strings" //tag handling
+	"time"    //flush interval
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  StatsD / DogStatsD push exporter
+//    - Every StatsDFlushInterval we send the counter deltas and the
+//      timing samples of the last window over UDP.
+//    - DogStatsD (Datadog agent) gets tags as "|#vhost:x,status_class:2xx";
+//      plain StatsD has no tags, so they are folded into the name
+//      (helix.requests.vhost.x.status_class.2xx).
+// ─────────────────────────────────────────────────────────────────
+
+// StatsDAddr is the host:port of the StatsD/DogStatsD agent. Empty
+// disables the exporter.
+var StatsDAddr = ""
+
+// StatsDFlavor is "dogstatsd" or "statsd".
+var StatsDFlavor = "dogstatsd"
+
+// StatsDPrefix is put in front of every metric name.
+var StatsDPrefix = "helix."
+
+// StatsDFlushInterval is how often we push.
+var StatsDFlushInterval = 10 * time.Second
+
+// StatsDTags are added to every metric (DogStatsD only), e.g. "env:prod".
+var StatsDTags = []string{}
+
+// statsdMaxPacket keeps datagrams below a typical Ethernet MTU.
+const statsdMaxPacket = 1432
+
+// ─────────────────────────────────────────────────────────────────
+//  startStatsD()
+//    - Runs the flush loop in the background. UDP "connect" doesn't
+//      talk to the agent, so a missing agent never blocks requests.
+// ─────────────────────────────────────────────────────────────────
+
+func startStatsD() {
+	if StatsDAddr == "" {
+		return
+	}
+	conn, err := net.Dial("udp", StatsDAddr)
+	if err != nil {
+		logErrorf("statsd", "StatsD exporter disabled, could not dial %s: %v", StatsDAddr, err)
+		return
+	}
+	go func() {
+		last := make(map[metricKey]int64)
+		for range time.Tick(StatsDFlushInterval) {
+			last = flushStatsD(conn, last)
+		}
+	}()
+}
+
+// flushStatsD sends one window and returns the counter values it was
+// based on, for the next delta.
+func flushStatsD(conn net.Conn, last map[metricKey]int64) map[metricKey]int64 {
+	var lines []string
+
+	current := counterSnapshot()
+	for key, value := range current {
+		if delta := value - last[key]; delta != 0 {
+			lines = append(lines, statsdLine(key, fmt.Sprintf("%d|c", delta)))
+		}
+	}
+
+	for key, t := range takeTimings() {
+		rate := ""
+		if t.seen > int64(len(t.samples)) {
+			rate = fmt.Sprintf("|@%.4f", float64(len(t.samples))/float64(t.seen))
+		}
+		for _, d := range t.samples {
+			lines = append(lines, statsdLine(key, fmt.Sprintf("%.3f|ms%s", float64(d.Microseconds())/1000, rate)))
+		}
+	}
+
+	//Pack as many lines as fit into each datagram
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			conn.Write([]byte(packet.String()))
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		conn.Write([]byte(packet.String()))
+	}
+	return current
+}
+
+// statsdLine formats "<name>:<value>" plus tags for the chosen flavor.
+func statsdLine(key metricKey, value string) string {
+	tags := append([]string(nil), StatsDTags...)
+	if key.tags != "" {
+		tags = append(tags, strings.Split(key.tags, ",")...)
+	}
+
+	if StatsDFlavor == "statsd" {
+		name := StatsDPrefix + key.name
+		for _, tag := range strings.Split(key.tags, ",") {
+			if tag == "" {
+				continue
+			}
+			k, v, _ := strings.Cut(tag, ":")
+			name += "." + statsdSafe(k) + "." + statsdSafe(v)
+		}
+		return name + ":" + value
+	}
+
+	line := StatsDPrefix + key.name + ":" + value
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsdSafe keeps dots and colons out of name segments.
+func statsdSafe(s string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_").Replace(s)
+}
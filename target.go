@@ -0,0 +1,65 @@
+// target.go
+
+package main
+
+import (
+	"net/url" //decoding
+	"strings" //splitting
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Request targets
+//    - req.rawPath is the target as sent, "/my%20file.html?x=1";
+//      parseRequestLine() already turned down malformed escapes.
+//    - req.path() is its path, percent-decoded: "/my file.html". That
+//      is what files are looked up by, so "%20" finds "my file.html"
+//      and "%2e%2e" is as much ".." as ".." (see sanitizePath).
+//    - req.cleanPath() is req.path() as file lookup sees it, after
+//      sanitizePath(): "//a/./b/../c.iso" is "/a/c.iso". Anything
+//      matching path prefixes (route limits, mirrors, routes) goes by
+//      it, so another spelling of a path can't slip past its rule.
+//    - req.query() is the query string parsed, for handlers to read
+//      parameters from: req.query().Get("q"). A malformed pair is
+//      dropped and the rest kept, like url.ParseQuery does.
+//    - Both are worked out from rawPath on each call, so they follow
+//      internal redirects, which just swap rawPath.
+//    - Paths going back out in a Location or Link header are escaped
+//      again with escapePath().
+// ─────────────────────────────────────────────────────────────────
+
+// splitTarget splits target into its decoded path and raw query.
+func splitTarget(target string) (path, rawQuery string) {
+	path, rawQuery, _ = strings.Cut(target, "?")
+	if decoded, err := url.PathUnescape(path); err == nil {
+		path = decoded
+	}
+	return path, rawQuery
+}
+
+func (r *request) path() string {
+	path, _ := splitTarget(r.rawPath)
+	return path
+}
+
+func (r *request) cleanPath() string {
+	path := r.path()
+	cleaned, err := sanitizePath(path)
+	if err != nil {
+		return path //turned down by resolveStatic() anyway
+	}
+	if strings.HasSuffix(path, "/") && cleaned != "/" {
+		cleaned += "/" //a directory stays one
+	}
+	return cleaned
+}
+
+func (r *request) query() url.Values {
+	_, rawQuery, _ := strings.Cut(r.rawPath, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	return query
+}
+
+// escapePath is path ("/my file.html") as it goes in a URL.
+func escapePath(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
@@ -0,0 +1,294 @@
+// torrent.go
+
+package main
+
+import (
+	"bytes"         //bencoding
+	"crypto/sha1"   //torrent pieces
+	"crypto/sha256" //metalink hash
+	"encoding/hex"  //metalink hashes
+	"encoding/xml"  //metalink documents
+	"fmt"           //errors, bencoding
+	"io"            //hashing the file
+	"os"            //reading the file
+	"path"          //URL paths
+	"sort"          //bencoded keys are sorted
+	"strings"       //suffix checks
+	"sync"          //hash cache
+	"time"          //change detection
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  .torrent and Metalink descriptors (off unless TorrentDir is set)
+//    - For every file under TorrentDir (a URL path, e.g. "/releases/")
+//      two descriptors are generated on request:
+//          /releases/big.iso.torrent   BitTorrent, with the file's own
+//                                      URL as a web seed (BEP 19)
+//          /releases/big.iso.meta4     Metalink 4 (RFC 5854), SHA-256
+//                                      of the file and of each piece
+//      so resumable multi-source clients can fetch large artifacts
+//      from peers and mirrors and verify them, instead of pulling
+//      everything from us. A real file by those names wins.
+//    - The file itself gets a "Link: <…meta4>; rel=describedby"
+//      header (RFC 6249) so Metalink-aware clients find it.
+//    - Hashing reads the whole file once; the result is cached until
+//      its size or modification time changes.
+//    - URLs are absolute: TorrentBaseURL, or else http://<Host>.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	TorrentDir       = ""
+	TorrentBaseURL   = ""             //e.g. "https://downloads.example.com"
+	TorrentTrackers  = []string{}     //announce URLs, optional with web seeds
+	TorrentPieceSize = int64(1 << 20) //bytes per piece
+)
+
+const (
+	torrentSuffix  = ".torrent"
+	metalinkSuffix = ".meta4"
+)
+
+// fileHashes is what both descriptors need, from one pass over a file.
+type fileHashes struct {
+	modTime     time.Time
+	size        int64
+	pieceLength int64
+	sha1Pieces  [][sha1.Size]byte
+	sha256      [sha256.Size]byte
+	sha256Piece [][sha256.Size]byte
+}
+
+var (
+	fileHashesMu sync.Mutex
+	fileHashesBy = make(map[string]*fileHashes) //by local path
+)
+
+// torrentTargetFor splits a descriptor URL into the file it describes
+// and the descriptor's suffix.
+func torrentTargetFor(rawPath string) (filePath, suffix string, ok bool) {
+	if TorrentDir == "" {
+		return "", "", false
+	}
+	urlPath, _, _ := strings.Cut(rawPath, "?")
+	if !strings.HasPrefix(urlPath, strings.TrimSuffix(TorrentDir, "/")+"/") {
+		return "", "", false
+	}
+	for _, suffix := range []string{torrentSuffix, metalinkSuffix} {
+		if filePath, ok := strings.CutSuffix(urlPath, suffix); ok {
+			return filePath, suffix, true
+		}
+	}
+	return "", "", false
+}
+
+// isTorrentDescriptor reports whether req asks for a generated
+// descriptor (and there is no real file by that name).
+func isTorrentDescriptor(req *request) bool {
+	if _, _, ok := torrentTargetFor(req.rawPath); !ok {
+		return false
+	}
+	_, err := resolveStatic(req.site, req.rawPath)
+	return err != nil
+}
+
+func serveTorrentDescriptor(w ResponseWriter, req *request) error {
+	if !readMethod(req.method) {
+		return onlyMethods("GET", "HEAD")
+	}
+	filePath, suffix, _ := torrentTargetFor(req.rawPath)
+	target, err := resolveStatic(req.site, filePath)
+	if err != nil {
+		return err
+	}
+	if target.redirect != "" || target.listing || path.Base(target.cleanPath) != path.Base(filePath) {
+		return ErrNotFound //only plain files, not index pages or listings
+	}
+	hashes, err := hashFile(target.localPath, target.info)
+	if err != nil {
+		return err
+	}
+
+	fileURL := torrentBaseURL(req) + target.cleanPath
+	name := path.Base(target.cleanPath)
+	w.Header().Set("Last-Modified", httpDate(target.info.ModTime()))
+	if suffix == torrentSuffix {
+		writeBody(w, 200, "application/x-bittorrent", buildTorrent(name, fileURL, hashes))
+		return nil
+	}
+	body, err := buildMetalink(name, fileURL, hashes)
+	if err != nil {
+		return err
+	}
+	writeBody(w, 200, "application/metalink4+xml", body)
+	return nil
+}
+
+// describedByLink is the Link header for a file under TorrentDir.
+func describedByLink(cleanPath string) string {
+	if TorrentDir == "" || !strings.HasPrefix(cleanPath, strings.TrimSuffix(TorrentDir, "/")+"/") {
+		return ""
+	}
+	return "<" + path.Base(cleanPath) + metalinkSuffix + `>; rel=describedby; type="application/metalink4+xml"`
+}
+
+func torrentBaseURL(req *request) string {
+	if TorrentBaseURL != "" {
+		return strings.TrimSuffix(TorrentBaseURL, "/")
+	}
+	return "http://" + req.header.Get("Host")
+}
+
+// hashFile returns the piece and file hashes of localPath, from the
+// cache if the file hasn't changed.
+func hashFile(localPath string, info os.FileInfo) (*fileHashes, error) {
+	pieceLength := max(TorrentPieceSize, 16<<10)
+	fileHashesMu.Lock()
+	h, ok := fileHashesBy[localPath]
+	fileHashesMu.Unlock()
+	if ok && h.modTime.Equal(info.ModTime()) && h.size == info.Size() && h.pieceLength == pieceLength {
+		return h, nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: open %s: %v", ErrForbidden, localPath, err)
+	}
+	defer f.Close()
+	h = &fileHashes{modTime: info.ModTime(), size: info.Size(), pieceLength: pieceLength}
+	whole := sha256.New()
+	buf := make([]byte, pieceLength)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			whole.Write(buf[:n])
+			h.sha1Pieces = append(h.sha1Pieces, sha1.Sum(buf[:n]))
+			h.sha256Piece = append(h.sha256Piece, sha256.Sum256(buf[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", localPath, err)
+		}
+	}
+	copy(h.sha256[:], whole.Sum(nil))
+
+	fileHashesMu.Lock()
+	fileHashesBy[localPath] = h
+	fileHashesMu.Unlock()
+	logInfof("torrent", "Hashed %s (%d bytes, %d pieces)", localPath, h.size, len(h.sha1Pieces))
+	return h, nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  .torrent (bencoded, single file, BitTorrent v1)
+// ─────────────────────────────────────────────────────────────────
+
+func buildTorrent(name, fileURL string, h *fileHashes) []byte {
+	pieces := make([]byte, 0, len(h.sha1Pieces)*sha1.Size)
+	for _, p := range h.sha1Pieces {
+		pieces = append(pieces, p[:]...)
+	}
+	torrent := map[string]any{
+		"info": map[string]any{
+			"name":         name,
+			"length":       h.size,
+			"piece length": h.pieceLength,
+			"pieces":       string(pieces),
+		},
+		"url-list":      []any{fileURL},
+		"created by":    "Helix " + version,
+		"creation date": h.modTime.Unix(),
+	}
+	if len(TorrentTrackers) > 0 {
+		torrent["announce"] = TorrentTrackers[0]
+		tiers := make([]any, len(TorrentTrackers))
+		for i, t := range TorrentTrackers {
+			tiers[i] = []any{t}
+		}
+		torrent["announce-list"] = tiers
+	}
+	var buf bytes.Buffer
+	bencode(&buf, torrent)
+	return buf.Bytes()
+}
+
+// bencode writes v, made of strings, int64s, lists and dictionaries.
+func bencode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			bencode(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			bencode(buf, k)
+			bencode(buf, v[k])
+		}
+		buf.WriteByte('e')
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Metalink 4
+// ─────────────────────────────────────────────────────────────────
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkFile struct {
+	Name   string         `xml:"name,attr"`
+	Size   int64          `xml:"size"`
+	Hash   metalinkHash   `xml:"hash"`
+	Pieces metalinkPieces `xml:"pieces"`
+	URLs   []string       `xml:"url"`
+}
+
+type metalinkPieces struct {
+	Length int64          `xml:"length,attr"`
+	Type   string         `xml:"type,attr"`
+	Hashes []metalinkHash `xml:"hash"`
+}
+
+type metalinkDoc struct {
+	XMLName   xml.Name     `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Generator string       `xml:"generator"`
+	Published string       `xml:"published"`
+	File      metalinkFile `xml:"file"`
+}
+
+func buildMetalink(name, fileURL string, h *fileHashes) ([]byte, error) {
+	doc := metalinkDoc{
+		Generator: "Helix/" + version,
+		Published: h.modTime.UTC().Format(time.RFC3339),
+		File: metalinkFile{
+			Name:   name,
+			Size:   h.size,
+			Hash:   metalinkHash{Type: "sha-256", Value: hex.EncodeToString(h.sha256[:])},
+			Pieces: metalinkPieces{Length: h.pieceLength, Type: "sha-256"},
+			URLs:   []string{fileURL},
+		},
+	}
+	for _, p := range h.sha256Piece {
+		doc.File.Pieces.Hashes = append(doc.File.Pieces.Hashes, metalinkHash{Value: hex.EncodeToString(p[:])})
+	}
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
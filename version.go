@@ -0,0 +1,50 @@
+// version.go
+
+package main
+
+import (
+	"fmt"           //output
+	"runtime"       //Go version, platform
+	"runtime/debug" //VCS info embedded by go build
+)
+
+// version is set at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0"
+var version = "dev"
+
+// buildRevision is the VCS commit the binary was built from, with a
+// "-dirty" suffix for uncommitted changes, or "" if unknown.
+func buildRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var rev, dirty string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				dirty = "-dirty"
+			}
+		}
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if rev == "" {
+		return ""
+	}
+	return rev + dirty
+}
+
+func runVersion(args []string) int {
+	fmt.Printf("helix %s", version)
+	if rev := buildRevision(); rev != "" {
+		fmt.Printf(" (%s)", rev)
+	}
+	fmt.Printf(" %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
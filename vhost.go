@@ -0,0 +1,234 @@
+// vhost.go
+
+package main
+
+import (
+	"fmt"     //config errors
+	"log"     //per-host request logs
+	"sort"    //stable order
+	"strconv" //error page codes
+	"strings" //host names
+	"sync"    //log file registry
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Virtual hosts
+//    - Vhosts maps a host name to its own document root, index file,
+//      SPA fallback, autoindex, pretty URLs, case folding, error pages
+//      and request log. The Host header picks the vhost; anything
+//      unknown (or no Host at all) gets the default site from the
+//      top-level settings.
+//    - "*.example.com" matches any subdomain; an exact name wins over
+//      a wildcard. Aliases are more names for the same vhost.
+//    - Unset fields fall back to the top-level setting (index, MIME
+//      types, error pages, listing template). Vhosts are part of the
+//      site snapshot, so they reload with SIGHUP like the rest.
+//    - Docroot sync, the disk watchdog and warm-up only look at the
+//      default root.
+//
+//      vhosts:
+//        docs.example.com:
+//          root: /srv/docs
+//          aliases: [www.docs.example.com]
+//          log: /var/log/helix/docs.log
+//          error_pages:
+//            404: /missing.html
+// ─────────────────────────────────────────────────────────────────
+
+type vhostConfig struct {
+	Root        string
+	Index       string         //"" = IndexFile
+	SPAFallback string         //"" = none
+	Autoindex   bool           //directory listings
+	PrettyURLs  bool           ///about serves about.html
+	CaseFold    bool           //case-insensitive paths
+	ErrorPages  map[int]string //nil = ErrorDocuments
+	LogFile     string         //request log, "" = server.log
+	Aliases     []string       //more names for this host
+}
+
+// Vhosts by primary host name, see setVhost.
+var Vhosts = map[string]vhostConfig{}
+
+// siteFor picks the vhost for a host as normalizeHost returns it.
+func (s *siteSettings) siteFor(host string) *siteSettings {
+	if len(s.vhosts) == 0 || host == "" {
+		return s
+	}
+	if v, ok := s.vhosts[host]; ok {
+		return v
+	}
+	for dot := strings.IndexByte(host, '.'); dot >= 0; dot = strings.IndexByte(host, '.') {
+		host = host[dot+1:]
+		if v, ok := s.vhosts["*."+host]; ok {
+			return v
+		}
+	}
+	return s
+}
+
+// vhostSites builds the per-host snapshots on top of the default site.
+func vhostSites(base *siteSettings) map[string]*siteSettings {
+	sites := make(map[string]*siteSettings)
+	for name, v := range Vhosts {
+		site := &siteSettings{
+			root:        v.Root,
+			index:       v.Index,
+			spaFallback: v.SPAFallback,
+			autoindex:   v.Autoindex,
+			prettyURLs:  v.PrettyURLs,
+			caseFold:    v.CaseFold,
+			mime:        base.mime,
+			errorDocs:   v.ErrorPages,
+			retryAfter:  base.retryAfter,
+			listing:     base.listing,
+			readme:      base.readme,
+			loaded:      base.loaded,
+			host:        name,
+			requestLog:  vhostLogWriter(v.LogFile),
+		}
+		if site.index == "" {
+			site.index = base.index
+		}
+		if site.errorDocs == nil {
+			site.errorDocs = base.errorDocs
+		}
+		for _, host := range append([]string{name}, v.Aliases...) {
+			sites[strings.ToLower(host)] = site
+		}
+	}
+	return sites
+}
+
+// checkVhosts rejects vhosts we can't serve.
+func checkVhosts() error {
+	names := make([]string, 0, len(Vhosts))
+	for name := range Vhosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seen := make(map[string]string)
+	for _, name := range names {
+		v := Vhosts[name]
+		if err := checkRoot(v.Root); err != nil {
+			return fmt.Errorf("vhost %s: %w", name, err)
+		}
+		for _, host := range append([]string{name}, v.Aliases...) {
+			host = strings.ToLower(host)
+			if other, dup := seen[host]; dup {
+				return fmt.Errorf("vhost %s: %s is already used by %s", name, host, other)
+			}
+			seen[host] = name
+		}
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Per-vhost request logs
+//    - Opened when the site snapshot is built and kept open; SIGUSR1
+//      reopens them along with server.log.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	vhostLogsMu sync.Mutex
+	vhostLogs   = make(map[string]*logFile) //by path
+)
+
+// openVhostLogs opens the request log of every vhost that has one.
+func openVhostLogs() error {
+	vhostLogsMu.Lock()
+	defer vhostLogsMu.Unlock()
+	for name, v := range Vhosts {
+		if v.LogFile == "" || vhostLogs[v.LogFile] != nil {
+			continue
+		}
+		lf, err := openLogFile(v.LogFile)
+		if err != nil {
+			return fmt.Errorf("vhost %s: %w", name, err)
+		}
+		vhostLogs[v.LogFile] = lf
+	}
+	return nil
+}
+
+func vhostLogWriter(path string) *log.Logger {
+	vhostLogsMu.Lock()
+	defer vhostLogsMu.Unlock()
+	if lf := vhostLogs[path]; lf != nil {
+		return log.New(lf, "", 0)
+	}
+	return nil
+}
+
+func reopenVhostLogs() {
+	vhostLogsMu.Lock()
+	defer vhostLogsMu.Unlock()
+	for _, lf := range vhostLogs {
+		reopenLogs(lf)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Config: vhosts.<host>.<field>
+// ─────────────────────────────────────────────────────────────────
+
+func setVhost(host string, v any) error {
+	fields, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a mapping of settings")
+	}
+	var vh vhostConfig
+	for name, fv := range fields {
+		var err error
+		switch name {
+		case "root":
+			vh.Root, err = configString(fv)
+		case "index":
+			vh.Index, err = configString(fv)
+		case "spa_fallback":
+			vh.SPAFallback, err = configString(fv)
+		case "log":
+			vh.LogFile, err = configString(fv)
+		case "autoindex":
+			err = boolSetting(name, &vh.Autoindex).set(fv)
+		case "pretty_urls":
+			err = boolSetting(name, &vh.PrettyURLs).set(fv)
+		case "case_insensitive":
+			err = boolSetting(name, &vh.CaseFold).set(fv)
+		case "aliases":
+			err = stringListSetting(name, &vh.Aliases).set(fv)
+		case "error_pages":
+			vh.ErrorPages, err = parseErrorPages(fv)
+		default:
+			err = fmt.Errorf("unknown setting %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if vh.Root == "" {
+		return fmt.Errorf("root is required")
+	}
+	if vh.Index != "" && !plainFileName(vh.Index) {
+		return fmt.Errorf("index %q: must be a plain file name", vh.Index)
+	}
+	Vhosts[strings.ToLower(host)] = vh
+	return nil
+}
+
+// vhostsForConfig is the get side of the vhosts setting.
+func vhostsForConfig() any {
+	out := make(map[string]any, len(Vhosts))
+	for name, v := range Vhosts {
+		pages := make(map[string]string, len(v.ErrorPages))
+		for code, page := range v.ErrorPages {
+			pages[strconv.Itoa(code)] = page
+		}
+		out[name] = map[string]any{
+			"root": v.Root, "index": v.Index, "spa_fallback": v.SPAFallback, "autoindex": v.Autoindex,
+			"pretty_urls": v.PrettyURLs, "case_insensitive": v.CaseFold, "error_pages": pages, "log": v.LogFile, "aliases": v.Aliases,
+		}
+	}
+	return out
+}
@@ -0,0 +1,343 @@
+// watermark.go
+
+package main
+
+import (
+	"bytes"           //finding insertion points
+	"crypto/aes"      //sealing the mark
+	"crypto/cipher"   //sealing the mark
+	"crypto/hmac"     //deterministic nonces
+	"crypto/sha256"   //keys + nonces
+	"encoding/base64" //mark text
+	"encoding/hex"    //ETag suffix
+	"errors"          //decoding failures
+	"flag"            //subcommand flags
+	"fmt"             //errors + report lines
+	"image"           //PNG pixels
+	"image/draw"      //converting to NRGBA
+	"image/png"       //PNG decoding + encoding
+	"io"              //discarding log output
+	"log"             //logger for the loaders
+	"mime"            //content type parsing
+	"os"              //reading leaked copies
+	"regexp"          //finding marks in text
+	"strings"         //parsing
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  Watermarks on gated downloads
+//    - With WatermarkTypes (config "watermark.types") set, a file
+//      behind sidecar auth (see sidecar.go) of one of those types is
+//      served with an invisible mark naming the user who fetched it,
+//      so a leaked copy can be traced back to the account:
+//        html  <!-- hxwm1.… --> before </body>
+//        pdf   a %hxwm1.… comment line before the final %%EOF;
+//              readers skip comments and no offsets move
+//        png   the mark in the low bit of the blue channel of the
+//              first pixels, re-encoded losslessly (16-bit images
+//              come out 8-bit)
+//    - The mark is the user and the path, encrypted with a key from
+//      WatermarkSecret (config "watermark.secret", may be a file:,
+//      env: or vault: reference). Without the secret a mark is
+//      opaque; nobody can forge one for another user.
+//    - The same user and file always get the same bytes, so Range
+//      requests and resumed downloads fit together. The ETag gets a
+//      per-user suffix and the response "Cache-Control: private".
+//    - "helix watermark [flags] FILE..." reads the marks back, with
+//      the same configuration (the secret must be the one the copy
+//      was served with).
+//    - Marks only survive exact copies: re-saving a PDF, editing the
+//      page source or re-encoding, resizing or screenshotting an
+//      image removes them. A file that can't take a mark (a PNG too
+//      small, one that doesn't decode) is served unmarked and logged.
+// ─────────────────────────────────────────────────────────────────
+
+var (
+	WatermarkTypes  []string //"html", "pdf", "png"; empty = off
+	WatermarkSecret = ""
+)
+
+const markPrefix = "hxwm1."
+
+// watermarkTypes maps WatermarkTypes names to the media types they mark.
+var watermarkTypes = map[string]string{
+	"html": "text/html",
+	"pdf":  "application/pdf",
+	"png":  "image/png",
+}
+
+var markPattern = regexp.MustCompile(`hxwm1\.[A-Za-z0-9_-]+`)
+
+type watermark struct {
+	kind string //a watermarkTypes key
+	user string
+	mark string //markPrefix + sealed user and path
+}
+
+// checkWatermark rejects unknown types and types without a secret.
+func checkWatermark() error {
+	for _, kind := range WatermarkTypes {
+		if _, ok := watermarkTypes[kind]; !ok {
+			return fmt.Errorf("watermark.types %q: expected html, pdf or png", kind)
+		}
+	}
+	if len(WatermarkTypes) > 0 && WatermarkSecret == "" {
+		return errors.New("watermark.types needs watermark.secret")
+	}
+	return nil
+}
+
+// watermarkFor returns the mark for req's copy of the file, or nil if
+// it gets none: not a watermarked type, or not behind auth.
+func watermarkFor(req *request, contentType string, meta *sidecar) *watermark {
+	if len(WatermarkTypes) == 0 || meta == nil || meta.Auth == nil {
+		return nil
+	}
+	user, _, ok := basicAuth(req.header.Get("Authorization")) //authorize() checked the password
+	if !ok {
+		return nil
+	}
+	if meta.ContentType != "" {
+		contentType = meta.ContentType
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, kind := range WatermarkTypes {
+		if watermarkTypes[kind] == mediaType {
+			return &watermark{kind: kind, user: user, mark: sealMark(user, req.path())}
+		}
+	}
+	return nil
+}
+
+// etag is the file's ETag made unique to this copy.
+func (wm *watermark) etag(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(wm.mark))
+	return etag[:len(etag)-1] + "-" + hex.EncodeToString(sum[:4]) + `"`
+}
+
+// apply returns body with the mark in it, or body as it is if it
+// can't take one.
+func (wm *watermark) apply(body []byte, localPath string) []byte {
+	var marked []byte
+	var err error
+	switch wm.kind {
+	case "html":
+		marked = markHTML(body, wm.mark)
+	case "pdf":
+		marked, err = markPDF(body, wm.mark)
+	case "png":
+		marked, err = markPNG(body, wm.mark)
+	}
+	if err != nil {
+		logWarnf("watermark", "Serving %s unmarked: %v", localPath, err)
+		incCounter("watermarks", []string{"type:" + wm.kind, "result:failed"}, 1)
+		return body
+	}
+	logDebugf("watermark", "Marked %s for %s", localPath, wm.user)
+	incCounter("watermarks", []string{"type:" + wm.kind, "result:marked"}, 1)
+	return marked
+}
+
+// setWatermarkHeaders keeps shared caches from handing one user's
+// copy to another; a no-op for wm == nil.
+func setWatermarkHeaders(h Header, wm *watermark) {
+	if wm == nil {
+		return
+	}
+	if cc := strings.ToLower(h.Get("Cache-Control")); !strings.Contains(cc, "private") && !strings.Contains(cc, "no-store") {
+		h.Set("Cache-Control", "private")
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Sealing
+// ─────────────────────────────────────────────────────────────────
+
+func watermarkCipher() cipher.AEAD {
+	key := sha256.Sum256([]byte("helix-watermark-v1\n" + secret(WatermarkSecret)))
+	block, _ := aes.NewCipher(key[:]) //a 32-byte key can't fail
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// sealMark encrypts user and path into a mark. The nonce comes from
+// them too, so the mark is the same every time.
+func sealMark(user, path string) string {
+	aead := watermarkCipher()
+	plain := []byte(user + "\x00" + path)
+	mac := hmac.New(sha256.New, []byte(secret(WatermarkSecret)))
+	mac.Write(plain)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	return markPrefix + base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil))
+}
+
+// openMark is sealMark backwards.
+func openMark(mark string) (user, path string, err error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(mark, markPrefix))
+	if err != nil {
+		return "", "", err
+	}
+	aead := watermarkCipher()
+	if len(sealed) < aead.NonceSize() {
+		return "", "", errors.New("mark too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", "", errors.New("doesn't open with this watermark.secret")
+	}
+	user, path, _ = strings.Cut(string(plain), "\x00")
+	return user, path, nil
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  Embedding
+// ─────────────────────────────────────────────────────────────────
+
+func markHTML(body []byte, mark string) []byte {
+	comment := []byte("<!-- " + mark + " -->\n")
+	at := len(body) - len("</body")
+	for at >= 0 && !bytes.EqualFold(body[at:at+len("</body")], []byte("</body")) {
+		at--
+	}
+	if at < 0 {
+		return append(append([]byte{}, body...), comment...)
+	}
+	marked := make([]byte, 0, len(body)+len(comment))
+	marked = append(marked, body[:at]...)
+	marked = append(marked, comment...)
+	return append(marked, body[at:]...)
+}
+
+func markPDF(body []byte, mark string) ([]byte, error) {
+	at := bytes.LastIndex(body, []byte("%%EOF"))
+	if at < 0 {
+		return nil, errors.New("no %%EOF, not a PDF")
+	}
+	line := []byte("%" + mark + "\n")
+	marked := make([]byte, 0, len(body)+len(line))
+	marked = append(marked, body[:at]...)
+	marked = append(marked, line...)
+	return append(marked, body[at:]...), nil
+}
+
+// markPNG hides a 2-byte length and the mark in the low bits of the
+// blue channel, one bit per pixel, row by row from the top left.
+func markPNG(body []byte, mark string) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	payload := append([]byte{byte(len(mark) >> 8), byte(len(mark))}, mark...)
+	bounds := img.Bounds()
+	if bounds.Dx()*bounds.Dy() < len(payload)*8 {
+		return nil, fmt.Errorf("%dx%d is too small for a mark", bounds.Dx(), bounds.Dy())
+	}
+	rgba := toNRGBA(img)
+	for i := 0; i < len(payload)*8; i++ {
+		bit := payload[i/8] >> (7 - i%8) & 1
+		rgba.Pix[i*4+2] = rgba.Pix[i*4+2]&^1 | bit
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, rgba); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// toNRGBA returns img as 8-bit NRGBA, img itself if it already is.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if rgba, ok := img.(*image.NRGBA); ok {
+		return rgba
+	}
+	rgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// findMarks returns the marks in a copy of a file, text or PNG.
+func findMarks(data []byte) []string {
+	marks := markPattern.FindAllString(string(data), -1)
+	if len(marks) > 0 {
+		return marks
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	bounds := img.Bounds()
+	rgba := toNRGBA(img)
+	readBytes := func(from, n int) []byte {
+		if (from+n)*8 > bounds.Dx()*bounds.Dy() {
+			return nil
+		}
+		out := make([]byte, n)
+		for i := 0; i < n*8; i++ {
+			out[i/8] |= rgba.Pix[(from*8+i)*4+2] & 1 << (7 - i%8)
+		}
+		return out
+	}
+	size := readBytes(0, 2)
+	if size == nil {
+		return nil
+	}
+	mark := string(readBytes(2, int(size[0])<<8|int(size[1])))
+	if !markPattern.MatchString(mark) {
+		return nil
+	}
+	return []string{mark}
+}
+
+// ─────────────────────────────────────────────────────────────────
+//  helix watermark [flags] FILE...
+//    - Prints who each copy was served to. Takes the server's flags
+//      so -config and -profile find the same watermark.secret.
+// ─────────────────────────────────────────────────────────────────
+
+func runWatermark(args []string) int {
+	fs := flag.NewFlagSet("watermark", flag.ContinueOnError)
+	defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Println("Usage: helix watermark [flags] FILE...")
+		return 2
+	}
+	logWriter = log.New(io.Discard, "", 0) //the loaders log, we report
+
+	if err := loadSettings(fs); err != nil {
+		fmt.Println("Invalid configuration:", err)
+		return 1
+	}
+	if WatermarkSecret == "" {
+		fmt.Println("No watermark.secret configured; pass -config")
+		return 1
+	}
+	status := 0
+	for _, name := range fs.Args() {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			status = 1
+			continue
+		}
+		marks := findMarks(data)
+		if len(marks) == 0 {
+			fmt.Printf("%s: no watermark\n", name)
+			status = 1
+		}
+		for _, mark := range marks {
+			user, path, err := openMark(mark)
+			if err != nil {
+				fmt.Printf("%s: %s %v\n", name, mark, err)
+				status = 1
+				continue
+			}
+			fmt.Printf("%s: served to %q as %s\n", name, user, path)
+		}
+	}
+	return status
+}
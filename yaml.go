@@ -0,0 +1,278 @@
+// yaml.go
+
+package main
+
+import (
+	"fmt"     //errors with line numbers
+	"strconv" //double-quoted scalars
+	"strings" //line handling
+)
+
+// ─────────────────────────────────────────────────────────────────
+//  A small YAML subset, enough for helix.yaml without a dependency:
+//    - mappings ("key: value"), nested by indentation (spaces only)
+//    - block lists ("- item") and flow lists ("[a, b]") of scalars
+//    - plain, 'single' and "double" quoted scalars and keys, #
+//      comments; inside quotes ":", "," and "#" are just text
+//  Scalars stay strings; the config code converts them. Anchors,
+//  multi-line strings, flow mappings, nested flow lists and lists of
+//  mappings are not supported and are reported as errors rather than
+//  misread.
+// ─────────────────────────────────────────────────────────────────
+
+type yamlLine struct {
+	num    int //1-based, for errors
+	indent int
+	text   string //without indentation and comment
+}
+
+func parseYAML(data string) (map[string]any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		text := strings.TrimSpace(stripYAMLComment(trimmed))
+		if text == "" || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: text})
+	}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("line %d: the top level must be a mapping", lines[0].num)
+	}
+	return m, nil
+}
+
+// stripYAMLComment cuts a "#" comment that isn't inside quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// block parses the mapping or list whose lines are indented by indent.
+func (p *yamlParser) block(indent int) (any, error) {
+	if strings.HasPrefix(p.lines[p.pos].text, "- ") || p.lines[p.pos].text == "-" {
+		return p.list(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) list(indent int) (any, error) {
+	var items []any
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		item, ok := strings.CutPrefix(l.text, "-")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a list item", l.num)
+		}
+		item = strings.TrimSpace(item)
+		if item == "" || (strings.Contains(item, ": ") && !isQuoted(item)) || strings.HasSuffix(item, ":") {
+			return nil, fmt.Errorf("line %d: only lists of plain values are supported", l.num)
+		}
+		v, err := yamlScalar(item, l.num)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		p.pos++
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		key, value, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.HasPrefix(key, `"`) || strings.HasPrefix(key, "'") {
+			unq, err := yamlScalar(key, l.num)
+			if err != nil {
+				return nil, err
+			}
+			key = unq.(string)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: %q appears twice", l.num, key)
+		}
+		p.pos++
+
+		if value != "" {
+			v, err := yamlScalar(value, l.num)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		//Nested block, or an empty value
+		if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		//"key:" followed by a list at the same indentation is valid YAML
+		if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && strings.HasPrefix(p.lines[p.pos].text, "- ") {
+			v, err := p.list(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		m[key] = ""
+	}
+	return m, nil
+}
+
+// splitYAMLKey splits "key: value" at the colon that ends the key: the
+// first one followed by a space or the end of the line, or for a
+// quoted key the one after the closing quote.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	from := 0
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text, 0)
+		if end < 0 {
+			return "", "", false
+		}
+		from = end + 1
+	}
+	for i := from; i < len(text); i++ {
+		if text[i] != ':' {
+			continue
+		}
+		if i+1 == len(text) || text[i+1] == ' ' {
+			return text[:i], text[i+1:], true
+		}
+		if from > 0 {
+			break //"quoted":value
+		}
+	}
+	return "", "", false
+}
+
+// closingQuote returns the index of the quote closing the one at
+// s[open], -1 if there is none. Inside double quotes a backslash
+// escapes, inside single quotes a doubled quote does.
+func closingQuote(s string, open int) int {
+	quote := s[open]
+	for i := open + 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitFlowList splits the inside of "[a, b]" at the commas that
+// aren't inside a quoted item.
+func splitFlowList(inner string, line int) ([]string, error) {
+	var parts []string
+	start := 0
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case (c == '"' || c == '\'') && strings.TrimSpace(inner[start:i]) == "":
+			end := closingQuote(inner, i)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted string in list", line)
+			}
+			i = end
+		case c == ',':
+			parts = append(parts, inner[start:i])
+			start = i + 1
+		case c == '[' || c == ']' || c == '{' || c == '}':
+			return nil, fmt.Errorf("line %d: nested lists and mappings are not supported in helix.yaml", line)
+		}
+	}
+	return append(parts, inner[start:]), nil
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'')
+}
+
+// yamlScalar turns a value into a string, or a []any for a flow list.
+func yamlScalar(s string, line int) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("line %d: unterminated list", line)
+		}
+		items := []any{}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return items, nil
+		}
+		parts, err := splitFlowList(inner, line)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts {
+			v, err := yamlScalar(strings.TrimSpace(part), line)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case strings.HasPrefix(s, "{"), strings.HasPrefix(s, "&"), strings.HasPrefix(s, "*"), s == "|", s == ">":
+		return nil, fmt.Errorf("line %d: %q is not supported in helix.yaml", line, s)
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad quoted string %s", line, s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("line %d: bad quoted string %s", line, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s == "~" || s == "null":
+		return "", nil
+	}
+	return s, nil
+}